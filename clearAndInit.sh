@@ -10,3 +10,17 @@ rm -rf /tmp/badger
 mkdir -p /tmp/badger/internal
 mkdir -p /tmp/badger/utxo
 mkdir -p /tmp/badger/debt
+mkdir -p /tmp/badger/loan
+mkdir -p /tmp/badger/pool
+mkdir -p /tmp/badger/registry
+mkdir -p /tmp/badger/attestation
+mkdir -p /tmp/badger/oracle
+mkdir -p /tmp/badger/state
+
+# register the demo bank (keys/bankseed.dat, read by the client demo) as
+# lender and KYC provider in the genesis app_state
+if [ -f keys/bankseed.dat ]; then
+	BANKKEY=$(go run ./cmd/client lenderkey keys/bankseed.dat)
+	APPSTATE="{\"Lenders\": [\"$BANKKEY\"], \"Attesters\": {\"kyc\": [\"$BANKKEY\"]}}"
+	sed -i "s|\"app_hash\": \"\"|\"app_hash\": \"\",\n  \"app_state\": $APPSTATE|" /tmp/debtchain/config/genesis.json
+fi
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"debtchain/internal/envelope"
+	"debtchain/internal/wallet"
+	"debtchain/pkg/utxi"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+const nodeAddress = "http://localhost:26657"
+
+/*
+	Commands besides the default demo:
+
+		client viewkey <seedfile>	print the wallet's view key to hand to an auditor
+		client scan <viewkey>		decrypt the output memos readable with a view key
+		client stealthaddress <seedfile>	print the wallet's stealth address to publish to lenders
+		client stealthscan <seedfile>	list the stealth outputs paid to the wallet
+		client lenderkey <seedfile>	print the wallet's lender (and governor) key for the genesis app_state
+		client memo <seedfile> <key> <txhash>	decrypt the memo of a transaction paid to the wallet's child key
+		client query <path> [height]	print the response to a query path, e.g. /debt/total, at the last or a past height
+		client prove <path> [validatorshash [height]]	print the value at a path once its proof is verified
+*/
+func runCommand(args []string) error {
+	switch args[0] {
+	case "viewkey":
+		if len(args) != 2 {
+			return errors.New("usage: client viewkey <seedfile>")
+		}
+		w, err := wallet.NewWallet(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString(w.ViewKey().Serialize()))
+		return nil
+	case "scan":
+		if len(args) != 2 {
+			return errors.New("usage: client scan <viewkey>")
+		}
+		keyBytes, err := hex.DecodeString(args[1])
+		if err != nil {
+			return err
+		}
+		viewKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), keyBytes)
+		return scan(viewKey)
+	case "stealthaddress", "stealthscan":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: client %v <seedfile>", args[0])
+		}
+		w, err := wallet.NewWallet(args[1])
+		if err != nil {
+			return err
+		}
+		if args[0] == "stealthaddress" {
+			fmt.Println(w.StealthAddress().String())
+			return nil
+		}
+		return stealthScan(w)
+	case "lenderkey":
+		if len(args) != 2 {
+			return errors.New("usage: client lenderkey <seedfile>")
+		}
+		w, err := wallet.NewWallet(args[1])
+		if err != nil {
+			return err
+		}
+		lenderKey, _ := w.PublicKey(1)
+		// genesis JSON encodes keys in standard base64
+		fmt.Println(base64.StdEncoding.EncodeToString(lenderKey))
+		return nil
+	case "memo":
+		if len(args) != 4 {
+			return errors.New("usage: client memo <seedfile> <key> <txhash>")
+		}
+		w, err := wallet.NewWallet(args[1])
+		if err != nil {
+			return err
+		}
+		which, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil {
+			return err
+		}
+		txHash, err := base64.URLEncoding.DecodeString(args[3])
+		if err != nil {
+			return err
+		}
+		return readMemo(w, uint32(which), txHash)
+	case "query":
+		if len(args) != 2 && len(args) != 3 {
+			return errors.New("usage: client query <path> [height]")
+		}
+		var height int64
+		if len(args) == 3 {
+			var err error
+			height, err = strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+		value, err := abciQueryAt(args[1], nil, height)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(value))
+		return nil
+	case "prove":
+		if len(args) < 2 || len(args) > 4 {
+			return errors.New("usage: client prove <path> [validatorshash [height]]")
+		}
+		var anchor trustAnchor
+		if len(args) >= 3 {
+			var err error
+			anchor.Validators, err = parseValidatorsHash(args[2])
+			if err != nil {
+				return err
+			}
+		}
+		if len(args) == 4 {
+			var err error
+			anchor.Height, err = strconv.ParseInt(args[3], 10, 64)
+			if err != nil || anchor.Height < 1 {
+				return fmt.Errorf("invalid height %q", args[3])
+			}
+		}
+		value, err := proveQuery(args[1], anchor)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(value))
+		return nil
+	}
+	return fmt.Errorf("unknown command %v", args[0])
+}
+
+// abciQuery runs an ABCI query through the node's RPC and returns the response value
+func abciQuery(path string, data []byte) ([]byte, error) {
+	return abciQueryAt(path, data, 0)
+}
+
+// abciQueryAt queries the state after a past height; height 0 is the last one
+func abciQueryAt(path string, data []byte, height int64) ([]byte, error) {
+	query := url.Values{}
+	query.Set("path", fmt.Sprintf("\"%v\"", path))
+	if len(data) > 0 {
+		query.Set("data", "0x"+hex.EncodeToString(data))
+	}
+	if height != 0 {
+		query.Set("height", strconv.FormatInt(height, 10))
+	}
+	resp, err := http.Get(nodeAddress + "/abci_query?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	resBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Result struct {
+			Response struct {
+				Code		uint32
+				Codespace	string
+				Log			string
+				Value		string
+			}
+		}
+	}
+	err = json.Unmarshal(resBytes, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Result.Response.Code != 0 {
+		return nil, envelope.Decode(res.Result.Response.Codespace, res.Result.Response.Code, res.Result.Response.Log)
+	}
+	return base64.StdEncoding.DecodeString(res.Result.Response.Value)
+}
+
+// broadcastError returns the error of a broadcast_tx_commit response, from
+// CheckTx or else DeliverTx, mapped back to the registered errors.
+func broadcastError(resBytes []byte) error {
+	type txResult struct {
+		Code		uint32
+		Codespace	string
+		Log			string
+	}
+	var res struct {
+		Result struct {
+			CheckTx		txResult	`json:"check_tx"`
+			DeliverTx	txResult	`json:"deliver_tx"`
+		}
+	}
+	err := json.Unmarshal(resBytes, &res)
+	if err != nil {
+		return err
+	}
+	if res.Result.CheckTx.Code != 0 {
+		return envelope.Decode(res.Result.CheckTx.Codespace, res.Result.CheckTx.Code, res.Result.CheckTx.Log)
+	}
+	return envelope.Decode(res.Result.DeliverTx.Codespace, res.Result.DeliverTx.Code, res.Result.DeliverTx.Log)
+}
+
+func scan(viewKey *btcec.PrivateKey) error {
+	memosAsJson, err := abciQuery("memos", nil)
+	if err != nil {
+		return err
+	}
+	var memoOutputs []utxi.RecordedOutput
+	err = json.Unmarshal(memosAsJson, &memoOutputs)
+	if err != nil {
+		return err
+	}
+	for _, memoOutput := range memoOutputs {
+		// memos sealed to other view keys fail to decrypt
+		memo, err := utxi.OpenOutputMemo(viewKey, memoOutput.Output.Memo)
+		if err != nil {
+			continue
+		}
+		fmt.Printf("Txid: %v Vout: %v\n", base64.URLEncoding.EncodeToString(memoOutput.Txid), memoOutput.Vout)
+		fmt.Printf("    Recipient: %v\n", memoOutput.Output.RecipientAddrStr())
+		fmt.Printf("    Value: %v\n", memo.Value)
+		if memo.Terms != nil {
+			fmt.Printf("    Terms: %+v\n", *memo.Terms)
+		}
+	}
+	return nil
+}
+
+func stealthScan(w *wallet.Wallet) error {
+	outputsAsJson, err := abciQuery("stealth", nil)
+	if err != nil {
+		return err
+	}
+	var outputs []utxi.RecordedOutput
+	err = json.Unmarshal(outputsAsJson, &outputs)
+	if err != nil {
+		return err
+	}
+	for _, owned := range w.FindStealthOutputs(outputs) {
+		fmt.Printf("Txid: %v Vout: %v\n", base64.URLEncoding.EncodeToString(owned.Txid), owned.Vout)
+		fmt.Printf("    Address: %v\n", owned.Output.RecipientAddrStr())
+		fmt.Printf("    Value: %v\n", owned.Output.Value)
+	}
+	return nil
+}
+
+func readMemo(w *wallet.Wallet, which uint32, txHash []byte) error {
+	txAsJson, err := abciQuery("tx", txHash)
+	if err != nil {
+		return err
+	}
+	var tx utxi.Transaction
+	err = json.Unmarshal(txAsJson, &tx)
+	if err != nil {
+		return err
+	}
+	memo, err := w.OpenTransactionMemo(which, tx)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(memo))
+	return nil
+}
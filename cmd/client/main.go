@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"debtchain/internal/envelope"
 	"debtchain/internal/wallet"
@@ -17,6 +19,15 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		err := runCommand(os.Args[1:])
+		if err != nil {
+			fmt.Println("error: ", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// load keys
 	bankwallet, err := wallet.NewWallet("../../keys/bankseed.dat")
 	if err != nil {
@@ -40,7 +51,21 @@ func main() {
 	
 	clientAddress, clientAddressString := clientWallet.NewPublicKey()
 
+	// debt is only issued to attested borrowers; clearAndInit.sh registers the
+	// bank as lender and KYC provider, so it attests the client itself
+	attestation := bankwallet.ConstructAttestation(utxi.AttestKYC, clientAddress, time.Now().AddDate(1, 0, 0).Unix())
+	resBytes, err := broadcastCommand("Attestation", clientAddressString, attestation)
+	if err != nil {
+		fmt.Println("Attestation failed: ", err)
+		return
+	}
+	fmt.Println("attestation resbytes: ", string(resBytes))
+
 	debtTx := bankwallet.ConstructDebtTransaction(clientAddress, 50)
+	// the details of the loan can be read with the client's view key
+	debtTx.Outputs[0].Memo, _ = utxi.SealOutputMemo(clientWallet.ViewPublicKey(), utxi.OutputMemo{Value: 50})
+	// the lender signs the whole transaction, memo included
+	bankwallet.SignTransaction(1, &debtTx)
 
 	debtTxbytes, _ := json.Marshal(debtTx)
 	debtTxbytesbase64 := base64.RawURLEncoding.EncodeToString(debtTxbytes)
@@ -75,14 +100,21 @@ func main() {
 	}
 	defer resp.Body.Close()	
 	
-	resBytes, _ := ioutil.ReadAll(resp.Body)
+	resBytes, _ = ioutil.ReadAll(resp.Body)
 	fmt.Println("resbytes: ", string(resBytes))
+	if err := broadcastError(resBytes); err != nil {
+		fmt.Println("IssueDebt failed: ", err)
+	}
 
 	// for illustrative purposes we construct transactions from both a reverse mortgage issuer's 
 	// perspetive and from a user perspective. 
 	// in a production setting, there would be multiple clients connecting to the blockchain backend
 	odtx := utxi.MakeOutstandingDebtTx(debtTx)
-	repaymentTx := clientWallet.ConstructRepaymentTransaction(bankAddress,25,odtx,0)
+	repaymentTx := clientWallet.ConstructRepaymentTransaction(1,bankAddress,25,odtx,0)
+	// the bank reads the reference with `client memo <bankseed> 1 <txhash>`
+	repaymentTx.AttachMemo([]byte("loan " + base64.URLEncoding.EncodeToString(odtx.Hash())))
+	// the borrower signs the memo too
+	clientWallet.SignTransaction(1, &repaymentTx)
 	repaymentTxbytes, _ := json.Marshal(repaymentTx)
 	repaymentTxbytes64 := base64.RawURLEncoding.EncodeToString(repaymentTxbytes)
 
@@ -124,6 +156,38 @@ func main() {
 
 	resBytes, _ = ioutil.ReadAll(resp2.Body)
 	fmt.Println("resbytes2: ", string(resBytes))
+	if err := broadcastError(resBytes); err != nil {
+		fmt.Println("Repayment failed: ", err)
+	}
+}
+
+// broadcastCommand commits a command and returns the node's response, or the
+// error it reports.
+func broadcastCommand(command string, address string, tx interface{}) ([]byte, error) {
+	txbytes, err := json.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+	cmd := envelope.Command{
+		Command: command,
+		Address: address,
+		Transaction: base64.RawURLEncoding.EncodeToString(txbytes),
+	}
+	cmdbytes, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	bodyString := fmt.Sprintf("{\"jsonrpc\":\"2.0\",\"id\":\"anything\",\"method\":\"broadcast_tx_commit\",\"params\": {\"tx\": \"%v\"}}", base64.RawURLEncoding.EncodeToString(cmdbytes))
+	resp, err := http.Post(nodeAddress, "text/plain;", strings.NewReader(bodyString))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	resBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return resBytes, broadcastError(resBytes)
 }
 
 // the http get request is another way to connect to tendermint
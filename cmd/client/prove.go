@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"debtchain/pkg/smt"
+	"debtchain/internal/envelope"
+
+	"github.com/tendermint/tendermint/crypto/merkle"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	rpchttp "github.com/tendermint/tendermint/rpc/client/http"
+	"github.com/tendermint/tendermint/types"
+)
+
+/*
+	Proven queries. The node answers a path reading a single key with the
+	stored value and a proof of it against the app hash of the queried
+	height, which is committed in the header of the next block. The header
+	is trusted once it is signed by more than two thirds of a validator set
+	the caller trusts. Trust starts from a validator set hash at a height:
+	the genesis validator set at height 1 by default, or the hash given on
+	the command line, at the given height or else at the header's. From
+	there every header up to the queried one is checked in turn, each
+	signed by the validators the previous header names as the next ones,
+	so validator set changes are followed.
+*/
+
+// trustAnchor is a validator set hash trusted at a height; a zero height
+// trusts the set at whichever height is checked.
+type trustAnchor struct {
+	Validators	[]byte
+	Height		int64
+}
+
+// proveQuery queries a path with a proof and returns the value once it is
+// verified against a header signed by validators followed from the anchor.
+func proveQuery(path string, anchor trustAnchor) ([]byte, error) {
+	node, err := rpchttp.New(nodeAddress, "/websocket")
+	if err != nil {
+		return nil, err
+	}
+	res, err := node.ABCIQueryWithOptions(path, nil, rpcclient.ABCIQueryOptions{Prove: true})
+	if err != nil {
+		return nil, err
+	}
+	response := res.Response
+	if response.Code != 0 {
+		return nil, envelope.Decode(response.Codespace, response.Code, response.Log)
+	}
+	if response.Proof == nil || len(response.Proof.Ops) != 2 {
+		return nil, errors.New("response carries no proof")
+	}
+
+	// the app hash after the queried height is in the header of the next one
+	height := response.Height + 1
+	header, err := trustedHeader(node, height, anchor)
+	if err != nil {
+		return nil, err
+	}
+	keyPath := merkle.KeyPath{}.
+		AppendKey(response.Proof.Ops[1].Key, merkle.KeyEncodingURL).
+		AppendKey(response.Key, merkle.KeyEncodingHex)
+	err = smt.ProofRuntime().VerifyValue(response.Proof, header.AppHash, keyPath.String(), response.Value)
+	if err != nil {
+		return nil, fmt.Errorf("proof does not match the app hash at height %v: %w", height, err)
+	}
+	return response.Value, nil
+}
+
+// trustedHeader returns the header at a height after checking the commit
+// of every header from the anchor's height to it.
+func trustedHeader(node *rpchttp.HTTP, height int64, anchor trustAnchor) (*types.Header, error) {
+	var err error
+	if anchor.Validators == nil {
+		anchor.Validators, err = genesisValidators(node)
+		if err != nil {
+			return nil, err
+		}
+		anchor.Height = 1
+	}
+	if anchor.Height == 0 {
+		anchor.Height = height
+	}
+	if anchor.Height > height {
+		return nil, fmt.Errorf("trusted height %v is after height %v", anchor.Height, height)
+	}
+	chainID := ""
+	trusted := anchor.Validators
+	for h := anchor.Height; ; h++ {
+		header, err := signedHeader(node, h, chainID, trusted)
+		if err != nil {
+			return nil, err
+		}
+		if h == height {
+			return header, nil
+		}
+		chainID = header.ChainID
+		// the header commits to the validators of the next height
+		trusted = header.NextValidatorsHash
+	}
+}
+
+// signedHeader returns the header at a height after checking its commit
+// against the validator set, which must hash to trustedValidators. An
+// empty chainID takes the chain id of the header.
+func signedHeader(node *rpchttp.HTTP, height int64, chainID string, trustedValidators []byte) (*types.Header, error) {
+	commit, err := node.Commit(&height)
+	if err != nil {
+		return nil, fmt.Errorf("no commit at height %v yet: %w", height, err)
+	}
+	if chainID == "" {
+		chainID = commit.Header.ChainID
+	}
+	err = commit.SignedHeader.ValidateBasic(chainID)
+	if err != nil {
+		return nil, err
+	}
+	validators, err := validatorSet(node, height)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(validators.Hash(), trustedValidators) {
+		return nil, fmt.Errorf("validators at height %v are not the trusted set", height)
+	}
+	if !bytes.Equal(commit.Header.ValidatorsHash, validators.Hash()) {
+		return nil, fmt.Errorf("header at height %v names other validators", height)
+	}
+	err = validators.VerifyCommit(chainID, commit.Commit.BlockID, height, commit.Commit)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Header, nil
+}
+
+func validatorSet(node *rpchttp.HTTP, height int64) (*types.ValidatorSet, error) {
+	var validators []*types.Validator
+	for page := 1; ; page++ {
+		res, err := node.Validators(&height, page, 100)
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, res.Validators...)
+		if len(res.Validators) == 0 || len(validators) >= res.Total {
+			break
+		}
+	}
+	return types.NewValidatorSet(validators), nil
+}
+
+// genesisValidators returns the hash of the genesis validator set.
+func genesisValidators(node *rpchttp.HTTP) ([]byte, error) {
+	res, err := node.Genesis()
+	if err != nil {
+		return nil, err
+	}
+	validators := make([]*types.Validator, 0, len(res.Genesis.Validators))
+	for _, v := range res.Genesis.Validators {
+		validators = append(validators, types.NewValidator(v.PubKey, v.Power))
+	}
+	return types.NewValidatorSet(validators).Hash(), nil
+}
+
+func parseValidatorsHash(s string) ([]byte, error) {
+	hash, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) != 32 {
+		return nil, errors.New("validators hash is 32 bytes")
+	}
+	return hash, nil
+}
@@ -1,75 +1,162 @@
 package main
 
 import (
-	"encoding/binary"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"strconv"
 
+	"debtchain/pkg/smt"
+	"debtchain/pkg/sumtree"
 	"debtchain/pkg/utxi"
 	"debtchain/internal/envelope"
 
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/dgraph-io/badger/v2"
 	abcitypes "github.com/tendermint/tendermint/abci/types"
-	"github.com/tendermint/tmlibs/merkle"
 )
 
-const (
-	codeTypeOK            uint32 = 0
-	codeTypeEncodingError uint32 = 1
-	codeTypeTicketError   uint32 = 2
-)
+// failures are reported with the codes registered in envelope
+const codeTypeOK uint32 = 0
 
 /*
 	Home Equity Loan Backend (HELB)
 */
 type HELB struct {
-	transactions	*badger.DB
-	utxoPool		*badger.DB
-	debtPool		*badger.DB
-	currentBatch	*badger.Txn
+	transactions	*Store
+	utxoPool		*Store
+	debtPool		*Store
+	loans			*Store
+	loanPools		*Store
+	// authorized lenders and the governors who manage them
+	registry		*Store
+	attestations	*Store
+	// oracle appraisals and collateral values
+	oracles			*Store
+	// last committed height and app hash, kept out of the app hash itself
+	state			*badger.DB
+	// opens confidential outputs for system totals; nil if not configured
+	auditKey		*btcec.PrivateKey
+	// block writes staged until Commit
+	currentBatch	*Batch
+	// writes of the transactions admitted to the mempool since the last commit
+	checkBatch		*Batch
+	// past heights kept for queries; 0 keeps every height
+	retainHeights	int64
+	// last committed height
 	height			int64
+	// time of the current block, unix seconds
+	blockTime		int64
 	lastHash		[]byte
-	merkletree 		[]merkle.Hasher
-}
-
-type ByteWrapper []byte
-
-func (st ByteWrapper) Hash() []byte {
-	hasher := sha256.New()
-	hasher.Write(st)
-	return hasher.Sum(nil)
+	// sparse Merkle trees of the state stores as of the last commit, by store name
+	trees			map[string]*smt.Tree
+	// root of the liabilities sum tree as of the last commit
+	liabilitiesRoot	sumtree.Node
 }
 
 var _ abcitypes.Application = (*HELB)(nil)
 
-func NewHELB(db, utxodb, debtdb *badger.DB) *HELB {
+func NewHELB(db, utxodb, debtdb, loandb, pooldb, registrydb, attestationdb, oracledb, statedb *badger.DB) *HELB {
 	return &HELB{
-		transactions: db,
-		utxoPool: utxodb,
-		debtPool: debtdb,
+		transactions: NewStore(db),
+		utxoPool: NewStore(utxodb),
+		debtPool: NewStore(debtdb),
+		loans: NewStore(loandb),
+		loanPools: NewStore(pooldb),
+		registry: NewStore(registrydb),
+		attestations: NewStore(attestationdb),
+		oracles: NewStore(oracledb),
+		state: statedb,
+		currentBatch: NewBatch(),
+		checkBatch: NewBatch(),
 		height: 0,
 	}
 }
 
 func (app *HELB) AddToDebtPool(tx utxi.Transaction) error {
-	return app.debtPool.Update(func(txn *badger.Txn) error {
+	return app.debtPool.Update(func(txn *Txn) error {
 		return txn.Set(tx.Hash(), tx.Serialize())
 	})
 }
 
+func (app *HELB) GetOutstandingDebt(id []byte) (utxi.Transaction, error) {
+	var debtTx utxi.Transaction
+	err := app.debtPool.View(func(txn *Txn) error {
+		item, err := txn.Get(id)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &debtTx)
+		})
+	})
+	return debtTx, err
+}
+
+// SetOutstandingDebt stores a debt under an explicit loan id rather than its hash
+func (app *HELB) SetOutstandingDebt(id []byte, tx utxi.Transaction) error {
+	return app.debtPool.Update(func(txn *Txn) error {
+		return txn.Set(id, tx.Serialize())
+	})
+}
+
+func (app *HELB) RemoveFromDebtPool(id []byte) error {
+	return app.debtPool.Update(func(txn *Txn) error {
+		return txn.Delete(id)
+	})
+}
+
+// loans are keyed like the debt pool, by the id of the outstanding debt transaction
+func (app *HELB) AddLoan(loan utxi.Loan) error {
+	return app.loans.Update(func(txn *Txn) error {
+		return txn.Set(loan.Id, loan.Serialize())
+	})
+}
+
+func (app *HELB) GetLoan(id []byte) (utxi.Loan, error) {
+	var loan utxi.Loan
+	err := app.loans.View(func(txn *Txn) error {
+		item, err := txn.Get(id)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &loan)
+		})
+	})
+	return loan, err
+}
+
+func (app *HELB) GetTransaction(hash []byte) (utxi.Transaction, error) {
+	var tx utxi.Transaction
+	err := app.transactions.View(func(txn *Txn) error {
+		item, err := txn.Get(hash)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &tx)
+		})
+	})
+	return tx, err
+}
+
 func (app *HELB) AddTransaction(tx utxi.Transaction) error {
-	return app.transactions.Update(func(txn *badger.Txn) error {
+	return app.transactions.Update(func(txn *Txn) error {
 		return txn.Set(tx.Hash(), tx.Serialize())
 	})
 }
 
-// we assume proper privacy guidelines are followed that addresses are not reused
+// AddRecord stores a non-UTXO transaction (pools, netting, ...) next to the regular ones
+func (app *HELB) AddRecord(hash, record []byte) error {
+	return app.transactions.Update(func(txn *Txn) error {
+		return txn.Set(hash, record)
+	})
+}
+
+// we assume proper privacy guidelines are followed that addresses are not reused;
+// stealth outputs are checked for reuse in checkStealthOutputs
 // function takes an output and adds 
 func (app *HELB) AddToUXTOPool(tx utxi.Transaction) error {
-	return app.utxoPool.Update(func(txn *badger.Txn) error {
+	return app.utxoPool.Update(func(txn *Txn) error {
 		for _, output := range tx.Outputs {
 			txn.Set(output.RecipientAddr(), output.Serialize())
 		}
@@ -77,21 +164,43 @@ func (app *HELB) AddToUXTOPool(tx utxi.Transaction) error {
 	})
 }
 
+// GetTotalCredits sums the native asset; GetTotalCreditsByAsset covers every asset.
 func (app *HELB) GetTotalCredits() (error, int) {
-	var totalCredits int
-	totalCredits = 0
+	err, totals := app.GetTotalCreditsByAsset()
+	if err != nil {
+		return err, -10
+	}
+	return nil, totals[utxi.NativeAsset]
+}
+
+func (app *HELB) GetTotalCreditsByAsset() (error, map[utxi.AssetId]int) {
+	err, totals, _ := app.totalCredits(app.outputValue)
+	return err, totals
+}
+
+// totalCredits sums the utxo pool by asset, valuing each output with value.
+// It also returns the number of outputs value could not open.
+func (app *HELB) totalCredits(value func(utxi.TxOutput) (uint64, bool)) (error, map[utxi.AssetId]int, int) {
+	totalCredits := make(map[utxi.AssetId]int)
+	unopened := 0
 	var output utxi.TxOutput
-	err := app.utxoPool.View(func(txn *badger.Txn) error {
+	err := app.utxoPool.View(func(txn *Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
 			err := item.Value(func(v []byte) error {
+				output = utxi.TxOutput{}
 				jsonErr := json.Unmarshal(v, &output)
 				if jsonErr != nil {
 					return jsonErr
 				}
-				totalCredits = totalCredits + int(output.Value)
+				amount, ok := value(output)
+				if !ok {
+					unopened++
+					return nil
+				}
+				totalCredits[output.Asset] = totalCredits[output.Asset] + int(amount)
 				return nil
 			})
 			if err != nil {
@@ -101,26 +210,53 @@ func (app *HELB) GetTotalCredits() (error, int) {
 		return nil
 	})
 	if err != nil {
-		return err, -10
+		return err, nil, 0
 	}
-	return nil, totalCredits
+	return nil, totalCredits, unopened
 }
 
+// GetTotalDebt sums the native asset; GetTotalDebtByAsset covers every asset.
 func (app *HELB) GetTotalDebt() (error, int) {
-	var debtAmt int
-	debtAmt = 0
+	err, totals := app.GetTotalDebtByAsset()
+	if err != nil {
+		return err, -10
+	}
+	return nil, totals[utxi.NativeAsset]
+}
+
+func (app *HELB) GetTotalDebtByAsset() (error, map[utxi.AssetId]int) {
+	err, totals, _ := app.totalDebt(app.outputValue)
+	return err, totals
+}
+
+// totalDebt sums the debt pool by asset, valuing each output with value.
+// It also returns the number of outputs value could not open.
+func (app *HELB) totalDebt(value func(utxi.TxOutput) (uint64, bool)) (error, map[utxi.AssetId]int, int) {
+	debtAmt := make(map[utxi.AssetId]int)
+	unopened := 0
 	var debtTx utxi.Transaction
-	err := app.debtPool.View(func(txn *badger.Txn) error {
+	err := app.debtPool.View(func(txn *Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
 			err := item.Value(func(v []byte) error {
+				debtTx = utxi.Transaction{}
 				jsonErr := json.Unmarshal(v, &debtTx)
 				if jsonErr != nil {
 					return jsonErr
 				}
-				debtAmt = debtAmt + int(debtTx.DebtIssued())
+				var amount uint64
+				for _, output := range debtTx.Outputs {
+					outputAmount, ok := value(output)
+					if !ok {
+						unopened++
+						continue
+					}
+					amount = amount + outputAmount
+				}
+				asset, _ := debtTx.Asset()
+				debtAmt[asset] = debtAmt[asset] + int(amount)
 				return nil
 			})
 			if err != nil {
@@ -130,14 +266,32 @@ func (app *HELB) GetTotalDebt() (error, int) {
 		return nil
 	})
 	if err != nil {
-		return err, -10
+		return err, nil, 0
 	}
-	return nil, debtAmt
+	return nil, debtAmt, unopened
 }
 
-func (app *HELB) HandleRepayment(rpTx utxi.Transaction) error {
-	var debtTx utxi.Transaction
-	err := app.debtPool.Update(func(txn *badger.Txn) error {
+// the outstanding debt is updated in place so the loan keeps its id across repayments;
+// a plaintext debt repaid in full is removed from the debt pool, and closed is set
+func (app *HELB) HandleRepayment(rpTx utxi.Transaction) (closed bool, err error) {
+	debtTx, err := app.GetOutstandingDebt(rpTx.Inputs[0].Txid)
+	if err != nil {
+		return false, err
+	}
+	if debtTx.Outputs[0].IsConfidential() {
+		return false, app.HandleConfidentialRepayment(rpTx, debtTx)
+	}
+	if rpTx.IsConfidential() {
+		return false, envelope.ErrInvalidTx.Wrapf("plaintext debts are repaid in plaintext")
+	}
+	// a loan is repaid in the asset it was issued in
+	for _, output := range rpTx.Outputs {
+		if output.Asset != debtTx.Outputs[0].Asset {
+			return false, envelope.ErrInvalidTx.Wrapf("repayment is not in the asset of the debt")
+		}
+	}
+
+	err = app.debtPool.Update(func(txn *Txn) error {
 		item, err := txn.Get(rpTx.Inputs[0].Txid)
 		if err != nil {
 			return err
@@ -152,248 +306,154 @@ func (app *HELB) HandleRepayment(rpTx utxi.Transaction) error {
 		if valueErr != nil {
 			return valueErr
 		}
-		txn.Delete(rpTx.Inputs[0].Txid)
+		if debtTx.Outputs[0].Value < rpTx.Outputs[0].Value {
+			return envelope.ErrInsufficientFunds.Wrapf("repayment exceeds outstanding debt")
+		}
 
 		debtTx.Outputs[0].Value = debtTx.Outputs[0].Value - rpTx.Outputs[0].Value 
-		txn.Set(debtTx.Hash(), debtTx.Serialize())
-		return nil
+		if debtTx.DebtIssued() == 0 {
+			closed = true
+			return txn.Delete(rpTx.Inputs[0].Txid)
+		}
+		return txn.Set(rpTx.Inputs[0].Txid, debtTx.Serialize())
 	})
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	return closed, nil
 }
 
 func (app *HELB) BeginBlock(req abcitypes.RequestBeginBlock) abcitypes.ResponseBeginBlock {
-	app.merkletree = make([]merkle.Hasher, app.height)
-	return abcitypes.ResponseBeginBlock{}
+	// replaying a block the stores already contain would apply it twice
+	if req.Header.Height != app.height + 1 {
+		panic(fmt.Sprintf("BeginBlock at height %v after committing height %v", req.Header.Height, app.height))
+	}
+	// block writes are staged until Commit
+	app.use(app.currentBatch)
+	// due dates and attestations are checked against the block time so every
+	// node agrees on them
+	app.blockTime = req.Header.Time.Unix()
+	app.currentBatch.Begin()
+	events, err := app.AssessLoans(app.blockTime)
+	if err == nil {
+		err = app.updateLiabilities()
+	}
+	if err != nil {
+		panic(fmt.Sprintf("AssessLoans Error: %v", err))
+	}
+	return abcitypes.ResponseBeginBlock{Events: events}
 }
 
+// CheckTx runs the decoding and the stateless and stateful checks of a
+// transaction against the check state. An admitted transaction is then
+// executed into the check state only, so a transaction conflicting with
+// one already in the mempool is rejected. The check state is reset in
+// Commit, after which Tendermint rechecks the transactions left in the
+// mempool against the new block.
 func (app *HELB) CheckTx(req abcitypes.RequestCheckTx) abcitypes.ResponseCheckTx {
-
-	var cmds envelope.Command
-	err := json.Unmarshal(req.Tx, &cmds)
-	if err != nil {
-		return abcitypes.ResponseCheckTx{
-			Code: codeTypeEncodingError,
-			Log:  fmt.Sprint(err),
-			Info: "Could not parse command JSON",
-		}
+	defer app.use(app.checkBatch)()
+	cmd, name, rejected := app.prepare(req.Tx)
+	if rejected != nil {
+		return checkTxResponse(*rejected)
 	}
-
-	switch cmds.Command {
-	case "IssueDebt":
-		var debtTx utxi.Transaction
-		debtTxBytes, _ := base64.RawURLEncoding.DecodeString(cmds.Transaction)
-		err := json.Unmarshal(debtTxBytes, &debtTx)
-
-		if err != nil {
-			errMsg := fmt.Sprint("error: %v\n", err)
-			return abcitypes.ResponseCheckTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: errMsg, 
-				Data: []byte(cmds.Transaction),
-			}
-		}
-		return abcitypes.ResponseCheckTx{
-			Code: 0, 
-			GasWanted: 1, 
-			Info: strconv.Itoa(int(debtTx.DebtIssued())), 
-			Data: []byte("Valid IssueDebt Cmd"),
-		}
-	case "Repayment":
-		var repaymentTx utxi.Transaction
-		repaymentTxBytes, _ := base64.RawURLEncoding.DecodeString(cmds.Transaction)
-		err := json.Unmarshal(repaymentTxBytes, &repaymentTx)
-
+	app.checkBatch.Begin()
+	res := app.run(cmd)
+	if res.Code != codeTypeOK {
+		err := app.checkBatch.Rollback()
 		if err != nil {
-			errMsg := fmt.Sprint("error: %v\n", err)
-			return abcitypes.ResponseCheckTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: errMsg, 
-				Data: []byte(cmds.Transaction),
-			}
-		}
-		return abcitypes.ResponseCheckTx{
-			Code: 0, 
-			GasWanted: 1, 
-			// Info: strconv.Itoa(int(debtTx.DebtIssued())), 
-			Data: []byte("Valid Repayment Cmd"),
+			panic(fmt.Sprintf("Rollback Error: %v", err))
 		}
+		return checkTxResponse(res)
 	}
+	return abcitypes.ResponseCheckTx{Code: 0, GasWanted: 1, Data: []byte("Valid " + name + " Cmd")}
+}
 
-	return abcitypes.ResponseCheckTx{Code: 0, GasWanted: 1, Info: "unrecognized command", Data: req.Tx}
+func checkTxResponse(res abcitypes.ResponseDeliverTx) abcitypes.ResponseCheckTx {
+	return abcitypes.ResponseCheckTx{
+		Code: res.Code,
+		Codespace: res.Codespace,
+		GasWanted: res.GasWanted,
+		Info: res.Info,
+		Log: res.Log,
+	}
 }
 
+// DeliverTx stages the writes of the transaction in the block batch; a
+// failed transaction leaves the batch as it found it.
 func (app *HELB) DeliverTx(req abcitypes.RequestDeliverTx) abcitypes.ResponseDeliverTx {
-
-	var cmds envelope.Command
-	err := json.Unmarshal(req.Tx, &cmds)
-	if err != nil {
-		return abcitypes.ResponseDeliverTx{
-			Code: codeTypeEncodingError,
-			Log:  fmt.Sprint(err),
-			Info: "Could not parse command JSON",
-		}
+	app.use(app.currentBatch)
+	cmd, _, rejected := app.prepare(req.Tx)
+	if rejected != nil {
+		return *rejected
 	}
-	switch cmds.Command {
-	case "IssueDebt":
-		var debtTx utxi.Transaction
-		debtTxBytes, _ := base64.RawURLEncoding.DecodeString(cmds.Transaction)
-		err := json.Unmarshal(debtTxBytes, &debtTx)
-		if err != nil {
-			errMsg := fmt.Sprint("error: %v\n", err)
-			return abcitypes.ResponseDeliverTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: errMsg, 
-			}
-		}
-		
-		if (!debtTx.IsTransactionValid()) {
-			return abcitypes.ResponseDeliverTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: "Invalid Transaction", 
-			}
-		}
-		// transaction has to be in a block
-		err = app.AddTransaction(debtTx)
-		if err != nil {
-			return abcitypes.ResponseDeliverTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: fmt.Sprintf("error: %v\n", err),
-			}
-		}
-		app.merkletree = append(app.merkletree, ByteWrapper(debtTx.Hash()))
-		// add utxo to utxo pools
-		err = app.AddToUXTOPool(debtTx)
-		// check for error
-		err, totalCredits := app.GetTotalCredits()
+	app.currentBatch.Begin()
+	res := app.run(cmd)
+	if res.Code != codeTypeOK {
+		err := app.currentBatch.Rollback()
 		if err != nil {
-			return abcitypes.ResponseDeliverTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: fmt.Sprintf("error: %v\n", err), 
-			}
+			panic(fmt.Sprintf("Rollback Error: %v", err))
 		}
-		// create outstanding dnbt transaction
-		odtx := utxi.MakeOutstandingDebtTx(debtTx)
-		err = app.AddToDebtPool(odtx)
-		if err != nil {
-			return abcitypes.ResponseDeliverTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: fmt.Sprintf("error: %v\n", err), 
-			}
-		}
-		return abcitypes.ResponseDeliverTx{
-			Code: 0,
-			GasWanted: 1,
-			Info: fmt.Sprintf("Total System Credits: %v", totalCredits),
-		}
-	case "Repayment":
-		var repaymentTx utxi.Transaction
-		repaymentTxBytes, _ := base64.RawURLEncoding.DecodeString(cmds.Transaction)
-		err := json.Unmarshal(repaymentTxBytes, &repaymentTx)
-		if err != nil {
-			errMsg := fmt.Sprint("error: %v\n", err)
-			return abcitypes.ResponseDeliverTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: errMsg, 
-			}
-		}
-		// add to blockchain
-		err = app.AddTransaction(repaymentTx)
-		if err != nil {
-			return abcitypes.ResponseDeliverTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: fmt.Sprintf("error: %v\n", err),
-			}
-		}
-		app.merkletree = append(app.merkletree, ByteWrapper(repaymentTx.Hash()))
-		// edit the outstanding debt
-		err = app.HandleRepayment(repaymentTx)
-		if err != nil {
-			return abcitypes.ResponseDeliverTx{
-				Code: 1, 
-				GasWanted: 1, 
-				Info: fmt.Sprintf("HandleRepayment Error: %v\n", err),
-			}
-		}
-		// no need to add the utxo's to the utxo pool for repayments
-		// querying total system debt
-		debtQueryErr, systemDebt:= app.GetTotalDebt()
-		if debtQueryErr != nil {
-			return abcitypes.ResponseDeliverTx{
-				Code: 0,
-				GasWanted: 1,
-				Info: fmt.Sprintf("error: %v", debtQueryErr),
-			}
-		}
-		return abcitypes.ResponseDeliverTx{
-			Code: 0,
-			GasWanted: 1,
-			Info: fmt.Sprintf("Total System Debt: %v", systemDebt),
-		}
-	}
-
-	return abcitypes.ResponseDeliverTx{Code: 0}
-}
-
-func (app *HELB) Query(reqQuery abcitypes.RequestQuery) (resQuery abcitypes.ResponseQuery) {
-
-	switch reqQuery.Path {
-	case "":
-		// return abcitypes.ResponseQuery{Value: []byte(fmt.Sprint("here"))}
-		return abcitypes.ResponseQuery{Value: []byte(fmt.Sprintf("in nnull case"))}
-	case "test":
-		return abcitypes.ResponseQuery{Value: []byte(fmt.Sprint("test"))}
-		// return abcitypes.ResponseQuery{Value: reqQuery.Data}
-	default:
-		return abcitypes.ResponseQuery{Value: []byte(fmt.Sprint("couldnt recognize path"))}
 	}
-
-	return abcitypes.ResponseQuery{Value: []byte(fmt.Sprint("didn't switch on path"))}
+	return res
 }
 
 func (app *HELB) Commit() abcitypes.ResponseCommit {
+	app.use(app.currentBatch)
 
-	// commented code can be used when Tendermint's empty block generation is diabled
-	// if (len(app.merkletree) > 0) {
-	// 	blockHash := merkle.SimpleHashFromHashers(app.merkletree)
-	// 	app.lastHash = blockHash
-	// } else {
-	// 	app.lastHash = nila
-	// }
-	// app.lastHash = merkle.SimpleHashFromHashers(app.merkletree)
-	// app.height++
-	// return abcitypes.ResponseCommit{Data: app.lastHash}	// merkle root of the block propogated to block tendermint block header
-
-	apphash := make([]byte, 8)
- 	binary.PutVarint(apphash, app.height)
- 	app.height++
- 	return abcitypes.ResponseCommit{Data: apphash}
+	// the sum tree is only rebuilt when the block changed the debt pool
+	if app.currentBatch.Wrote(app.debtPool) || app.liabilitiesRoot.Hash == nil {
+		tree, err := app.LiabilitiesTree()
+		if err != nil {
+			panic(fmt.Sprintf("LiabilitiesTree Error: %v", err))
+		}
+		app.liabilitiesRoot = tree.Root()
+	}
+	// the app hash commits to every store, so validators that diverge disagree on it
+	err := app.updateTrees(app.currentBatch)
+	if err != nil {
+		panic(fmt.Sprintf("updateTrees Error: %v", err))
+	}
+	app.lastHash = app.AppHash()
+	app.height++
+	// writes the block batch and the committed state together
+	err = app.saveState()
+	if err != nil {
+		panic(fmt.Sprintf("saveState Error: %v", err))
+	}
+	app.use(nil)
+	// the mempool is rechecked against the new committed state
+	app.checkBatch.Discard()
+	app.resetLiabilities()
+	return abcitypes.ResponseCommit{Data: app.lastHash}
 }
 
-func (HELB) Info(req abcitypes.RequestInfo) abcitypes.ResponseInfo {
-	return abcitypes.ResponseInfo{}
+// Info tells Tendermint where the app left off; on startup it replays the
+// blocks after LastBlockHeight and checks LastBlockAppHash.
+func (app *HELB) Info(req abcitypes.RequestInfo) abcitypes.ResponseInfo {
+	return abcitypes.ResponseInfo{
+		Data: "debtchain",
+		LastBlockHeight: app.height,
+		LastBlockAppHash: app.lastHash,
+	}
 }
 
 func (HELB) SetOption(req abcitypes.RequestSetOption) abcitypes.ResponseSetOption {
 	return abcitypes.ResponseSetOption{}
 }
 
-func (HELB) InitChain(req abcitypes.RequestInitChain) abcitypes.ResponseInitChain {
+func (app *HELB) InitChain(req abcitypes.RequestInitChain) abcitypes.ResponseInitChain {
+	err := app.InitRegistry(req.AppStateBytes)
+	if err != nil {
+		panic(fmt.Sprintf("InitRegistry Error: %v", err))
+	}
+	// the registry is written straight to the stores
+	err = app.buildTrees()
+	if err != nil {
+		panic(fmt.Sprintf("buildTrees Error: %v", err))
+	}
 	return abcitypes.ResponseInitChain{}
 }
 
 func (app *HELB) EndBlock(req abcitypes.RequestEndBlock) abcitypes.ResponseEndBlock {
-	app.height = req.GetHeight()
 	return abcitypes.ResponseEndBlock{}
 }
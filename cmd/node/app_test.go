@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"debtchain/internal/envelope"
+	"debtchain/internal/wallet"
+	"debtchain/pkg/utxi"
+
+	"github.com/dgraph-io/badger/v2"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "debtchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func openDB(t *testing.T) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(tempDir(t)).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newTestWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+	seed := make([]byte, 32)
+	rand.Read(seed)
+	seedPath := filepath.Join(tempDir(t), "seed.dat")
+	err := ioutil.WriteFile(seedPath, seed, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := wallet.NewWallet(seedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// testChain is a node with one registered lender, one KYC provider and
+// three governors, two of whom must sign governance transactions.
+type testChain struct {
+	*HELB
+	lender		*wallet.Wallet
+	provider	*wallet.Wallet
+	governors	[]*wallet.Wallet
+	stores		[]*badger.DB
+}
+
+// newTestChain starts a chain from the test genesis, as changed by genesis.
+func newTestChain(t *testing.T, genesis ...func(state *GenesisState)) *testChain {
+	t.Helper()
+	var stores []*badger.DB
+	for i := 0; i < 9; i++ {
+		stores = append(stores, openDB(t))
+	}
+	app := NewHELB(stores[0], stores[1], stores[2], stores[3], stores[4], stores[5], stores[6], stores[7], stores[8])
+	chain := &testChain{HELB: app, lender: newTestWallet(t), provider: newTestWallet(t), stores: stores}
+	lender, _ := chain.lender.PublicKey(1)
+	provider, _ := chain.provider.PublicKey(1)
+	state := GenesisState{
+		Lenders: [][]byte{lender},
+		Attesters: map[utxi.AttestationKind][][]byte{utxi.AttestKYC: {provider}},
+		Threshold: 2,
+	}
+	for i := 0; i < 3; i++ {
+		governor := newTestWallet(t)
+		key, _ := governor.PublicKey(1)
+		chain.governors = append(chain.governors, governor)
+		state.Governors = append(state.Governors, key)
+	}
+	for _, change := range genesis {
+		change(&state)
+	}
+	appState, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.InitChain(abcitypes.RequestInitChain{AppStateBytes: appState})
+	return chain
+}
+
+// restart replaces the node with one opened on the same stores, as after a
+// process restart.
+func (chain *testChain) restart(t *testing.T) {
+	t.Helper()
+	s := chain.stores
+	chain.HELB = NewHELB(s[0], s[1], s[2], s[3], s[4], s[5], s[6], s[7], s[8])
+	err := chain.LoadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encodeCommand(t *testing.T, command string, tx interface{}) []byte {
+	t.Helper()
+	b, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := json.Marshal(envelope.Command{Command: command, Transaction: base64.RawURLEncoding.EncodeToString(b)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// deliverTx checks and delivers a command.
+func (chain *testChain) deliverTx(t *testing.T, command string, tx interface{}) abcitypes.ResponseDeliverTx {
+	t.Helper()
+	req := encodeCommand(t, command, tx)
+	chain.CheckTx(abcitypes.RequestCheckTx{Tx: req})
+	return chain.DeliverTx(abcitypes.RequestDeliverTx{Tx: req})
+}
+
+// check admits a command to the mempool, returning the error of the CheckTx response.
+func (chain *testChain) check(t *testing.T, command string, tx interface{}) error {
+	t.Helper()
+	res := chain.CheckTx(abcitypes.RequestCheckTx{Tx: encodeCommand(t, command, tx)})
+	return envelope.Decode(res.Codespace, res.Code, res.Log)
+}
+
+// deliver checks and delivers a command, returning the error of the DeliverTx response.
+func (chain *testChain) deliver(t *testing.T, command string, tx interface{}) error {
+	t.Helper()
+	res := chain.deliverTx(t, command, tx)
+	return envelope.Decode(res.Codespace, res.Code, res.Log)
+}
+
+func (chain *testChain) beginBlock(blockTime time.Time) {
+	req := abcitypes.RequestBeginBlock{}
+	req.Header.Height = chain.height + 1
+	req.Header.Time = blockTime
+	chain.BeginBlock(req)
+}
+
+// attest has the KYC provider attest the subject for an hour, unless it already has.
+func (chain *testChain) attest(t *testing.T, subject []byte) {
+	t.Helper()
+	if _, err := chain.GetAttestation(utxi.AttestKYC, subject); err == nil {
+		return
+	}
+	at := chain.provider.ConstructAttestation(utxi.AttestKYC, subject, time.Now().Add(time.Hour).Unix())
+	if err := chain.deliver(t, "Attestation", at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// issue attests the borrower and delivers a debt of amount to it.
+func (chain *testChain) issue(t *testing.T, borrower []byte, amount uint64) utxi.Transaction {
+	t.Helper()
+	chain.attest(t, borrower)
+	debtTx := chain.lender.ConstructDebtTransaction(borrower, amount)
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+	return debtTx
+}
+
+func loanOf(debtTx utxi.Transaction) []byte {
+	odtx := utxi.MakeOutstandingDebtTx(debtTx)
+	return odtx.Hash()
+}
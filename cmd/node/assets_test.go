@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"debtchain/pkg/utxi"
+)
+
+func withAsset(id utxi.AssetId) func(state *GenesisState) {
+	return func(state *GenesisState) {
+		state.Assets = append(state.Assets, utxi.Asset{Id: id, Name: string(id), Decimals: 2})
+	}
+}
+
+func TestAssetDebt(t *testing.T) {
+	chain := newTestChain(t, withAsset("usd"))
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+	chain.attest(t, borrowerAddr)
+
+	if err := chain.deliver(t, "IssueDebt", chain.lender.ConstructAssetDebtTransaction(borrowerAddr, "eur", 100)); err == nil {
+		t.Error("debt issued in an unregistered asset")
+	}
+	debtTx := chain.lender.ConstructAssetDebtTransaction(borrowerAddr, "usd", 100)
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+	chain.issue(t, borrowerAddr, 40)
+
+	err, debt := chain.GetTotalDebtByAsset()
+	if err != nil || debt["usd"] != 100 || debt[utxi.NativeAsset] != 40 {
+		t.Errorf("debt by asset %v, %v", debt, err)
+	}
+	if err, native := chain.GetTotalDebt(); err != nil || native != 40 {
+		t.Errorf("native debt %v, %v; want 40", native, err)
+	}
+
+	odtx := utxi.MakeOutstandingDebtTx(debtTx)
+	otherAsset := borrower.ConstructRepaymentTransaction(1, lenderAddr, 30, odtx, 0)
+	otherAsset.Outputs[0].Asset = utxi.NativeAsset
+	if err := chain.deliver(t, "Repayment", otherAsset); err == nil {
+		t.Error("usd debt repaid in the native asset")
+	}
+	if err := chain.deliver(t, "Repayment", borrower.ConstructRepaymentTransaction(1, lenderAddr, 30, odtx, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err, debt := chain.GetTotalDebtByAsset(); err != nil || debt["usd"] != 70 || debt[utxi.NativeAsset] != 40 {
+		t.Errorf("debt by asset %v, %v after repaying 30 usd", debt, err)
+	}
+}
+
+func TestAddAsset(t *testing.T) {
+	chain := newTestChain(t)
+	issuer, _ := newTestWallet(t).PublicKey(1)
+	asset := utxi.Asset{Id: "eur", Name: "Euro", Decimals: 2}
+	gov := utxi.Governance{Action: utxi.AddAsset, Key: issuer, Asset: &asset, Nonce: 1}
+	chain.governors[0].SignGovernance(&gov)
+	chain.governors[1].SignGovernance(&gov)
+	if err := chain.deliver(t, "Governance", gov); err != nil {
+		t.Fatal(err)
+	}
+	registered, err := chain.GetAsset("eur")
+	if err != nil || string(registered.Issuer) != string(issuer) {
+		t.Fatalf("asset %+v, %v", registered, err)
+	}
+
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	if err := chain.deliver(t, "IssueDebt", chain.lender.ConstructAssetDebtTransaction(borrowerAddr, "eur", 100)); err != nil {
+		t.Error(err)
+	}
+
+	again := utxi.Governance{Action: utxi.AddAsset, Key: issuer, Asset: &asset, Nonce: 2}
+	chain.governors[0].SignGovernance(&again)
+	chain.governors[1].SignGovernance(&again)
+	if err := chain.deliver(t, "Governance", again); err == nil {
+		t.Error("asset registered twice")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	"github.com/dgraph-io/badger/v2"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+/*
+	Attestations are kept per kind and subject. Debt can only be issued to
+	addresses with a current KYC attestation and the attestations its
+	product type requires; expiry is checked against the block time. A
+	stealth output is checked against every spend key its StealthProof
+	lists, as its one-time address is never attested. An attestation only
+	replaces one that expires earlier.
+*/
+
+func attestationKey(kind utxi.AttestationKind, subject []byte) []byte {
+	return append([]byte(string(kind) + "/"), subject...)
+}
+
+func (app *HELB) AddAttestation(at utxi.Attestation) error {
+	registered, err := app.IsAttester(at.Kind, at.Attester())
+	if err != nil {
+		return err
+	}
+	if !registered {
+		return envelope.ErrUnauthorized.Wrapf("attester is not registered for %v", at.Kind)
+	}
+	if !at.IsCurrent(app.blockTime) {
+		return envelope.ErrNotAttested.Wrapf("attestation has expired")
+	}
+	if _, err := app.GetRecord(at.Hash()); err == nil {
+		return envelope.ErrDuplicateTx.Wrapf("attestation already recorded")
+	}
+	current, err := app.GetAttestation(at.Kind, at.Subject)
+	if err == nil && at.Expires <= current.Expires {
+		return envelope.ErrInvalidTx.Wrapf("attestation expires no later than the current one")
+	}
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	err = app.AddRecord(at.Hash(), at.Serialize())
+	if err != nil {
+		return err
+	}
+	return app.attestations.Update(func(txn *Txn) error {
+		return txn.Set(attestationKey(at.Kind, at.Subject), at.Serialize())
+	})
+}
+
+func (app *HELB) GetAttestation(kind utxi.AttestationKind, subject []byte) (utxi.Attestation, error) {
+	var at utxi.Attestation
+	err := app.attestations.View(func(txn *Txn) error {
+		item, err := txn.Get(attestationKey(kind, subject))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &at)
+		})
+	})
+	return at, err
+}
+
+// checkAttestation rejects subjects without a current attestation of the kind
+// from an attester that is still registered.
+func (app *HELB) checkAttestation(kind utxi.AttestationKind, subject []byte) error {
+	at, err := app.GetAttestation(kind, subject)
+	if err == badger.ErrKeyNotFound {
+		return envelope.ErrNotAttested.Wrapf("no %v attestation for %v", kind, base64.URLEncoding.EncodeToString(subject))
+	}
+	if err != nil {
+		return err
+	}
+	if !at.IsCurrent(app.blockTime) {
+		return envelope.ErrNotAttested.Wrapf("%v attestation for %v has expired", kind, base64.URLEncoding.EncodeToString(subject))
+	}
+	registered, err := app.IsAttester(kind, at.Attester())
+	if err != nil {
+		return err
+	}
+	if !registered {
+		return envelope.ErrNotAttested.Wrapf("%v attester for %v is no longer registered", kind, base64.URLEncoding.EncodeToString(subject))
+	}
+	return nil
+}
+
+// checkBorrowers requires a KYC attestation and those of the product type for
+// every borrower of a debt transaction.
+func (app *HELB) checkBorrowers(debtTx utxi.Transaction) error {
+	for _, output := range debtTx.Outputs {
+		err := app.checkBorrower(debtTx.Product, output)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBorrower requires a KYC attestation and those of the product type for
+// the borrower paid by output.
+func (app *HELB) checkBorrower(product utxi.ProductType, output utxi.TxOutput) error {
+	required, err := app.GetRequirements(product)
+	if err != nil {
+		return err
+	}
+	required = append([]utxi.AttestationKind{utxi.AttestKYC}, required...)
+	subjects, err := borrowerKeys(output)
+	if err != nil {
+		return err
+	}
+	for _, subject := range subjects {
+		for _, kind := range required {
+			err := app.checkAttestation(kind, subject)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// borrowerKeys returns the keys attested for the borrower of an output: its
+// address, or the spend keys the proof of a stealth output lists.
+func borrowerKeys(output utxi.TxOutput) ([][]byte, error) {
+	if !output.IsStealth() {
+		return [][]byte{output.RecipientAddr()}, nil
+	}
+	// IsOutputValid checked the proof
+	if output.StealthProof == nil {
+		return nil, envelope.ErrNotAttested.Wrapf("stealth output %v names no spend keys", base64.URLEncoding.EncodeToString(output.RecipientAddr()))
+	}
+	return output.StealthProof.Ring, nil
+}
+
+func decodeAttestation(encoded string) (utxi.Attestation, error) {
+	var at utxi.Attestation
+	attestationBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return at, err
+	}
+	err = json.Unmarshal(attestationBytes, &at)
+	return at, err
+}
+
+func attestationCommand(app *HELB, encoded string) (command, error) {
+	at, err := decodeAttestation(encoded)
+	if err != nil {
+		return command{}, err
+	}
+	return command{
+		validate: func() error {
+			if !at.IsAttestationValid() {
+				return envelope.ErrInvalidTx.Wrapf("attestation")
+			}
+			return nil
+		},
+		execute: func() (abcitypes.ResponseDeliverTx, error) {
+			err := app.AddAttestation(at)
+			if err != nil {
+				return abcitypes.ResponseDeliverTx{}, fmt.Errorf("AddAttestation Error: %w", err)
+			}
+			return abcitypes.ResponseDeliverTx{
+				Code: 0,
+				GasWanted: 1,
+				Info: fmt.Sprintf("%v attestation for %v", at.Kind, base64.URLEncoding.EncodeToString(at.Subject)),
+			}, nil
+		},
+	}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"debtchain/pkg/utxi"
+)
+
+func TestKYCRequired(t *testing.T) {
+	chain := newTestChain(t)
+	now := time.Now()
+	chain.beginBlock(now)
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	debtTx := chain.lender.ConstructDebtTransaction(borrowerAddr, 100)
+
+	if err := chain.deliver(t, "IssueDebt", debtTx); err == nil {
+		t.Fatal("debt issued to a borrower without an attestation")
+	}
+	rejected := []struct {
+		name	string
+		at		utxi.Attestation
+	}{
+		{"unregistered provider", newTestWallet(t).ConstructAttestation(utxi.AttestKYC, borrowerAddr, now.Add(time.Hour).Unix())},
+		{"expired", chain.provider.ConstructAttestation(utxi.AttestKYC, borrowerAddr, now.Add(-time.Hour).Unix())},
+		{"other kind", chain.provider.ConstructAttestation(utxi.AttestationKind("counseling"), borrowerAddr, now.Add(time.Hour).Unix())},
+	}
+	for _, tc := range rejected {
+		if err := chain.deliver(t, "Attestation", tc.at); err == nil {
+			t.Errorf("%v: attestation accepted", tc.name)
+		}
+	}
+
+	at := chain.provider.ConstructAttestation(utxi.AttestKYC, borrowerAddr, now.Add(time.Hour).Unix())
+	if err := chain.deliver(t, "Attestation", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the attestation lapses with the block time
+	chain.beginBlock(now.Add(2 * time.Hour))
+	if err := chain.deliver(t, "IssueDebt", chain.lender.ConstructDebtTransaction(borrowerAddr, 50)); err == nil {
+		t.Error("debt issued on an expired attestation")
+	}
+}
+
+func TestRemovedProvider(t *testing.T) {
+	chain := newTestChain(t)
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.issue(t, borrowerAddr, 100)
+
+	provider, _ := chain.provider.PublicKey(1)
+	gov := utxi.Governance{Action: utxi.RemoveAttester, Key: provider, Kind: utxi.AttestKYC, Nonce: 1}
+	chain.governors[0].SignGovernance(&gov)
+	chain.governors[1].SignGovernance(&gov)
+	if err := chain.deliver(t, "Governance", gov); err != nil {
+		t.Fatal(err)
+	}
+	// attestations of a removed provider no longer count
+	if err := chain.deliver(t, "IssueDebt", chain.lender.ConstructDebtTransaction(borrowerAddr, 50)); err == nil {
+		t.Error("debt issued on the attestation of a removed provider")
+	}
+}
+
+func TestAttestationReplay(t *testing.T) {
+	chain := newTestChain(t)
+	now := time.Now()
+	chain.beginBlock(now)
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+
+	older := chain.provider.ConstructAttestation(utxi.AttestKYC, borrowerAddr, now.Add(time.Hour).Unix())
+	newer := chain.provider.ConstructAttestation(utxi.AttestKYC, borrowerAddr, now.Add(2 * time.Hour).Unix())
+	if err := chain.deliver(t, "Attestation", older); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "Attestation", newer); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := []struct {
+		name	string
+		at		utxi.Attestation
+	}{
+		{"replayed", newer},
+		{"older", older},
+		{"same expiry", chain.provider.ConstructAttestation(utxi.AttestKYC, borrowerAddr, now.Add(2 * time.Hour).Unix())},
+	}
+	for _, tc := range rejected {
+		if err := chain.deliver(t, "Attestation", tc.at); err == nil {
+			t.Errorf("%v: attestation accepted", tc.name)
+		}
+	}
+	if at, err := chain.GetAttestation(utxi.AttestKYC, borrowerAddr); err != nil || at.Expires != newer.Expires {
+		t.Errorf("attestation %+v, %v", at, err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/dgraph-io/badger/v2"
+)
+
+/*
+	Confidential amounts. Commitments are checked to balance in DeliverTx
+	without opening them; only totals need the audit key, which opens each
+	confidential output so the node can report and prove the sums. The key
+	is a local setting, so it is only used by queries: DeliverTx reports the
+	plaintext totals, which every node computes alike. Outputs the node
+	cannot open, without the key or with an opening that does not match,
+	are left out of the totals and counted separately.
+*/
+
+func loadAuditKey(path string) (*btcec.PrivateKey, error) {
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	auditKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), keyBytes)
+	return auditKey, nil
+}
+
+func (app *HELB) outputOpening(output utxi.TxOutput) (utxi.Opening, error) {
+	if !output.IsConfidential() {
+		return utxi.Opening{Value: output.Value}, nil
+	}
+	if app.auditKey == nil {
+		return utxi.Opening{}, errors.New("confidential output and no audit key configured")
+	}
+	return output.Confidential.Open(app.auditKey)
+}
+
+// outputValue returns the opened value of the output, or false if it cannot be opened.
+func (app *HELB) outputValue(output utxi.TxOutput) (uint64, bool) {
+	opening, err := app.outputOpening(output)
+	return opening.Value, err == nil
+}
+
+// plaintextValue leaves confidential outputs, whose Value is 0, out of a total.
+func plaintextValue(output utxi.TxOutput) (uint64, bool) {
+	return output.Value, true
+}
+
+// GetPlaintextDebt sums the plaintext native asset debt, for DeliverTx results.
+func (app *HELB) GetPlaintextDebt() (error, int) {
+	err, totals, _ := app.totalDebt(plaintextValue)
+	if err != nil {
+		return err, -10
+	}
+	return nil, totals[utxi.NativeAsset]
+}
+
+// GetPlaintextCredits sums the plaintext native asset credits, for DeliverTx results.
+func (app *HELB) GetPlaintextCredits() (error, int) {
+	err, totals, _ := app.totalCredits(plaintextValue)
+	if err != nil {
+		return err, -10
+	}
+	return nil, totals[utxi.NativeAsset]
+}
+
+// Unopened counts the confidential outputs left out of the totals.
+type Unopened struct {
+	Debt			int
+	Credits			int
+}
+
+func (app *HELB) GetUnopened() (Unopened, error) {
+	var unopened Unopened
+	err, _, debt := app.totalDebt(app.outputValue)
+	if err != nil {
+		return unopened, err
+	}
+	err, _, credits := app.totalCredits(app.outputValue)
+	if err != nil {
+		return unopened, err
+	}
+	unopened.Debt, unopened.Credits = debt, credits
+	return unopened, nil
+}
+
+/*
+	A confidential repayment spends the loan's debt commitment D into the
+	payment P (Outputs[0]) and the remaining debt D' (Outputs[1]). The
+	borrower picks the blinding factors so that D = P + D', and the range
+	proof on D' shows the repayment does not exceed the debt.
+*/
+func (app *HELB) HandleConfidentialRepayment(rpTx utxi.Transaction, debtTx utxi.Transaction) error {
+	if len(rpTx.Outputs) != 2 || !rpTx.Outputs[0].IsConfidential() || !rpTx.Outputs[1].IsConfidential() {
+		return envelope.ErrInvalidTx.Wrapf("confidential repayments pay one output and return the remaining debt")
+	}
+	for _, output := range rpTx.Outputs {
+		if !output.IsOutputValid() {
+			return envelope.ErrInvalidTx.Wrapf("invalid confidential output")
+		}
+	}
+	debt := debtTx.Outputs[0].Confidential.Commitment
+	payment := rpTx.Outputs[0].Confidential.Commitment
+	remaining := rpTx.Outputs[1].Confidential.Commitment
+	if !utxi.CommitmentsBalance([][]byte{debt}, [][]byte{payment, remaining}) {
+		return envelope.ErrInvalidTx.Wrapf("repayment commitments do not balance")
+	}
+
+	debtTx.Outputs[0].Confidential = rpTx.Outputs[1].Confidential
+	return app.SetOutstandingDebt(rpTx.Inputs[0].Txid, debtTx)
+}
+
+func (app *HELB) proveTotal(db *Store, outputsOf func(v []byte) ([]utxi.TxOutput, error)) (utxi.TotalProof, error) {
+	var proof utxi.TotalProof
+	blinding := new(big.Int)
+	err := db.View(func(txn *Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(v []byte) error {
+				outputs, err := outputsOf(v)
+				if err != nil {
+					return err
+				}
+				for _, output := range outputs {
+					opening, err := app.outputOpening(output)
+					if err != nil {
+						proof.Unopened = append(proof.Unopened, output.Confidential.Commitment)
+						continue
+					}
+					proof.Total = proof.Total + opening.Value
+					if output.IsConfidential() {
+						blinding.Add(blinding, opening.BlindingFactor())
+						proof.Commitments = append(proof.Commitments, output.Confidential.Commitment)
+					} else if output.Value > 0 {
+						proof.Commitments = append(proof.Commitments, utxi.PlainCommitment(output.Value))
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	proof.Blinding = new(big.Int).Mod(blinding, btcec.S256().N).Bytes()
+	return proof, err
+}
+
+// ProveTotalDebt opens the sum of all outstanding debt commitments.
+func (app *HELB) ProveTotalDebt() (utxi.TotalProof, error) {
+	return app.proveTotal(app.debtPool, func(v []byte) ([]utxi.TxOutput, error) {
+		var debtTx utxi.Transaction
+		err := json.Unmarshal(v, &debtTx)
+		return debtTx.Outputs, err
+	})
+}
+
+// ProveTotalCredits opens the sum of all utxo commitments.
+func (app *HELB) ProveTotalCredits() (utxi.TotalProof, error) {
+	return app.proveTotal(app.utxoPool, func(v []byte) ([]utxi.TxOutput, error) {
+		var output utxi.TxOutput
+		err := json.Unmarshal(v, &output)
+		return []utxi.TxOutput{output}, err
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+func TestConfidentialDebt(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	confidentialAddr, _ := borrower.PublicKey(2)
+	audit, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.attest(t, confidentialAddr)
+	chain.issue(t, borrowerAddr, 40)
+	debtTx, _, err := chain.lender.ConstructConfidentialDebtTransaction(confidentialAddr, 100, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the node has no audit key, which DeliverTx does not need
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+	if err, debt := chain.GetPlaintextDebt(); err != nil || debt != 40 {
+		t.Errorf("plaintext debt %v, %v; want 40", debt, err)
+	}
+	// without the key the confidential output is left out and counted
+	if err, debt := chain.GetTotalDebt(); err != nil || debt != 40 {
+		t.Errorf("total debt without the audit key %v, %v; want 40", debt, err)
+	}
+	if unopened, err := chain.GetUnopened(); err != nil || unopened != (Unopened{Debt: 1, Credits: 1}) {
+		t.Errorf("unopened %+v, %v", unopened, err)
+	}
+
+	chain.auditKey = audit
+	if err, debt := chain.GetTotalDebt(); err != nil || debt != 140 {
+		t.Errorf("total debt %v, %v; want 140", debt, err)
+	}
+	if err, credits := chain.GetTotalCredits(); err != nil || credits != 140 {
+		t.Errorf("total credits %v, %v; want 140", credits, err)
+	}
+	if unopened, err := chain.GetUnopened(); err != nil || unopened != (Unopened{}) {
+		t.Errorf("unopened %+v, %v", unopened, err)
+	}
+}
+
+func TestConfidentialWrongOpening(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	confidentialAddr, _ := borrower.PublicKey(2)
+	audit, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.auditKey = audit
+	chain.attest(t, confidentialAddr)
+	chain.issue(t, borrowerAddr, 40)
+	// a well formed opening the node's audit key does not open
+	debtTx, _, err := chain.lender.ConstructConfidentialDebtTransaction(confidentialAddr, 100, other.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err, debt := chain.GetTotalDebt(); err != nil || debt != 40 {
+		t.Errorf("total debt %v, %v; want 40", debt, err)
+	}
+	if unopened, err := chain.GetUnopened(); err != nil || unopened != (Unopened{Debt: 1, Credits: 1}) {
+		t.Errorf("unopened %+v, %v", unopened, err)
+	}
+	proof, err := chain.ProveTotalDebt()
+	if err != nil || proof.Total != 40 || len(proof.Unopened) != 1 || !proof.Verify() {
+		t.Errorf("debt proof %+v, %v", proof, err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"debtchain/pkg/utxi"
+
+	"github.com/dgraph-io/badger/v2"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/kv"
+)
+
+/*
+	Delinquency tracking. At the start of every block each scheduled loan is
+	checked against the block time: missed installments move the loan into a
+	days-past-due bucket and, past the grace period, are charged a late fee
+	which is added to the outstanding debt. Bucket changes and fees are
+	emitted as BeginBlock events. A fee that would overflow the outstanding
+	debt or the liabilities total is skipped, as BeginBlock cannot fail.
+*/
+
+// openLoans returns the loans that are not closed and match.
+func (app *HELB) openLoans(match func(utxi.Loan) bool) ([]utxi.Loan, error) {
+	return app.loansWhere(func(loan utxi.Loan) bool {
+		return !loan.IsClosed() && match(loan)
+	})
+}
+
+// loansWhere lists every loan, open or closed, that matches.
+func (app *HELB) loansWhere(match func(utxi.Loan) bool) ([]utxi.Loan, error) {
+	loans := []utxi.Loan{}
+	err := app.loans.View(func(txn *Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(v []byte) error {
+				var loan utxi.Loan
+				jsonErr := json.Unmarshal(v, &loan)
+				if jsonErr != nil {
+					return jsonErr
+				}
+				if match(loan) {
+					loans = append(loans, loan)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return loans, err
+}
+
+func (app *HELB) openScheduledLoans() ([]utxi.Loan, error) {
+	return app.openLoans(func(loan utxi.Loan) bool {
+		return loan.Terms != nil && len(loan.Terms.Schedule) > 0
+	})
+}
+
+func (app *HELB) AssessLoans(now int64) ([]abcitypes.Event, error) {
+	scheduled, err := app.openScheduledLoans()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []abcitypes.Event
+	liabilities := app.debtPool.batch.liabilities
+	for _, loan := range scheduled {
+		before := loan.Serialize()
+		fees, bucketChanged := loan.Assess(now)
+		if bytes.Equal(before, loan.Serialize()) {
+			continue
+		}
+
+		var debtTx utxi.Transaction
+		if fees > 0 {
+			debtTx, err = app.GetOutstandingDebt(loan.Id)
+			if err != nil {
+				return nil, err
+			}
+			value := debtTx.Outputs[0].Value
+			if value + fees < value || liabilities + fees < liabilities {
+				loan.LateFees = loan.LateFees - fees
+				fees = 0
+			}
+		}
+		if fees > 0 {
+			debtTx.Outputs[0].Value = debtTx.Outputs[0].Value + fees
+			liabilities = liabilities + fees
+			err = app.SetOutstandingDebt(loan.Id, debtTx)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, loanEvent("loan.late_fee", loan,
+				kv.Pair{Key: []byte("amount"), Value: []byte(strconv.FormatUint(fees, 10))}))
+		}
+		if bucketChanged {
+			events = append(events, delinquencyEvent(loan))
+		}
+		err = app.AddLoan(loan)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (app *HELB) GetDelinquentLoans() ([]utxi.Loan, error) {
+	scheduled, err := app.openScheduledLoans()
+	if err != nil {
+		return nil, err
+	}
+	delinquent := []utxi.Loan{}
+	for _, loan := range scheduled {
+		if loan.Delinquency != nil {
+			delinquent = append(delinquent, loan)
+		}
+	}
+	return delinquent, nil
+}
+
+func loanEvent(eventType string, loan utxi.Loan, attributes ...kv.Pair) abcitypes.Event {
+	return abcitypes.Event{
+		Type: eventType,
+		Attributes: append([]kv.Pair{
+			{Key: []byte("loan"), Value: []byte(base64.URLEncoding.EncodeToString(loan.Id))},
+			{Key: []byte("lender"), Value: []byte(base64.URLEncoding.EncodeToString(loan.Lender))},
+			{Key: []byte("borrower"), Value: []byte(base64.URLEncoding.EncodeToString(loan.Borrower))},
+		}, attributes...),
+	}
+}
+
+// amountEvent is a loan event with the amount it moved and the asset of the
+// loan; confidential amounts are left out.
+func amountEvent(eventType string, loan utxi.Loan, amount uint64, confidential bool) abcitypes.Event {
+	var attributes []kv.Pair
+	if !confidential {
+		attributes = append(attributes, kv.Pair{Key: []byte("amount"), Value: []byte(strconv.FormatUint(amount, 10))})
+	}
+	if loan.Asset != utxi.NativeAsset {
+		attributes = append(attributes, kv.Pair{Key: []byte("asset"), Value: []byte(loan.Asset)})
+	}
+	return loanEvent(eventType, loan, attributes...)
+}
+
+func delinquencyEvent(loan utxi.Loan) abcitypes.Event {
+	if loan.Delinquency == nil {
+		return loanEvent("loan.current", loan)
+	}
+	return loanEvent("loan.delinquent", loan,
+		kv.Pair{Key: []byte("bucket"), Value: []byte(loan.Delinquency.Bucket)},
+		kv.Pair{Key: []byte("days_past_due"), Value: []byte(strconv.FormatInt(loan.Delinquency.DaysPastDue, 10))})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"debtchain/pkg/utxi"
+)
+
+func TestLateFee(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	now := time.Now()
+	chain.beginBlock(now)
+	chain.attest(t, borrowerAddr)
+	debtTx := chain.lender.ConstructScheduledDebtTransaction(borrowerAddr, 100, utxi.LoanTerms{
+		Schedule: []utxi.Installment{{DueTime: now.Add(time.Hour).Unix(), Amount: 100}},
+		LateFee: utxi.LateFeeRule{GraceDays: 1, Flat: 5, Bps: 1000},
+	})
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+	chain.Commit()
+
+	chain.beginBlock(now.Add(72 * time.Hour))
+	chain.Commit()
+	loan, err := chain.GetLoan(loanOf(debtTx))
+	if err != nil || loan.LateFees != 15 || loan.DelinquencyBucket() != utxi.Bucket1To29 {
+		t.Fatalf("loan %+v, %v", loan, err)
+	}
+	if err, debt := chain.GetTotalDebt(); err != nil || debt != 115 {
+		t.Errorf("total debt %v, %v; want 115", debt, err)
+	}
+}
+
+func TestLateFeeOverflowSkipped(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	first, _ := borrower.PublicKey(1)
+	second, _ := borrower.PublicKey(2)
+	now := time.Now()
+	chain.beginBlock(now)
+	chain.issue(t, first, ^uint64(0) - 10)
+	chain.attest(t, second)
+	debtTx := chain.lender.ConstructScheduledDebtTransaction(second, 5, utxi.LoanTerms{
+		Schedule: []utxi.Installment{{DueTime: now.Add(time.Hour).Unix(), Amount: 5}},
+		LateFee: utxi.LateFeeRule{Flat: 10},
+	})
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+	chain.Commit()
+
+	// the fee would take the liabilities total past a uint64; Commit must not panic
+	chain.beginBlock(now.Add(72 * time.Hour))
+	chain.Commit()
+	loan, err := chain.GetLoan(loanOf(debtTx))
+	if err != nil || loan.LateFees != 0 || loan.DelinquencyBucket() != utxi.Bucket1To29 {
+		t.Fatalf("loan %+v, %v", loan, err)
+	}
+	if chain.liabilitiesRoot.Sum != ^uint64(0) - 5 {
+		t.Errorf("liabilities %v after commit", chain.liabilitiesRoot.Sum)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"debtchain/internal/envelope"
+	"debtchain/pkg/utxi"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestErrorCodes(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+	chain.beginBlock(time.Unix(1000, 0))
+	debtTx := chain.issue(t, borrowerAddr, 100)
+	odtx := utxi.MakeOutstandingDebtTx(debtTx)
+	unattested, _ := newTestWallet(t).PublicKey(1)
+
+	tests := []struct {
+		name	string
+		command	string
+		tx		interface{}
+		want	*envelope.Error
+	}{
+		{"unknown command", "Mint", debtTx, envelope.ErrUnknownCommand},
+		{"undecodable transaction", "IssueDebt", "not a transaction", envelope.ErrEncoding},
+		{"reissued debt", "IssueDebt", debtTx, envelope.ErrDuplicateTx},
+		{"reissued loan", "IssueDebt", chain.lender.ConstructDebtTransaction(borrowerAddr, 100), envelope.ErrDuplicateTx},
+		{"unregistered lender", "IssueDebt", newTestWallet(t).ConstructDebtTransaction(borrowerAddr, 75), envelope.ErrUnauthorizedIssuer},
+		{"borrower without KYC", "IssueDebt", chain.lender.ConstructDebtTransaction(unattested, 100), envelope.ErrNotAttested},
+		{"unregistered asset", "IssueDebt", chain.lender.ConstructAssetDebtTransaction(borrowerAddr, "eur", 100), envelope.ErrUnknownAsset},
+		{"repayment by another wallet", "Repayment", newTestWallet(t).ConstructRepaymentTransaction(1, lenderAddr, 10, odtx, 0), envelope.ErrSignature},
+		{"overpayment", "Repayment", borrower.ConstructRepaymentTransaction(1, lenderAddr, 500, odtx, 0), envelope.ErrInsufficientFunds},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := chain.deliverTx(t, test.command, test.tx)
+			if res.Codespace != test.want.Codespace || res.Code != test.want.Code {
+				t.Fatalf("failed with %v/%v %q, want %v/%v", res.Codespace, res.Code, res.Log, test.want.Codespace, test.want.Code)
+			}
+			if err := envelope.Decode(res.Codespace, res.Code, res.Log); !errors.Is(err, test.want) {
+				t.Errorf("decoded %v, want %v", err, test.want)
+			}
+		})
+	}
+	if res := chain.deliverTx(t, "IssueDebt", chain.lender.ConstructDebtTransaction(borrowerAddr, 50)); res.Code != 0 || res.Codespace != "" {
+		t.Errorf("delivered debt reported %v/%v", res.Codespace, res.Code)
+	}
+}
+
+func TestQueryErrorCodes(t *testing.T) {
+	chain := newTestChain(t)
+	tests := []struct {
+		name	string
+		query	abcitypes.RequestQuery
+		want	*envelope.Error
+	}{
+		{"unknown path", abcitypes.RequestQuery{Path: "balance"}, envelope.ErrUnknownPath},
+		{"unknown transaction", abcitypes.RequestQuery{Path: "tx", Data: []byte("missing")}, envelope.ErrNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := chain.Query(test.query)
+			if err := envelope.Decode(res.Codespace, res.Code, res.Log); !errors.Is(err, test.want) {
+				t.Errorf("query failed with %v/%v %q, want %v", res.Codespace, res.Code, res.Log, test.want)
+			}
+		})
+	}
+}
+
+// failures found while executing a command keep their codes
+func TestExecuteErrorCodes(t *testing.T) {
+	chain := newTestChain(t, withAsset("usd"))
+	chain.beginBlock(time.Unix(1000, 0))
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+	chain.attest(t, borrowerAddr)
+	usdDebt := chain.lender.ConstructAssetDebtTransaction(borrowerAddr, "usd", 100)
+	if err := chain.deliver(t, "IssueDebt", usdDebt); err != nil {
+		t.Fatal(err)
+	}
+	nativeRepayment := borrower.ConstructRepaymentTransaction(1, lenderAddr, 10, utxi.MakeOutstandingDebtTx(usdDebt), 0)
+	nativeRepayment.Outputs[0].Asset = utxi.NativeAsset
+	borrower.SignTransaction(1, &nativeRepayment)
+	closed := loanOf(chain.issue(t, borrowerAddr, 50))
+	if err := chain.deliver(t, "WriteOff", chain.lender.ConstructWriteOff(closed, utxi.ReasonUncollectable)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name	string
+		command	string
+		tx		interface{}
+		want	*envelope.Error
+	}{
+		{"repayment in another asset", "Repayment", nativeRepayment, envelope.ErrInvalidTx},
+		{"forgiving a closed loan", "ForgiveDebt", chain.lender.ConstructDebtReduction(closed, 10, utxi.ReasonHardship), envelope.ErrLoanClosed},
+		{"forgiving an unknown loan", "ForgiveDebt", chain.lender.ConstructDebtReduction([]byte("missing"), 10, utxi.ReasonHardship), envelope.ErrUnknownLoan},
+		{"expired attestation", "Attestation", chain.provider.ConstructAttestation(utxi.AttestKYC, borrowerAddr, 1), envelope.ErrNotAttested},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := chain.deliver(t, test.command, test.tx); !errors.Is(err, test.want) {
+				t.Errorf("failed with %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"debtchain/internal/envelope"
+	"debtchain/pkg/utxi"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+// attributes returns the attributes of the only event of a type.
+func attributes(t *testing.T, events []abcitypes.Event, eventType string) map[string]string {
+	t.Helper()
+	var found map[string]string
+	for _, event := range events {
+		if event.Type != eventType {
+			continue
+		}
+		if found != nil {
+			t.Fatalf("more than one %v event", eventType)
+		}
+		found = make(map[string]string)
+		for _, attr := range event.Attributes {
+			found[string(attr.Key)] = string(attr.Value)
+		}
+	}
+	if found == nil {
+		t.Fatalf("no %v event in %v", eventType, events)
+	}
+	return found
+}
+
+func TestLoanEvents(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+	lender, _ := chain.lender.PublicKey(1)
+	b64 := base64.URLEncoding.EncodeToString
+	chain.attest(t, borrowerAddr)
+
+	debtTx := chain.lender.ConstructDebtTransaction(borrowerAddr, 100)
+	res := chain.deliverTx(t, "IssueDebt", debtTx)
+	issued := attributes(t, res.Events, "debt.issued")
+	loanId := loanOf(debtTx)
+	want := map[string]string{"loan": b64(loanId), "lender": b64(lender), "borrower": b64(borrowerAddr), "amount": "100"}
+	for key, value := range want {
+		if issued[key] != value {
+			t.Errorf("debt.issued %v = %q, want %q", key, issued[key], value)
+		}
+	}
+	if _, ok := issued["asset"]; ok {
+		t.Error("debt.issued names the native asset")
+	}
+
+	repayment := borrower.ConstructRepaymentTransaction(1, lenderAddr, 30, utxi.MakeOutstandingDebtTx(debtTx), 0)
+	res = chain.deliverTx(t, "Repayment", repayment)
+	if repaid := attributes(t, res.Events, "debt.repaid"); repaid["loan"] != b64(loanId) || repaid["amount"] != "30" {
+		t.Errorf("debt.repaid %v", repaid)
+	}
+
+	res = chain.deliverTx(t, "ForgiveDebt", chain.lender.ConstructDebtReduction(loanId, 70, utxi.ReasonHardship))
+	if closed := attributes(t, res.Events, "loan.closed"); closed["loan"] != b64(loanId) || closed["amount"] != "70" {
+		t.Errorf("loan.closed %v", closed)
+	}
+
+	// a failed transaction emits nothing
+	res = chain.deliverTx(t, "IssueDebt", debtTx)
+	if res.Code == 0 || len(res.Events) != 0 {
+		t.Errorf("rejected debt emitted %v", res.Events)
+	}
+}
+
+func TestAssetLoanEvents(t *testing.T) {
+	chain := newTestChain(t, withAsset("usd"))
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	res := chain.deliverTx(t, "IssueDebt", chain.lender.ConstructAssetDebtTransaction(borrowerAddr, "usd", 100))
+	if issued := attributes(t, res.Events, "debt.issued"); issued["asset"] != "usd" || issued["amount"] != "100" {
+		t.Errorf("debt.issued %v", issued)
+	}
+}
+
+func TestRepaidInFull(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+	debtTx := chain.issue(t, borrowerAddr, 100)
+	odtx := utxi.MakeOutstandingDebtTx(debtTx)
+	loanId := loanOf(debtTx)
+
+	res := chain.deliverTx(t, "Repayment", borrower.ConstructRepaymentTransaction(1, lenderAddr, 100, odtx, 0))
+	attributes(t, res.Events, "debt.repaid")
+	if closed := attributes(t, res.Events, "loan.closed"); closed["loan"] != base64.URLEncoding.EncodeToString(loanId) || closed["amount"] != "100" {
+		t.Errorf("loan.closed %v", closed)
+	}
+	if loan, err := chain.GetLoan(loanId); err != nil || !loan.IsClosed() || loan.Repaid != 100 {
+		t.Errorf("loan %+v, %v", loan, err)
+	}
+	if _, err := chain.GetOutstandingDebt(loanId); err == nil {
+		t.Error("repaid debt left in the debt pool")
+	}
+	again := borrower.ConstructRepaymentTransaction(1, lenderAddr, 10, odtx, 0)
+	if err := chain.deliver(t, "Repayment", again); !errors.Is(err, envelope.ErrLoanClosed) {
+		t.Errorf("repayment of a closed loan: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	"github.com/dgraph-io/badger/v2"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+/*
+	Forgiveness and write-offs reduce outstanding debt without a repayment.
+	The signed reduction is kept with the transactions as the audit record
+	and the loan accumulates the amounts so they can be reported separately.
+*/
+
+// ReduceDebt applies a forgiveness, or a write-off of the remaining balance.
+// A loan reduced to nothing is closed by the reduction, which is reported
+// in the returned events.
+func (app *HELB) ReduceDebt(dr utxi.DebtReduction, writeOff bool) ([]abcitypes.Event, error) {
+	loan, err := app.GetLoan(dr.Loan)
+	if err != nil {
+		return nil, envelope.ErrUnknownLoan.Wrap(err)
+	}
+	if loan.IsClosed() {
+		return nil, envelope.ErrLoanClosed
+	}
+	if loan.IsPooled() {
+		return nil, envelope.ErrUnauthorized.Wrapf("pooled loans belong to the tranche holders")
+	}
+	if !bytes.Equal(loan.Lender, dr.ScriptSig.PublicKey) {
+		return nil, envelope.ErrSignature.Wrapf("reduction is not signed by the lender")
+	}
+	debtTx, err := app.GetOutstandingDebt(dr.Loan)
+	if err != nil {
+		return nil, envelope.ErrUnknownLoan.Wrap(err)
+	}
+	if debtTx.IsConfidential() {
+		return nil, envelope.ErrInvalidTx.Wrapf("loan has a confidential amount")
+	}
+
+	amount := dr.Amount
+	if writeOff {
+		amount = debtTx.Outputs[0].Value
+	}
+	if amount == 0 || amount > debtTx.Outputs[0].Value {
+		return nil, envelope.ErrInsufficientFunds.Wrapf("invalid reduction amount")
+	}
+
+	recordHash := dr.Id()
+	if _, err := app.GetRecord(recordHash); err == nil {
+		return nil, envelope.ErrDuplicateTx
+	}
+	err = app.AddRecord(recordHash, dr.Serialize())
+	if err != nil {
+		return nil, err
+	}
+
+	var events []abcitypes.Event
+	debtTx.Outputs[0].Value = debtTx.Outputs[0].Value - amount
+	if debtTx.DebtIssued() == 0 {
+		err = app.RemoveFromDebtPool(dr.Loan)
+		loan.Closed = recordHash
+		events = append(events, amountEvent("loan.closed", loan, amount, false))
+	} else {
+		err = app.SetOutstandingDebt(dr.Loan, debtTx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if writeOff {
+		loan.WrittenOff = loan.WrittenOff + amount
+	} else {
+		loan.Forgiven = loan.Forgiven + amount
+	}
+	loan.Adjustments = append(loan.Adjustments, recordHash)
+	err = app.AddLoan(loan)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (app *HELB) sumLoans(amount func(utxi.Loan) uint64) (error, int) {
+	total := 0
+	var loan utxi.Loan
+	err := app.loans.View(func(txn *Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func(v []byte) error {
+				loan = utxi.Loan{}
+				jsonErr := json.Unmarshal(v, &loan)
+				if jsonErr != nil {
+					return jsonErr
+				}
+				total = total + int(amount(loan))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err, -10
+	}
+	return nil, total
+}
+
+func (app *HELB) GetTotalForgiven() (error, int) {
+	return app.sumLoans(func(loan utxi.Loan) uint64 { return loan.Forgiven })
+}
+
+func (app *HELB) GetTotalWrittenOff() (error, int) {
+	return app.sumLoans(func(loan utxi.Loan) uint64 { return loan.WrittenOff })
+}
+
+func decodeDebtReduction(encoded string) (utxi.DebtReduction, error) {
+	var dr utxi.DebtReduction
+	reductionBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return dr, err
+	}
+	err = json.Unmarshal(reductionBytes, &dr)
+	return dr, err
+}
+
+// debtReductionCommand handles ForgiveDebt, or WriteOff for the remaining balance.
+func debtReductionCommand(writeOff bool) handler {
+	return func(app *HELB, encoded string) (command, error) {
+		dr, err := decodeDebtReduction(encoded)
+		if err != nil {
+			return command{}, err
+		}
+		return command{
+			validate: func() error {
+				if !dr.IsReductionValid() {
+					return envelope.ErrInvalidTx.Wrapf("debt reduction")
+				}
+				if writeOff != (dr.Command == utxi.WriteOffCommand) {
+					return envelope.ErrInvalidTx.Wrapf("reduction signed for %v", dr.Command)
+				}
+				return nil
+			},
+			check: func() error {
+				if _, err := app.GetRecord(dr.Id()); err == nil {
+					return envelope.ErrDuplicateTx
+				}
+				_, err := app.GetLoan(dr.Loan)
+				if err != nil {
+					return envelope.ErrUnknownLoan.Wrap(err)
+				}
+				return nil
+			},
+			execute: func() (abcitypes.ResponseDeliverTx, error) {
+				events, err := app.ReduceDebt(dr, writeOff)
+				if err != nil {
+					return abcitypes.ResponseDeliverTx{}, fmt.Errorf("ReduceDebt Error: %w", err)
+				}
+				debtQueryErr, systemDebt := app.GetPlaintextDebt()
+				if debtQueryErr != nil {
+					return abcitypes.ResponseDeliverTx{
+						Code: 0,
+						GasWanted: 1,
+						Info: fmt.Sprintf("error: %v", debtQueryErr),
+						Events: events,
+					}, nil
+				}
+				writeOffQueryErr, writtenOff := app.GetTotalWrittenOff()
+				if writeOffQueryErr != nil {
+					return abcitypes.ResponseDeliverTx{
+						Code: 0,
+						GasWanted: 1,
+						Info: fmt.Sprintf("error: %v", writeOffQueryErr),
+						Events: events,
+					}, nil
+				}
+				return abcitypes.ResponseDeliverTx{
+					Code: 0,
+					GasWanted: 1,
+					Info: fmt.Sprintf("Total System Debt: %v, Total Written Off: %v", systemDebt, writtenOff),
+					Events: events,
+				}, nil
+			},
+		}, nil
+	}
+}
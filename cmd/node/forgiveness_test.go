@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"debtchain/pkg/utxi"
+)
+
+func TestForgiveDebt(t *testing.T) {
+	chain := newTestChain(t)
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	loanId := loanOf(chain.issue(t, borrowerAddr, 100))
+
+	if err := chain.deliver(t, "ForgiveDebt", chain.lender.ConstructDebtReduction(loanId, 30, utxi.ReasonHardship)); err != nil {
+		t.Fatal(err)
+	}
+	debtTx, err := chain.GetOutstandingDebt(loanId)
+	if err != nil || debtTx.Outputs[0].Value != 70 {
+		t.Fatalf("outstanding %v, %v; want 70", debtTx.Outputs, err)
+	}
+	loan, err := chain.GetLoan(loanId)
+	if err != nil || loan.Forgiven != 30 || len(loan.Adjustments) != 1 || loan.IsClosed() {
+		t.Fatalf("loan %+v, %v", loan, err)
+	}
+
+	rejected := []struct {
+		name	string
+		dr		utxi.DebtReduction
+	}{
+		{"more than outstanding", chain.lender.ConstructDebtReduction(loanId, 71, utxi.ReasonHardship)},
+		{"nothing", chain.lender.ConstructDebtReduction(loanId, 0, utxi.ReasonHardship)},
+		{"unknown reason", chain.lender.ConstructDebtReduction(loanId, 10, utxi.ReasonCode(99))},
+		{"not the lender", newTestWallet(t).ConstructDebtReduction(loanId, 10, utxi.ReasonHardship)},
+		{"unknown loan", chain.lender.ConstructDebtReduction([]byte("missing"), 10, utxi.ReasonHardship)},
+	}
+	for _, tc := range rejected {
+		if err := chain.deliver(t, "ForgiveDebt", tc.dr); err == nil {
+			t.Errorf("%v: forgiveness accepted", tc.name)
+		}
+	}
+	if err, forgiven := chain.GetTotalForgiven(); err != nil || forgiven != 30 {
+		t.Errorf("total forgiven %v, %v; want 30", forgiven, err)
+	}
+}
+
+func TestWriteOff(t *testing.T) {
+	chain := newTestChain(t)
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	loanId := loanOf(chain.issue(t, borrowerAddr, 100))
+	if err := chain.deliver(t, "ForgiveDebt", chain.lender.ConstructDebtReduction(loanId, 40, utxi.ReasonSettlement)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a reduction signed for one command cannot be delivered as the other
+	if err := chain.deliver(t, "WriteOff", chain.lender.ConstructDebtReduction(loanId, 1, utxi.ReasonUncollectable)); err == nil {
+		t.Error("forgiveness delivered as a write-off")
+	}
+	// a write-off takes the remaining balance
+	if err := chain.deliver(t, "WriteOff", chain.lender.ConstructWriteOff(loanId, utxi.ReasonUncollectable)); err != nil {
+		t.Fatal(err)
+	}
+	loan, err := chain.GetLoan(loanId)
+	if err != nil || loan.WrittenOff != 60 || loan.Forgiven != 40 || !loan.IsClosed() {
+		t.Fatalf("loan %+v, %v", loan, err)
+	}
+	if _, err := chain.GetOutstandingDebt(loanId); err == nil {
+		t.Error("written off debt left in the debt pool")
+	}
+	if err, debt := chain.GetTotalDebt(); err != nil || debt != 0 {
+		t.Errorf("total debt %v, %v; want 0", debt, err)
+	}
+	if err, writtenOff := chain.GetTotalWrittenOff(); err != nil || writtenOff != 60 {
+		t.Errorf("total written off %v, %v; want 60", writtenOff, err)
+	}
+	if err := chain.deliver(t, "ForgiveDebt", chain.lender.ConstructDebtReduction(loanId, 1, utxi.ReasonHardship)); err == nil {
+		t.Error("closed loan forgiven")
+	}
+}
+
+func TestReductionReplay(t *testing.T) {
+	chain := newTestChain(t)
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	loanId := loanOf(chain.issue(t, borrowerAddr, 100))
+	dr := chain.lender.ConstructDebtReduction(loanId, 10, utxi.ReasonHardship)
+	if err := chain.deliver(t, "ForgiveDebt", dr); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "ForgiveDebt", dr); err == nil {
+		t.Error("forgiveness replayed")
+	}
+	// the same terms under a new nonce are a new reduction
+	if err := chain.deliver(t, "ForgiveDebt", chain.lender.ConstructDebtReduction(loanId, 10, utxi.ReasonHardship)); err != nil {
+		t.Fatal(err)
+	}
+	if loan, err := chain.GetLoan(loanId); err != nil || loan.Forgiven != 20 {
+		t.Errorf("loan %+v, %v; want 20 forgiven", loan, err)
+	}
+}
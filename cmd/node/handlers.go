@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+/*
+	Every command goes through the same pipeline: its handler decodes the
+	transaction into a command, which is validated on its own, then
+	against state, then executed. CheckTx and DeliverTx share it, so a
+	transaction admitted to the mempool passed the same checks a block
+	will apply.
+*/
+
+type command struct {
+	// stateless checks
+	validate	func() error
+	// checks against state; checks that need the transaction applied
+	// are left to execute
+	check		func() error
+	// applies the transaction, returning the response to deliver
+	execute		func() (abcitypes.ResponseDeliverTx, error)
+}
+
+type handler func(app *HELB, encoded string) (command, error)
+
+var commandHandlers = map[string]handler{
+	"IssueDebt": issueDebtCommand,
+	"Repayment": repaymentCommand,
+	"CreatePool": createPoolCommand,
+	"TransferTranche": transferTrancheCommand,
+	"NetDebts": netDebtsCommand,
+	"ForgiveDebt": debtReductionCommand(false),
+	"WriteOff": debtReductionCommand(true),
+	"Refinance": refinanceCommand,
+	"Governance": governanceCommand,
+	"Attestation": attestationCommand,
+	"Appraisal": appraisalCommand,
+}
+
+// prepare decodes and checks a transaction, returning the command to
+// execute or the response rejecting it.
+func (app *HELB) prepare(tx []byte) (command, string, *abcitypes.ResponseDeliverTx) {
+	var cmds envelope.Command
+	err := json.Unmarshal(tx, &cmds)
+	if err != nil {
+		return command{}, "", errorResponse(envelope.ErrEncoding.Wrap(err))
+	}
+	handle, ok := commandHandlers[cmds.Command]
+	if !ok {
+		return command{}, cmds.Command, errorResponse(envelope.ErrUnknownCommand.Wrapf("%q", cmds.Command))
+	}
+	cmd, err := handle(app, cmds.Transaction)
+	if err != nil {
+		return command{}, cmds.Command, errorResponse(envelope.ErrEncoding.Wrap(err))
+	}
+	for _, stage := range []func() error{cmd.validate, cmd.check} {
+		if stage == nil {
+			continue
+		}
+		err = stage()
+		if err != nil {
+			return command{}, cmds.Command, errorResponse(err)
+		}
+	}
+	return cmd, cmds.Command, nil
+}
+
+// run executes a prepared command. A command that would overflow the
+// liabilities total fails here, as building the sum tree in Commit could
+// only panic.
+func (app *HELB) run(cmd command) abcitypes.ResponseDeliverTx {
+	res, err := cmd.execute()
+	if err == nil {
+		err = app.updateLiabilities()
+	}
+	if err != nil {
+		return *errorResponse(err)
+	}
+	return res
+}
+
+// errorResponse reports the registered code of err, see envelope.Error.
+func errorResponse(err error) *abcitypes.ResponseDeliverTx {
+	codespace, code, log := envelope.ABCIInfo(err)
+	return &abcitypes.ResponseDeliverTx{
+		Code: code,
+		Codespace: codespace,
+		GasWanted: 1,
+		Log: log,
+	}
+}
+
+func decodeTransaction(encoded string) (utxi.Transaction, error) {
+	var tx utxi.Transaction
+	txBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return tx, err
+	}
+	err = json.Unmarshal(txBytes, &tx)
+	return tx, err
+}
+
+func issueDebtCommand(app *HELB, encoded string) (command, error) {
+	debtTx, err := decodeTransaction(encoded)
+	if err != nil {
+		return command{}, err
+	}
+	return command{
+		validate: func() error {
+			if !debtTx.IsTransactionValid() {
+				return envelope.ErrInvalidTx
+			}
+			return nil
+		},
+		check: func() error {
+			// reissuing a debt would reset its outstanding balance; the loan
+			// id leaves out the inputs, so a new signature is the same loan
+			if _, err := app.GetTransaction(debtTx.Hash()); err == nil {
+				return envelope.ErrDuplicateTx
+			}
+			if _, err := app.GetLoan(utxi.NewLoan(debtTx).Id); err == nil {
+				return envelope.ErrDuplicateTx.Wrapf("loan already issued")
+			}
+			if err := app.checkOriginator(debtTx); err != nil {
+				return err
+			}
+			if err := app.checkBorrowers(debtTx); err != nil {
+				return err
+			}
+			if err := app.checkAssets(debtTx); err != nil {
+				return err
+			}
+			if err := app.checkCollateral(debtTx); err != nil {
+				return envelope.ErrCollateral.Wrap(err)
+			}
+			return app.checkStealthOutputs(debtTx)
+		},
+		execute: func() (abcitypes.ResponseDeliverTx, error) {
+			return app.issueDebt(debtTx)
+		},
+	}, nil
+}
+
+func (app *HELB) issueDebt(debtTx utxi.Transaction) (abcitypes.ResponseDeliverTx, error) {
+	// transaction has to be in a block
+	err := app.AddTransaction(debtTx)
+	if err != nil {
+		return abcitypes.ResponseDeliverTx{}, err
+	}
+	// add utxo to utxo pools
+	err = app.AddToUXTOPool(debtTx)
+	if err != nil {
+		return abcitypes.ResponseDeliverTx{}, err
+	}
+	// create outstanding debt transaction
+	odtx := utxi.MakeOutstandingDebtTx(debtTx)
+	err = app.AddToDebtPool(odtx)
+	if err != nil {
+		return abcitypes.ResponseDeliverTx{}, err
+	}
+	loan := utxi.NewLoan(debtTx)
+	err = app.AddLoan(loan)
+	if err != nil {
+		return abcitypes.ResponseDeliverTx{}, err
+	}
+	err, totalCredits := app.GetPlaintextCredits()
+	if err != nil {
+		return abcitypes.ResponseDeliverTx{}, err
+	}
+	return abcitypes.ResponseDeliverTx{
+		Code: 0,
+		GasWanted: 1,
+		Info: fmt.Sprintf("Total System Credits: %v", totalCredits),
+		Events: []abcitypes.Event{amountEvent("debt.issued", loan, loan.Principal, debtTx.IsConfidential())},
+	}, nil
+}
+
+func repaymentCommand(app *HELB, encoded string) (command, error) {
+	repaymentTx, err := decodeTransaction(encoded)
+	if err != nil {
+		return command{}, err
+	}
+	return command{
+		validate: func() error {
+			if !repaymentTx.IsRepaymentValid() {
+				return envelope.ErrInvalidTx.Wrapf("repayment")
+			}
+			return nil
+		},
+		check: func() error {
+			// a repayment already on chain would reduce the debt a second time
+			if _, err := app.GetTransaction(repaymentTx.Hash()); err == nil {
+				return envelope.ErrDuplicateTx
+			}
+			debtTx, err := app.GetOutstandingDebt(repaymentTx.Inputs[0].Txid)
+			if err != nil {
+				if loan, loanErr := app.GetLoan(repaymentTx.Inputs[0].Txid); loanErr == nil && loan.IsClosed() {
+					return envelope.ErrLoanClosed
+				}
+				return envelope.ErrUnknownLoan.Wrap(err)
+			}
+			// only the borrower can reduce their debt
+			if !repaymentTx.IsSignedBy(debtTx.Outputs[0].RecipientAddr()) {
+				return envelope.ErrSignature.Wrapf("repayment is not signed by the borrower")
+			}
+			return app.checkStealthOutputs(repaymentTx)
+		},
+		execute: func() (abcitypes.ResponseDeliverTx, error) {
+			return app.repay(repaymentTx)
+		},
+	}, nil
+}
+
+func (app *HELB) repay(repaymentTx utxi.Transaction) (abcitypes.ResponseDeliverTx, error) {
+	// add to blockchain
+	err := app.AddTransaction(repaymentTx)
+	if err != nil {
+		return abcitypes.ResponseDeliverTx{}, err
+	}
+	// edit the outstanding debt
+	closed, err := app.HandleRepayment(repaymentTx)
+	if err != nil {
+		return abcitypes.ResponseDeliverTx{}, fmt.Errorf("HandleRepayment Error: %w", err)
+	}
+	var events []abcitypes.Event
+	loan, loanErr := app.GetLoan(repaymentTx.Inputs[0].Txid)
+	if loanErr == nil {
+		events = append(events, amountEvent("debt.repaid", loan, repaymentTx.Outputs[0].Value, repaymentTx.IsConfidential()))
+		// repayments are applied to the loan's schedule in order
+		loan.Repaid = loan.Repaid + repaymentTx.Outputs[0].Value
+		if closed {
+			loan.Closed = repaymentTx.Hash()
+			events = append(events, amountEvent("loan.closed", loan, repaymentTx.Outputs[0].Value, false))
+		}
+		err = app.AddLoan(loan)
+		if err != nil {
+			return abcitypes.ResponseDeliverTx{}, err
+		}
+	}
+	// repayments of pooled loans are paid through to the tranche holders
+	if loanErr == nil && loan.IsPooled() {
+		err = app.DistributeRepayment(loan.Pool, repaymentTx)
+		if err != nil {
+			return abcitypes.ResponseDeliverTx{}, fmt.Errorf("DistributeRepayment Error: %w", err)
+		}
+	}
+	// no need to add the utxo's to the utxo pool for repayments
+	// querying total system debt
+	debtQueryErr, systemDebt := app.GetPlaintextDebt()
+	if debtQueryErr != nil {
+		return abcitypes.ResponseDeliverTx{
+			Code: 0,
+			GasWanted: 1,
+			Info: fmt.Sprintf("error: %v", debtQueryErr),
+			Events: events,
+		}, nil
+	}
+	return abcitypes.ResponseDeliverTx{
+		Code: 0,
+		GasWanted: 1,
+		Info: fmt.Sprintf("Total System Debt: %v", systemDebt),
+		Events: events,
+	}, nil
+}
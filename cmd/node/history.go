@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"debtchain/pkg/smt"
+	"debtchain/internal/envelope"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+/*
+	Past heights. Every commit records, under its height in the state DB,
+	the committed state of the height and the values the block's writes
+	replaced. The stores as they were after a past height are the committed
+	stores with the records of every later height undone, newest first.
+	Records older than the retention window are pruned at commit; with a
+	window of 0 every height is kept.
+*/
+
+type heightRecord struct {
+	State			committedState
+	// values of the keys written by the block before it, by store
+	Undo			map[string][]journalWrite
+}
+
+const historyPrefix = "history/"
+
+func historyKey(height int64) []byte {
+	key := make([]byte, len(historyPrefix) + 8)
+	copy(key, historyPrefix)
+	binary.BigEndian.PutUint64(key[len(historyPrefix):], uint64(height))
+	return key
+}
+
+// undoWrites reads the committed values of the keys a block is about to write.
+func undoWrites(s *Store, writes []journalWrite) ([]journalWrite, error) {
+	undo := make([]journalWrite, 0, len(writes))
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, write := range writes {
+			previous := journalWrite{Key: write.Key}
+			item, err := txn.Get(write.Key)
+			if err == badger.ErrKeyNotFound {
+				previous.Deleted = true
+			} else if err != nil {
+				return err
+			} else if previous.Value, err = item.ValueCopy(nil); err != nil {
+				return err
+			}
+			undo = append(undo, previous)
+		}
+		return nil
+	})
+	return undo, err
+}
+
+func (app *HELB) heightRecord(height int64) (heightRecord, error) {
+	var record heightRecord
+	err := app.state.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(historyKey(height))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &record)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return record, envelope.ErrUnknownHeight.Wrapf("height %v is not retained", height)
+	}
+	return record, err
+}
+
+// pruneHistory deletes the records of the heights outside the retention window.
+func (app *HELB) pruneHistory(txn *badger.Txn, height int64) error {
+	if app.retainHeights <= 0 || height <= app.retainHeights {
+		return nil
+	}
+	oldest := historyKey(height - app.retainHeights + 1)
+	var pruned [][]byte
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(historyPrefix)})
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := it.Item().KeyCopy(nil)
+		if string(key) >= string(oldest) {
+			break
+		}
+		pruned = append(pruned, key)
+	}
+	it.Close()
+	for _, key := range pruned {
+		err := txn.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// at routes every store to its state after a past height, and sets the
+// committed state to that height's, until the returned function is called.
+func (app *HELB) at(height int64) (func(), error) {
+	if height <= 0 || height > app.height {
+		return nil, envelope.ErrUnknownHeight.Wrapf("height %v, last committed height %v", height, app.height)
+	}
+	record, err := app.heightRecord(height)
+	if err != nil {
+		return nil, err
+	}
+	batch := NewBatch()
+	stores := app.stateStores()
+	trees := make(map[string]*smt.Tree)
+	for name, tree := range app.trees {
+		trees[name] = tree
+	}
+	for h := app.height; h > height; h-- {
+		later, err := app.heightRecord(h)
+		if err != nil {
+			batch.Discard()
+			return nil, err
+		}
+		for name, undo := range later.Undo {
+			err = batch.stage(stores[name], undo)
+			if err != nil {
+				batch.Discard()
+				return nil, err
+			}
+			trees[name] = applyWrites(trees[name], undo)
+		}
+	}
+
+	current, currentTrees := app.committedState(), app.trees
+	restore := app.use(batch)
+	app.setCommittedState(record.State)
+	app.trees = trees
+	return func() {
+		app.setCommittedState(current)
+		app.trees = currentTrees
+		restore()
+		batch.Discard()
+	}, nil
+}
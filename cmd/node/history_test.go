@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"debtchain/internal/envelope"
+	"debtchain/pkg/utxi"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestQueryPastHeight(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+
+	chain.beginBlock(time.Unix(1000, 0))
+	debtTx := chain.issue(t, borrowerAddr, 100)
+	chain.Commit()
+	first := chain.lastHash
+	chain.beginBlock(time.Unix(2000, 0))
+	repayment := borrower.ConstructRepaymentTransaction(1, lenderAddr, 30, utxi.MakeOutstandingDebtTx(debtTx), 0)
+	if err := chain.deliver(t, "Repayment", repayment); err != nil {
+		t.Fatal(err)
+	}
+	chain.Commit()
+	last := chain.lastHash
+
+	restore, err := chain.at(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := chain.AppHash(); string(hash) != string(first) {
+		t.Error("state at height 1 does not have its app hash")
+	}
+	if _, err := chain.GetTransaction(repayment.Hash()); err == nil {
+		t.Error("repayment of height 2 visible at height 1")
+	}
+	restore()
+	if hash := chain.AppHash(); string(hash) != string(last) || chain.height != 2 {
+		t.Error("state not restored after reading height 1")
+	}
+
+	// proofs at a past height are against that height's app hash
+	loanId := loanOf(debtTx)
+	res := chain.Query(abcitypes.RequestQuery{Path: "/debt/" + base64.URLEncoding.EncodeToString(loanId), Height: 1, Prove: true})
+	if err := verifyProof(res, first); err != nil || res.Height != 1 {
+		t.Errorf("proof at height %v: %v", res.Height, err)
+	}
+	if err := verifyProof(res, last); err == nil {
+		t.Error("proof at height 1 verifies against the app hash of height 2")
+	}
+
+	for _, height := range []int64{0, 3} {
+		if _, err := chain.at(height); !errors.Is(err, envelope.ErrUnknownHeight) {
+			t.Errorf("height %v: %v", height, err)
+		}
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	chain := newTestChain(t)
+	chain.retainHeights = 2
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	for height := int64(1); height <= 3; height++ {
+		chain.beginBlock(time.Unix(1000 * height, 0))
+		chain.issue(t, borrowerAddr, 10 * uint64(height))
+		chain.Commit()
+	}
+	if _, err := chain.at(1); !errors.Is(err, envelope.ErrUnknownHeight) {
+		t.Errorf("height 1 outside the retention window: %v", err)
+	}
+	restore, err := chain.at(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, total := chain.GetTotalDebt(); err != nil || total != 30 {
+		t.Errorf("total debt %v, %v at height 2; want 30", total, err)
+	}
+	restore()
+	if err, total := chain.GetTotalDebt(); err != nil || total != 60 {
+		t.Errorf("total debt %v, %v after restoring; want 60", total, err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"debtchain/pkg/sumtree"
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+/*
+	Proof of liabilities. The debt pool is summarized by a Merkle sum tree
+	whose root is committed in the app hash every block, so the published
+	total can be checked by every borrower against an inclusion proof for
+	their loans.
+	Leaves are ordered by loan id. Confidential debts have no plaintext
+	amount to sum; their total is proven with the prove/debt query instead.
+	Each batch keeps a running total from the debt pool writes of its
+	transactions, so an overflowing transaction is rejected without
+	rescanning the pool, and Commit only rebuilds the tree when the block
+	changed the debt pool.
+*/
+
+func liabilityLeaf(loanId []byte, debtTx utxi.Transaction) sumtree.Leaf {
+	key := append(append([]byte{}, loanId...), debtTx.Outputs[0].RecipientAddr()...)
+	return sumtree.Leaf{Key: key, Amount: debtTx.DebtIssued()}
+}
+
+func (app *HELB) liabilityLeaves() ([]sumtree.Leaf, []utxi.Transaction, error) {
+	var leaves []sumtree.Leaf
+	var debts []utxi.Transaction
+	err := app.debtPool.View(func(txn *Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			loanId := item.KeyCopy(nil)
+			err := item.Value(func(v []byte) error {
+				var debtTx utxi.Transaction
+				jsonErr := json.Unmarshal(v, &debtTx)
+				if jsonErr != nil {
+					return jsonErr
+				}
+				if debtTx.IsConfidential() {
+					return nil
+				}
+				leaves = append(leaves, liabilityLeaf(loanId, debtTx))
+				debts = append(debts, debtTx)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return leaves, debts, err
+}
+
+// liabilityAmount is the amount a stored debt adds to the liabilities tree.
+func liabilityAmount(stored []byte) (uint64, error) {
+	var debtTx utxi.Transaction
+	err := json.Unmarshal(stored, &debtTx)
+	if err != nil || debtTx.IsConfidential() {
+		return 0, err
+	}
+	return debtTx.DebtIssued(), nil
+}
+
+// resetLiabilities starts the running totals of both batches from the
+// committed liabilities tree.
+func (app *HELB) resetLiabilities() {
+	app.currentBatch.liabilities = app.liabilitiesRoot.Sum
+	app.checkBatch.liabilities = app.liabilitiesRoot.Sum
+}
+
+/*
+	updateLiabilities adds the debt pool writes made since Begin to the
+	running liabilities total of the batch in use, failing where the tree's
+	root sum would overflow. It reads only the keys written, so the total
+	is kept without rescanning the debt pool.
+*/
+func (app *HELB) updateLiabilities() error {
+	batch := app.debtPool.batch
+	changes := batch.changes(app.debtPool)
+	total := batch.liabilities
+	for _, entry := range changes {
+		if !entry.existed {
+			continue
+		}
+		before, err := liabilityAmount(entry.value)
+		if err != nil {
+			return err
+		}
+		total = total - before
+	}
+	for _, entry := range changes {
+		item, err := batch.txn(app.debtPool).Get(entry.key)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stored, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		after, err := liabilityAmount(stored)
+		if err != nil {
+			return err
+		}
+		if total + after < total {
+			return envelope.ErrInvalidTx.Wrapf("total liabilities overflow")
+		}
+		total = total + after
+	}
+	batch.liabilities = total
+	return nil
+}
+
+func (app *HELB) LiabilitiesTree() (*sumtree.Tree, error) {
+	leaves, _, err := app.liabilityLeaves()
+	if err != nil {
+		return nil, err
+	}
+	return sumtree.New(leaves)
+}
+
+// ProveLiabilities returns an inclusion proof for each loan of the borrower.
+func (app *HELB) ProveLiabilities(borrower []byte) ([]sumtree.Proof, error) {
+	leaves, debts, err := app.liabilityLeaves()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := sumtree.New(leaves)
+	if err != nil {
+		return nil, err
+	}
+	proofs := []sumtree.Proof{}
+	for i, debtTx := range debts {
+		if !bytes.Equal(debtTx.Outputs[0].RecipientAddr(), borrower) {
+			continue
+		}
+		proof, err := tree.Prove(i, leaves[i])
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proof)
+	}
+	return proofs, nil
+}
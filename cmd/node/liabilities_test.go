@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"debtchain/pkg/utxi"
+)
+
+func TestLiabilitiesOverflowRejected(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	first, _ := borrower.PublicKey(1)
+	second, _ := borrower.PublicKey(2)
+	chain.attest(t, second)
+	chain.beginBlock(time.Unix(1000, 0))
+	chain.issue(t, first, ^uint64(0) - 10)
+	if err := chain.deliver(t, "IssueDebt", chain.lender.ConstructDebtTransaction(second, 20)); err == nil {
+		t.Error("debt overflowing the liabilities total delivered")
+	}
+	if _, err := chain.GetOutstandingDebt(loanOf(chain.lender.ConstructDebtTransaction(second, 20))); err == nil {
+		t.Error("rejected debt left in the debt pool")
+	}
+	// Commit builds the sum tree of what was delivered
+	chain.Commit()
+	if chain.liabilitiesRoot.Sum != ^uint64(0) - 10 {
+		t.Errorf("liabilities %v after commit", chain.liabilitiesRoot.Sum)
+	}
+}
+
+func TestLiabilitiesRunningTotal(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	first, _ := borrower.PublicKey(1)
+	second, _ := borrower.PublicKey(2)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+	chain.attest(t, second)
+	chain.beginBlock(time.Unix(1000, 0))
+	debtTx := chain.issue(t, first, ^uint64(0) - 10)
+	chain.Commit()
+
+	// a repayment makes room in the total for the next block's debt
+	chain.beginBlock(time.Unix(2000, 0))
+	repayment := borrower.ConstructRepaymentTransaction(1, lenderAddr, 15, utxi.MakeOutstandingDebtTx(debtTx), 0)
+	if err := chain.deliver(t, "Repayment", repayment); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "IssueDebt", chain.lender.ConstructDebtTransaction(second, 20)); err != nil {
+		t.Errorf("debt within the repaid room rejected: %v", err)
+	}
+	chain.Commit()
+	if chain.liabilitiesRoot.Sum != ^uint64(0) - 5 {
+		t.Errorf("liabilities %v after commit", chain.liabilitiesRoot.Sum)
+	}
+}
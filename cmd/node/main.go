@@ -22,9 +22,13 @@ import (
 )
 
 var configFile string
+var auditKeyFile string
+var retainHeights int64
 
 func init() {
 	flag.StringVar(&configFile, "config", "$HOME/.tendermint/config/config.toml", "Path to config.toml")
+	flag.StringVar(&auditKeyFile, "audit-key", "", "Path to the audit private key opening confidential amounts")
+	flag.Int64Var(&retainHeights, "retain-heights", 1000, "Number of past heights kept for queries, 0 keeps every height")
 }
 
 func main() {
@@ -50,10 +54,67 @@ func main() {
 	}
 	defer debtdb.Close()
 
-	app := NewHELB(db, utxodb, debtdb)
+	loandb, err := badger.Open(badger.DefaultOptions("/tmp/badger/loan/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open badger  db (loan): %v", err)
+		os.Exit(1)
+	}
+	defer loandb.Close()
+
+	pooldb, err := badger.Open(badger.DefaultOptions("/tmp/badger/pool/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open badger  db (pool): %v", err)
+		os.Exit(1)
+	}
+	defer pooldb.Close()
+
+	registrydb, err := badger.Open(badger.DefaultOptions("/tmp/badger/registry/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open badger  db (registry): %v", err)
+		os.Exit(1)
+	}
+	defer registrydb.Close()
+
+	attestationdb, err := badger.Open(badger.DefaultOptions("/tmp/badger/attestation/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open badger  db (attestation): %v", err)
+		os.Exit(1)
+	}
+	defer attestationdb.Close()
+
+	oracledb, err := badger.Open(badger.DefaultOptions("/tmp/badger/oracle/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open badger  db (oracle): %v", err)
+		os.Exit(1)
+	}
+	defer oracledb.Close()
+
+	statedb, err := badger.Open(badger.DefaultOptions("/tmp/badger/state/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open badger  db (state): %v", err)
+		os.Exit(1)
+	}
+	defer statedb.Close()
 
 	flag.Parse()
 
+	app := NewHELB(db, utxodb, debtdb, loandb, pooldb, registrydb, attestationdb, oracledb, statedb)
+	// recovering an interrupted commit prunes with the configured window
+	app.retainHeights = retainHeights
+	err = app.LoadState()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load committed state: %v", err)
+		os.Exit(1)
+	}
+
+	if auditKeyFile != "" {
+		app.auditKey, err = loadAuditKey(auditKeyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load audit key: %v", err)
+			os.Exit(1)
+		}
+	}
+
 	node, err := newTendermint(app, configFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v", err)
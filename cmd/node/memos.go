@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+
+	"debtchain/pkg/utxi"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+// recordedOutputs lists the outputs of every recorded transaction that match.
+func (app *HELB) recordedOutputs(match func(utxi.TxOutput) bool) ([]utxi.RecordedOutput, error) {
+	outputs := []utxi.RecordedOutput{}
+	err := app.transactions.View(func(txn *Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			txid := item.KeyCopy(nil)
+			err := item.Value(func(v []byte) error {
+				// other records stored with the transactions decode without outputs
+				var tx utxi.Transaction
+				if json.Unmarshal(v, &tx) != nil {
+					return nil
+				}
+				for vout, output := range tx.Outputs {
+					if match(output) {
+						outputs = append(outputs, utxi.RecordedOutput{Txid: txid, Vout: int64(vout), Output: output})
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return outputs, err
+}
+
+// GetMemoOutputs lists every recorded output with a memo for wallets and
+// auditors to scan with their view keys.
+func (app *HELB) GetMemoOutputs() ([]utxi.RecordedOutput, error) {
+	return app.recordedOutputs(func(output utxi.TxOutput) bool {
+		return len(output.Memo) > 0
+	})
+}
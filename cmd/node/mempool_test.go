@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"debtchain/pkg/utxi"
+)
+
+func TestCheckStateConflicts(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+	chain.beginBlock(time.Unix(1000, 0))
+	debtTx := chain.issue(t, borrowerAddr, 100)
+	chain.Commit()
+	odtx := utxi.MakeOutstandingDebtTx(debtTx)
+
+	if err := chain.check(t, "IssueDebt", debtTx); err == nil {
+		t.Error("issued debt admitted to the mempool again")
+	}
+	first := borrower.ConstructRepaymentTransaction(1, lenderAddr, 60, odtx, 0)
+	if err := chain.check(t, "Repayment", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.check(t, "Repayment", first); err == nil {
+		t.Error("repayment admitted to the mempool twice")
+	}
+	// together with the first, the second overpays the debt
+	second := borrower.ConstructRepaymentTransaction(1, lenderAddr, 50, odtx, 0)
+	if err := chain.check(t, "Repayment", second); err == nil {
+		t.Error("repayment conflicting with the mempool admitted")
+	}
+	// the check state does not reach the committed debt
+	if err, total := chain.GetTotalDebt(); err != nil || total != 100 {
+		t.Errorf("total debt %v, %v after CheckTx; want 100", total, err)
+	}
+
+	// the block leaves the first repayment out; after the commit the
+	// mempool is rechecked against the committed state alone
+	chain.beginBlock(time.Unix(2000, 0))
+	chain.Commit()
+	if err := chain.check(t, "Repayment", second); err != nil {
+		t.Errorf("repayment rejected after the check state was reset: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+/*
+	Multilateral netting: debts between a set of institutions are closed and
+	replaced by the net obligations between them. System debt drops by the
+	amount netted away; every participant's net position is unchanged.
+*/
+
+// NetDebts returns the loans created to replace the netted ones, and the
+// events closing the netted loans and issuing their replacements.
+func (app *HELB) NetDebts(ntx utxi.NettingTransaction) ([]utxi.Loan, []abcitypes.Event, error) {
+	signers := ntx.SignedBy()
+	seen := make(map[string]bool)
+
+	var obligations []utxi.Obligation
+	netted := make([]utxi.Loan, 0, len(ntx.Loans))
+	for _, id := range ntx.Loans {
+		idStr := base64.URLEncoding.EncodeToString(id)
+		if seen[idStr] {
+			return nil, nil, envelope.ErrInvalidTx.Wrapf("loan %v listed twice", idStr)
+		}
+		seen[idStr] = true
+
+		loan, err := app.GetLoan(id)
+		if err != nil {
+			return nil, nil, envelope.ErrUnknownLoan.Wrapf("loan %v: %v", idStr, err)
+		}
+		if loan.IsClosed() {
+			return nil, nil, envelope.ErrLoanClosed.Wrapf("loan %v", idStr)
+		}
+		if loan.IsPooled() {
+			return nil, nil, envelope.ErrUnauthorized.Wrapf("loan %v belongs to the tranche holders", idStr)
+		}
+		if !signers[base64.URLEncoding.EncodeToString(loan.Lender)] {
+			return nil, nil, envelope.ErrSignature.Wrapf("missing lender signature for loan %v", idStr)
+		}
+		debtTx, err := app.GetOutstandingDebt(id)
+		if err != nil {
+			return nil, nil, envelope.ErrUnknownLoan.Wrapf("loan %v: %v", idStr, err)
+		}
+		if debtTx.IsConfidential() {
+			return nil, nil, envelope.ErrInvalidTx.Wrapf("loan %v has a confidential amount", idStr)
+		}
+		obligations = append(obligations, utxi.Obligation{
+			Debtor: loan.Borrower,
+			Creditor: loan.Lender,
+			Amount: debtTx.DebtIssued(),
+			Asset: loan.Asset,
+		})
+		netted = append(netted, loan)
+	}
+	if len(netted) < 2 {
+		return nil, nil, envelope.ErrInvalidTx.Wrapf("netting needs at least two loans")
+	}
+
+	nettedObligations, err := utxi.NetObligations(obligations)
+	if err != nil {
+		return nil, nil, envelope.ErrInvalidTx.Wrap(err)
+	}
+
+	closingHash := ntx.Hash()
+	err = app.AddRecord(closingHash, ntx.Serialize())
+	if err != nil {
+		return nil, nil, err
+	}
+	var events []abcitypes.Event
+	for i, loan := range netted {
+		err = app.RemoveFromDebtPool(loan.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		loan.Closed = closingHash
+		err = app.AddLoan(loan)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, amountEvent("loan.closed", loan, obligations[i].Amount, false))
+	}
+
+	var created []utxi.Loan
+	for i, obligation := range nettedObligations {
+		loan := utxi.Loan{
+			Id: utxi.DerivedLoanId(closingHash, i),
+			Lender: obligation.Creditor,
+			Borrower: obligation.Debtor,
+			Principal: obligation.Amount,
+			Asset: obligation.Asset,
+			Replaces: ntx.Loans,
+		}
+		odtx := utxi.Transaction{
+			Outputs: []utxi.TxOutput{utxi.ConstructAssetOutput(obligation.Debtor, obligation.Asset, obligation.Amount)},
+		}
+		err = app.SetOutstandingDebt(loan.Id, odtx)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = app.AddLoan(loan)
+		if err != nil {
+			return nil, nil, err
+		}
+		created = append(created, loan)
+		events = append(events, amountEvent("debt.issued", loan, loan.Principal, false))
+	}
+	return created, events, nil
+}
+
+func decodeNettingTransaction(encoded string) (utxi.NettingTransaction, error) {
+	var ntx utxi.NettingTransaction
+	nettingBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ntx, err
+	}
+	err = json.Unmarshal(nettingBytes, &ntx)
+	return ntx, err
+}
+
+func netDebtsCommand(app *HELB, encoded string) (command, error) {
+	ntx, err := decodeNettingTransaction(encoded)
+	if err != nil {
+		return command{}, err
+	}
+	return command{
+		validate: func() error {
+			if len(ntx.Loans) < 2 {
+				return envelope.ErrInvalidTx.Wrapf("netting transaction")
+			}
+			return nil
+		},
+		execute: func() (abcitypes.ResponseDeliverTx, error) {
+			created, events, err := app.NetDebts(ntx)
+			if err != nil {
+				return abcitypes.ResponseDeliverTx{}, fmt.Errorf("NetDebts Error: %w", err)
+			}
+			debtQueryErr, systemDebt := app.GetPlaintextDebt()
+			if debtQueryErr != nil {
+				return abcitypes.ResponseDeliverTx{
+					Code: 0,
+					GasWanted: 1,
+					Info: fmt.Sprintf("error: %v", debtQueryErr),
+					Events: events,
+				}, nil
+			}
+			return abcitypes.ResponseDeliverTx{
+				Code: 0,
+				GasWanted: 1,
+				Info: fmt.Sprintf("Net Debts: %v, Total System Debt: %v", len(created), systemDebt),
+				Events: events,
+			}, nil
+		},
+	}, nil
+}
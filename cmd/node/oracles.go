@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	"github.com/dgraph-io/badger/v2"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/kv"
+)
+
+/*
+	Collateral valuation. The oracle store keeps the latest appraisal from
+	every oracle for each collateral id and the median over the fresh
+	appraisals of registered oracles once there are enough of them. Debt
+	secured by collateral is checked against the value at issuance, and a new
+	value reports the loans it pushes past the liquidation threshold.
+*/
+
+func appraisalPrefix(collateral string) []byte {
+	return []byte("appraisal/" + collateral + "/")
+}
+
+func appraisalKey(collateral string, oracle []byte) []byte {
+	return append(appraisalPrefix(collateral), oracle...)
+}
+
+func collateralValueKey(collateral string) []byte {
+	return []byte("value/" + collateral)
+}
+
+func (app *HELB) isFresh(appraisalTime int64, params OracleParams) bool {
+	return params.MaxAge == 0 || appraisalTime > app.blockTime - params.MaxAge
+}
+
+// SubmitAppraisal records an oracle's appraisal and revalues the collateral.
+func (app *HELB) SubmitAppraisal(ap utxi.Appraisal) ([]abcitypes.Event, error) {
+	registered, err := app.IsOracle(ap.Oracle())
+	if err != nil {
+		return nil, err
+	}
+	if !registered {
+		return nil, envelope.ErrUnauthorized.Wrapf("oracle is not registered")
+	}
+	params, err := app.getOracleParams()
+	if err != nil {
+		return nil, err
+	}
+	if ap.Time > app.blockTime || !app.isFresh(ap.Time, params) {
+		return nil, envelope.ErrInvalidTx.Wrapf("appraisal time is outside the accepted window")
+	}
+
+	err = app.oracles.Update(func(txn *Txn) error {
+		item, err := txn.Get(appraisalKey(ap.Collateral, ap.Oracle()))
+		if err == nil {
+			var previous utxi.Appraisal
+			err = item.Value(func(v []byte) error {
+				return json.Unmarshal(v, &previous)
+			})
+			if err != nil {
+				return err
+			}
+			if previous.Time >= ap.Time {
+				return envelope.ErrInvalidTx.Wrapf("oracle already appraised the collateral at a later time")
+			}
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		return txn.Set(appraisalKey(ap.Collateral, ap.Oracle()), ap.Serialize())
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = app.AddRecord(ap.Hash(), ap.Serialize())
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := app.revalue(ap.Collateral, params)
+	if err != nil || value.Appraisals == 0 {
+		return nil, err
+	}
+	return app.undercollateralizedEvents(value, params)
+}
+
+// revalue takes the median of the usable appraisals; below the quorum the
+// collateral has no value.
+func (app *HELB) revalue(collateral string, params OracleParams) (utxi.CollateralValue, error) {
+	var appraisals []utxi.Appraisal
+	err := app.oracles.View(func(txn *Txn) error {
+		prefix := appraisalPrefix(collateral)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var ap utxi.Appraisal
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, &ap)
+			})
+			if err != nil {
+				return err
+			}
+			// longer collateral ids can share the prefix
+			if ap.Collateral != collateral {
+				continue
+			}
+			appraisals = append(appraisals, ap)
+		}
+		return nil
+	})
+	if err != nil {
+		return utxi.CollateralValue{}, err
+	}
+
+	usable := appraisals[:0]
+	for _, ap := range appraisals {
+		registered, err := app.IsOracle(ap.Oracle())
+		if err != nil {
+			return utxi.CollateralValue{}, err
+		}
+		if registered && app.isFresh(ap.Time, params) {
+			usable = append(usable, ap)
+		}
+	}
+	if len(usable) < params.Quorum {
+		return utxi.CollateralValue{}, app.oracles.Update(func(txn *Txn) error {
+			return txn.Delete(collateralValueKey(collateral))
+		})
+	}
+	value := utxi.MedianAppraisal(usable)
+	valueAsJson, _ := json.Marshal(value)
+	return value, app.oracles.Update(func(txn *Txn) error {
+		return txn.Set(collateralValueKey(collateral), valueAsJson)
+	})
+}
+
+// GetCollateralValue returns the latest value, failing if it has gone stale.
+func (app *HELB) GetCollateralValue(collateral string) (utxi.CollateralValue, error) {
+	var value utxi.CollateralValue
+	err := app.oracles.View(func(txn *Txn) error {
+		item, err := txn.Get(collateralValueKey(collateral))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &value)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return value, fmt.Errorf("collateral %v has no value", collateral)
+	}
+	if err != nil {
+		return value, err
+	}
+	params, err := app.getOracleParams()
+	if err != nil {
+		return value, err
+	}
+	if !app.isFresh(value.Time, params) {
+		return value, fmt.Errorf("value of collateral %v is stale", collateral)
+	}
+	return value, nil
+}
+
+// checkCollateral keeps secured debt within the maximum loan-to-value.
+func (app *HELB) checkCollateral(debtTx utxi.Transaction) error {
+	if debtTx.Collateral == "" {
+		return nil
+	}
+	value, err := app.GetCollateralValue(debtTx.Collateral)
+	if err != nil {
+		return err
+	}
+	params, err := app.getOracleParams()
+	if err != nil || params.MaxLtvBps == 0 {
+		return err
+	}
+	if debtTx.IsConfidential() {
+		return errors.New("loan-to-value of a confidential amount cannot be checked")
+	}
+	return app.checkLoanToValue(value, params, debtTx.DebtIssued(), 0)
+}
+
+// checkLoanToValue rejects new debt of amount against the collateral when its
+// open loans, less the released debt they are closed for, would exceed the
+// maximum loan-to-value.
+func (app *HELB) checkLoanToValue(value utxi.CollateralValue, params OracleParams, amount, released uint64) error {
+	// other open loans against the same collateral count towards the limit
+	debt, err := app.collateralDebt(value.Collateral)
+	if err != nil {
+		return err
+	}
+	if released > debt {
+		return fmt.Errorf("released debt %v exceeds the collateral's debt %v", released, debt)
+	}
+	debt = debt - released
+	if debt + amount < debt {
+		return errors.New("debt against the collateral overflows")
+	}
+	ltv := utxi.LoanToValueBps(debt + amount, value.Value)
+	if ltv > params.MaxLtvBps {
+		return fmt.Errorf("loan-to-value %v bps exceeds %v bps", ltv, params.MaxLtvBps)
+	}
+	return nil
+}
+
+func (app *HELB) collateralLoans(collateral string) ([]utxi.Loan, error) {
+	return app.openLoans(func(loan utxi.Loan) bool {
+		return loan.Collateral == collateral
+	})
+}
+
+// collateralDebt sums the outstanding debt secured by the collateral.
+func (app *HELB) collateralDebt(collateral string) (uint64, error) {
+	loans, err := app.collateralLoans(collateral)
+	if err != nil {
+		return 0, err
+	}
+	var debt uint64
+	for _, loan := range loans {
+		debtTx, err := app.GetOutstandingDebt(loan.Id)
+		if err != nil {
+			return 0, err
+		}
+		debt = debt + debtTx.DebtIssued()
+	}
+	return debt, nil
+}
+
+/*
+	undercollateralizedEvents reports the loans secured by the collateral
+	once their combined loan-to-value is past the liquidation threshold, for
+	the lenders' liquidation processes to act on.
+*/
+func (app *HELB) undercollateralizedEvents(value utxi.CollateralValue, params OracleParams) ([]abcitypes.Event, error) {
+	if params.LiquidationLtvBps == 0 {
+		return nil, nil
+	}
+	loans, err := app.collateralLoans(value.Collateral)
+	if err != nil {
+		return nil, err
+	}
+	// as at issuance, the loan-to-value counts every loan the collateral secures
+	debt, err := app.collateralDebt(value.Collateral)
+	if err != nil {
+		return nil, err
+	}
+	ltv := utxi.LoanToValueBps(debt, value.Value)
+	if ltv <= params.LiquidationLtvBps {
+		return nil, nil
+	}
+	var events []abcitypes.Event
+	for _, loan := range loans {
+		events = append(events, loanEvent("loan.undercollateralized", loan,
+			kv.Pair{Key: []byte("collateral"), Value: []byte(value.Collateral)},
+			kv.Pair{Key: []byte("ltv_bps"), Value: []byte(strconv.FormatUint(ltv, 10))},
+		))
+	}
+	return events, nil
+}
+
+func decodeAppraisal(encoded string) (utxi.Appraisal, error) {
+	var ap utxi.Appraisal
+	appraisalBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ap, err
+	}
+	err = json.Unmarshal(appraisalBytes, &ap)
+	return ap, err
+}
+
+func appraisalCommand(app *HELB, encoded string) (command, error) {
+	ap, err := decodeAppraisal(encoded)
+	if err != nil {
+		return command{}, err
+	}
+	return command{
+		validate: func() error {
+			if !ap.IsAppraisalValid() {
+				return envelope.ErrInvalidTx.Wrapf("appraisal")
+			}
+			return nil
+		},
+		execute: func() (abcitypes.ResponseDeliverTx, error) {
+			events, err := app.SubmitAppraisal(ap)
+			if err != nil {
+				return abcitypes.ResponseDeliverTx{}, fmt.Errorf("SubmitAppraisal Error: %w", err)
+			}
+			value, valueErr := app.GetCollateralValue(ap.Collateral)
+			if valueErr != nil {
+				return abcitypes.ResponseDeliverTx{
+					Code: 0,
+					GasWanted: 1,
+					Info: fmt.Sprintf("Collateral %v: %v", ap.Collateral, valueErr),
+				}, nil
+			}
+			return abcitypes.ResponseDeliverTx{
+				Code: 0,
+				GasWanted: 1,
+				Info: fmt.Sprintf("Collateral %v Value: %v", ap.Collateral, value.Value),
+				Events: events,
+			}, nil
+		},
+	}, nil
+}
+
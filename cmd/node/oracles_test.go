@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"debtchain/internal/wallet"
+	"debtchain/pkg/utxi"
+)
+
+// newOracleChain registers three oracles, two of which value collateral.
+func newOracleChain(t *testing.T) (*testChain, []*wallet.Wallet) {
+	t.Helper()
+	oracles := []*wallet.Wallet{newTestWallet(t), newTestWallet(t), newTestWallet(t)}
+	chain := newTestChain(t, func(state *GenesisState) {
+		for _, oracle := range oracles {
+			key, _ := oracle.PublicKey(1)
+			state.Oracles = append(state.Oracles, key)
+		}
+		state.Oracle = OracleParams{Quorum: 2, MaxAge: 1000, MaxLtvBps: 8000, LiquidationLtvBps: 9000}
+	})
+	return chain, oracles
+}
+
+func TestAppraisalMedian(t *testing.T) {
+	chain, oracles := newOracleChain(t)
+	now := time.Now().Unix()
+	chain.beginBlock(time.Unix(now, 0))
+
+	if err := chain.deliver(t, "Appraisal", oracles[0].ConstructAppraisal("house", 300, now)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.GetCollateralValue("house"); err == nil {
+		t.Error("collateral valued below the quorum")
+	}
+	if err := chain.deliver(t, "Appraisal", oracles[1].ConstructAppraisal("house", 100, now)); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := chain.GetCollateralValue("house"); err != nil || value.Value != 100 {
+		t.Errorf("value %+v, %v; want the lower middle 100", value, err)
+	}
+	if err := chain.deliver(t, "Appraisal", oracles[2].ConstructAppraisal("house", 200, now)); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := chain.GetCollateralValue("house"); err != nil || value.Value != 200 || value.Appraisals != 3 {
+		t.Errorf("value %+v, %v; want 200 over 3 appraisals", value, err)
+	}
+
+	rejected := []struct {
+		name	string
+		ap		utxi.Appraisal
+	}{
+		{"unregistered oracle", newTestWallet(t).ConstructAppraisal("house", 100, now)},
+		{"from the future", oracles[0].ConstructAppraisal("house", 100, now + 10)},
+		{"stale", oracles[0].ConstructAppraisal("house", 100, now - 1000)},
+		{"not newer than the oracle's last", oracles[0].ConstructAppraisal("house", 100, now)},
+	}
+	for _, tc := range rejected {
+		if err := chain.deliver(t, "Appraisal", tc.ap); err == nil {
+			t.Errorf("%v: appraisal accepted", tc.name)
+		}
+	}
+
+	// appraisals age out with the block time
+	chain.beginBlock(time.Unix(now + 1000, 0))
+	if _, err := chain.GetCollateralValue("house"); err == nil {
+		t.Error("stale value returned")
+	}
+}
+
+func securedDebt(lender *wallet.Wallet, borrower []byte, amount uint64, collateral string) utxi.Transaction {
+	debtTx := lender.ConstructDebtTransaction(borrower, amount)
+	debtTx.Collateral = collateral
+	// the lender signs every field but the signatures
+	lender.SignTransaction(1, &debtTx)
+	return debtTx
+}
+
+func TestLoanToValue(t *testing.T) {
+	chain, oracles := newOracleChain(t)
+	now := time.Now().Unix()
+	chain.beginBlock(time.Unix(now, 0))
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+
+	if err := chain.deliver(t, "IssueDebt", securedDebt(chain.lender, borrowerAddr, 10, "house")); err == nil {
+		t.Fatal("debt secured by unvalued collateral issued")
+	}
+	for _, oracle := range oracles[:2] {
+		if err := chain.deliver(t, "Appraisal", oracle.ConstructAppraisal("house", 1000, now)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := chain.deliver(t, "IssueDebt", securedDebt(chain.lender, borrowerAddr, 801, "house")); err == nil {
+		t.Error("debt past the maximum loan-to-value issued")
+	}
+	if err := chain.deliver(t, "IssueDebt", securedDebt(chain.lender, borrowerAddr, 500, "house")); err != nil {
+		t.Fatal(err)
+	}
+	// earlier loans against the collateral count towards the limit
+	otherAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, otherAddr)
+	if err := chain.deliver(t, "IssueDebt", securedDebt(chain.lender, otherAddr, 301, "house")); err == nil {
+		t.Error("second loan past the maximum loan-to-value issued")
+	}
+	if err := chain.deliver(t, "IssueDebt", securedDebt(chain.lender, otherAddr, 300, "house")); err != nil {
+		t.Fatal(err)
+	}
+
+	res := chain.deliverTx(t, "Appraisal", oracles[2].ConstructAppraisal("house", 1000, now))
+	if res.Code != 0 || len(res.Events) != 0 {
+		t.Errorf("appraisal at the same value: %v, events %v", res.Info, res.Events)
+	}
+	// once two of three oracles value it at 550, the 800 the collateral
+	// secures is past the 90% liquidation threshold
+	chain.beginBlock(time.Unix(now + 1, 0))
+	res = chain.deliverTx(t, "Appraisal", oracles[0].ConstructAppraisal("house", 550, now + 1))
+	if res.Code != 0 || len(res.Events) != 0 {
+		t.Errorf("first lower appraisal: %v, events %v", res.Info, res.Events)
+	}
+	res = chain.deliverTx(t, "Appraisal", oracles[1].ConstructAppraisal("house", 550, now + 1))
+	if res.Code != 0 {
+		t.Fatal(res.Info)
+	}
+	// the loan-to-value counts both loans, as at issuance, so both are reported
+	if len(res.Events) != 2 || res.Events[0].Type != "loan.undercollateralized" || res.Events[1].Type != "loan.undercollateralized" {
+		t.Errorf("events %v, want both loans undercollateralized", res.Events)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+/*
+	Securitization: loans are bundled into pools whose tranche tokens are
+	paid from repayments in waterfall order.
+*/
+
+func (app *HELB) AddLoanPool(pool utxi.LoanPool) error {
+	return app.loanPools.Update(func(txn *Txn) error {
+		return txn.Set(pool.Id, pool.Serialize())
+	})
+}
+
+func (app *HELB) GetLoanPool(id []byte) (utxi.LoanPool, error) {
+	var pool utxi.LoanPool
+	err := app.loanPools.View(func(txn *Txn) error {
+		item, err := txn.Get(id)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &pool)
+		})
+	})
+	return pool, err
+}
+
+// CreateLoanPool checks that the originator lent every loan in the pool, in a
+// single asset, and that none of them is pooled already, then records the
+// pool on the loans.
+func (app *HELB) CreateLoanPool(pool utxi.LoanPool) error {
+	pool.Id = pool.Hash()
+	if _, err := app.GetLoanPool(pool.Id); err == nil {
+		return envelope.ErrDuplicateTx.Wrapf("pool already exists")
+	}
+
+	pooled := make([]utxi.Loan, 0, len(pool.Loans))
+	for _, id := range pool.Loans {
+		loan, err := app.GetLoan(id)
+		if err != nil {
+			return envelope.ErrUnknownLoan.Wrapf("loan %v: %v", base64.URLEncoding.EncodeToString(id), err)
+		}
+		if !bytes.Equal(loan.Lender, pool.Originator()) {
+			return envelope.ErrUnauthorized.Wrapf("pool originator is not the lender of every loan")
+		}
+		if loan.IsPooled() {
+			return envelope.ErrInvalidTx.Wrapf("loan is already pooled")
+		}
+		// tranches are paid in the asset of the loans
+		if len(pooled) > 0 && loan.Asset != pooled[0].Asset {
+			return envelope.ErrInvalidTx.Wrapf("pooled loans must share an asset")
+		}
+		if loan.IsClosed() {
+			return envelope.ErrLoanClosed
+		}
+		// the waterfall needs the repaid amounts
+		debtTx, err := app.GetOutstandingDebt(id)
+		if err != nil {
+			return envelope.ErrUnknownLoan.Wrap(err)
+		}
+		if debtTx.IsConfidential() {
+			return envelope.ErrInvalidTx.Wrapf("loan has a confidential amount")
+		}
+		loan.Pool = pool.Id
+		pooled = append(pooled, loan)
+	}
+
+	err := app.AddLoanPool(pool)
+	if err != nil {
+		return err
+	}
+	for _, loan := range pooled {
+		err = app.AddLoan(loan)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TransferTranche applies a transfer once; it is recorded under its id.
+func (app *HELB) TransferTranche(transfer utxi.TrancheTransfer) error {
+	if _, err := app.GetRecord(transfer.Id()); err == nil {
+		return envelope.ErrDuplicateTx
+	}
+	pool, err := app.GetLoanPool(transfer.Pool)
+	if err != nil {
+		return envelope.ErrNotFound.Wrapf("pool: %v", err)
+	}
+	err = pool.Apply(transfer)
+	if err != nil {
+		return envelope.ErrInvalidTx.Wrap(err)
+	}
+	err = app.AddRecord(transfer.Id(), transfer.Serialize())
+	if err != nil {
+		return err
+	}
+	return app.AddLoanPool(pool)
+}
+
+// DistributeRepayment pays a repayment into the pool through its tranches.
+// The payout is recorded as a transaction spending the repayment; like the
+// repayment itself it is not added to the utxo pool.
+func (app *HELB) DistributeRepayment(poolId []byte, rpTx utxi.Transaction) error {
+	pool, err := app.GetLoanPool(poolId)
+	if err != nil {
+		return err
+	}
+	outputs := pool.Distribute(rpTx.Outputs[0].Value)
+	for i := range outputs {
+		outputs[i].Asset = rpTx.Outputs[0].Asset
+	}
+	err = app.AddLoanPool(pool)
+	if err != nil {
+		return err
+	}
+
+	distributionTx := utxi.Transaction{
+		Inputs: []utxi.TxInput{{Txid: rpTx.Hash(), Vout: 0}},
+		Outputs: outputs,
+	}
+	return app.AddTransaction(distributionTx)
+}
+
+func decodeLoanPool(encoded string) (utxi.LoanPool, error) {
+	var pool utxi.LoanPool
+	poolBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return pool, err
+	}
+	err = json.Unmarshal(poolBytes, &pool)
+	return pool, err
+}
+
+func decodeTrancheTransfer(encoded string) (utxi.TrancheTransfer, error) {
+	var transfer utxi.TrancheTransfer
+	transferBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return transfer, err
+	}
+	err = json.Unmarshal(transferBytes, &transfer)
+	return transfer, err
+}
+
+func createPoolCommand(app *HELB, encoded string) (command, error) {
+	pool, err := decodeLoanPool(encoded)
+	if err != nil {
+		return command{}, err
+	}
+	return command{
+		validate: func() error {
+			if !pool.IsPoolValid() {
+				return envelope.ErrInvalidTx.Wrapf("pool")
+			}
+			return nil
+		},
+		execute: func() (abcitypes.ResponseDeliverTx, error) {
+			err := app.CreateLoanPool(pool)
+			if err != nil {
+				return abcitypes.ResponseDeliverTx{}, fmt.Errorf("CreateLoanPool Error: %w", err)
+			}
+			return abcitypes.ResponseDeliverTx{
+				Code: 0,
+				GasWanted: 1,
+				Info: fmt.Sprintf("Pool: %v", base64.URLEncoding.EncodeToString(pool.Hash())),
+			}, nil
+		},
+	}, nil
+}
+
+func transferTrancheCommand(app *HELB, encoded string) (command, error) {
+	transfer, err := decodeTrancheTransfer(encoded)
+	if err != nil {
+		return command{}, err
+	}
+	return command{
+		validate: func() error {
+			if !transfer.IsTransferValid() {
+				return envelope.ErrInvalidTx.Wrapf("tranche transfer")
+			}
+			return nil
+		},
+		check: func() error {
+			if _, err := app.GetRecord(transfer.Id()); err == nil {
+				return envelope.ErrDuplicateTx
+			}
+			if _, err := app.GetLoanPool(transfer.Pool); err != nil {
+				return envelope.ErrNotFound.Wrapf("pool: %v", err)
+			}
+			return nil
+		},
+		execute: func() (abcitypes.ResponseDeliverTx, error) {
+			err := app.TransferTranche(transfer)
+			if err != nil {
+				return abcitypes.ResponseDeliverTx{}, fmt.Errorf("TransferTranche Error: %w", err)
+			}
+			return abcitypes.ResponseDeliverTx{Code: 0, GasWanted: 1}, nil
+		},
+	}, nil
+}
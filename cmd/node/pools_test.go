@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"debtchain/internal/envelope"
+	"debtchain/pkg/utxi"
+)
+
+func TestTrancheTransferOnce(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	first, _ := borrower.PublicKey(1)
+	second, _ := borrower.PublicKey(2)
+	loans := [][]byte{loanOf(chain.issue(t, first, 100)), loanOf(chain.issue(t, second, 50))}
+	pool := chain.lender.ConstructLoanPool(loans, []utxi.Tranche{{Face: 100}, {Face: 50}})
+	if err := chain.deliver(t, "CreatePool", pool); err != nil {
+		t.Fatal(err)
+	}
+	poolId := pool.Hash()
+	buyer, _ := newTestWallet(t).PublicKey(1)
+
+	transfer := chain.lender.ConstructTrancheTransfer(1, poolId, 0, buyer, 40)
+	if err := chain.deliver(t, "TransferTranche", transfer); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "TransferTranche", transfer); !errors.Is(err, envelope.ErrDuplicateTx) {
+		t.Errorf("replayed transfer: %v", err)
+	}
+	// the same terms under a new nonce are a new transfer
+	if err := chain.deliver(t, "TransferTranche", chain.lender.ConstructTrancheTransfer(1, poolId, 0, buyer, 40)); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := chain.GetLoanPool(poolId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held := stored.Tranches[0].Holders[base64.URLEncoding.EncodeToString(buyer)]; held != 80 {
+		t.Errorf("buyer holds %v, want 80", held)
+	}
+}
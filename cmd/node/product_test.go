@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"debtchain/internal/wallet"
+	"debtchain/pkg/utxi"
+)
+
+// withCounselor registers counselor for counseling attestations in genesis.
+func withCounselor(counselor *wallet.Wallet) func(state *GenesisState) {
+	return func(state *GenesisState) {
+		key, _ := counselor.PublicKey(1)
+		state.Attesters[utxi.AttestCounseling] = [][]byte{key}
+	}
+}
+
+func reverseMortgage(lender *wallet.Wallet, borrower []byte, amount uint64) utxi.Transaction {
+	debtTx := lender.ConstructDebtTransaction(borrower, amount)
+	debtTx.Product = utxi.ProductReverseMortgage
+	// the lender signs every field but the signatures
+	lender.SignTransaction(1, &debtTx)
+	return debtTx
+}
+
+func TestCounselingRequired(t *testing.T) {
+	counselor := newTestWallet(t)
+	chain := newTestChain(t, withCounselor(counselor))
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	expires := time.Now().Add(time.Hour).Unix()
+
+	if err := chain.deliver(t, "IssueDebt", reverseMortgage(chain.lender, borrowerAddr, 100)); err == nil {
+		t.Fatal("reverse mortgage issued without counseling")
+	}
+	// the KYC provider is not a counselor
+	if err := chain.deliver(t, "Attestation", chain.provider.ConstructAttestation(utxi.AttestCounseling, borrowerAddr, expires)); err == nil {
+		t.Fatal("counseling attested by a KYC provider")
+	}
+	if err := chain.deliver(t, "Attestation", counselor.ConstructAttestation(utxi.AttestCounseling, borrowerAddr, expires)); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "IssueDebt", reverseMortgage(chain.lender, borrowerAddr, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	// counseling does not stand in for KYC
+	otherAddr, _ := newTestWallet(t).PublicKey(1)
+	if err := chain.deliver(t, "Attestation", counselor.ConstructAttestation(utxi.AttestCounseling, otherAddr, expires)); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "IssueDebt", reverseMortgage(chain.lender, otherAddr, 100)); err == nil {
+		t.Error("reverse mortgage issued without KYC")
+	}
+}
+
+func TestGenesisRequirements(t *testing.T) {
+	chain := newTestChain(t, func(state *GenesisState) {
+		state.Requirements = map[utxi.ProductType][]utxi.AttestationKind{utxi.ProductReverseMortgage: nil}
+	})
+	required, err := chain.GetRequirements(utxi.ProductReverseMortgage)
+	if err != nil || len(required) != 0 {
+		t.Fatalf("requirements %v, %v", required, err)
+	}
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	if err := chain.deliver(t, "IssueDebt", reverseMortgage(chain.lender, borrowerAddr, 100)); err != nil {
+		t.Errorf("reverse mortgage without requirements: %v", err)
+	}
+
+	if required, _ := newTestChain(t).GetRequirements(utxi.ProductReverseMortgage); len(required) != 1 || required[0] != utxi.AttestCounseling {
+		t.Errorf("default requirements %v", required)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	"github.com/dgraph-io/badger/v2"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+/*
+	Query paths. A route is a fixed path, or a prefix followed by one
+	parameter: /tx/<hash>. Hashes, ids, keys and addresses in paths are
+	base64 URL encoded; an outpoint is <txid>:<vout>. Paths are accepted
+	with or without the leading slash, and the older routes taking their
+	argument in Data are kept. Every response carries the height of the
+	committed state it was read from: the last one, or the past height
+	asked for if it is still retained.
+
+	Paths reading a single key can be proven: with Prove set the response
+	holds the key, its stored value and the proof of the value against the
+	app hash in the header of the next height.
+*/
+
+type queryRoute struct {
+	path	string
+	// whether the path is followed by a parameter
+	param	bool
+	handle	func(app *HELB, param string, data []byte) ([]byte, error)
+}
+
+var queryRoutes = []queryRoute{
+	{"debt/total", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		err, total := app.GetTotalDebt()
+		return marshalQuery(total, err)
+	}},
+	// the outstanding debt of a loan
+	{"debt", true, func(app *HELB, id string, _ []byte) ([]byte, error) {
+		loanId, err := base64.URLEncoding.DecodeString(id)
+		if err != nil {
+			return nil, envelope.ErrEncoding.Wrap(err)
+		}
+		debtTx, err := app.GetOutstandingDebt(loanId)
+		if err != nil {
+			return nil, err
+		}
+		return debtTx.Serialize(), nil
+	}},
+	{"credits/total", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		err, total := app.GetTotalCredits()
+		return marshalQuery(total, err)
+	}},
+	{"debt/by-asset", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		err, totals := app.GetTotalDebtByAsset()
+		return marshalQuery(totals, err)
+	}},
+	{"credits/by-asset", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		err, totals := app.GetTotalCreditsByAsset()
+		return marshalQuery(totals, err)
+	}},
+	// the stored transaction or record
+	{"tx", true, func(app *HELB, hash string, _ []byte) ([]byte, error) {
+		key, err := base64.URLEncoding.DecodeString(hash)
+		if err != nil {
+			return nil, envelope.ErrEncoding.Wrap(err)
+		}
+		return app.GetRecord(key)
+	}},
+	{"utxo/by-address", true, func(app *HELB, address string, _ []byte) ([]byte, error) {
+		addr, err := base64.URLEncoding.DecodeString(address)
+		if err != nil {
+			return nil, envelope.ErrEncoding.Wrap(err)
+		}
+		outputs, err := app.GetUTXOsByAddress(addr)
+		return marshalQuery(outputs, err)
+	}},
+	{"utxo", true, func(app *HELB, outpoint string, _ []byte) ([]byte, error) {
+		txid, vout, err := parseOutpoint(outpoint)
+		if err != nil {
+			return nil, envelope.ErrEncoding.Wrap(err)
+		}
+		output, err := app.GetUTXO(txid, vout)
+		return marshalQuery(output, err)
+	}},
+	{"loan", true, func(app *HELB, id string, _ []byte) ([]byte, error) {
+		loanId, err := base64.URLEncoding.DecodeString(id)
+		if err != nil {
+			return nil, envelope.ErrEncoding.Wrap(err)
+		}
+		loan, err := app.GetLoan(loanId)
+		return marshalQuery(loan, err)
+	}},
+	{"loans/by-lender", true, func(app *HELB, pk string, _ []byte) ([]byte, error) {
+		lender, err := base64.URLEncoding.DecodeString(pk)
+		if err != nil {
+			return nil, envelope.ErrEncoding.Wrap(err)
+		}
+		loans, err := app.loansWhere(func(loan utxi.Loan) bool {
+			return bytes.Equal(loan.Lender, lender)
+		})
+		return marshalQuery(loans, err)
+	}},
+	{"test", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		return []byte("test"), nil
+	}},
+	// counts of the confidential outputs left out of the totals
+	{"confidential/unopened", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		return marshalQuery(app.GetUnopened())
+	}},
+	{"prove/debt", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		return marshalQuery(app.ProveTotalDebt())
+	}},
+	{"prove/credits", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		return marshalQuery(app.ProveTotalCredits())
+	}},
+	{"liabilities/root", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		return marshalQuery(app.liabilitiesRoot, nil)
+	}},
+	// Data holds the borrower address
+	{"liabilities/proof", false, func(app *HELB, _ string, data []byte) ([]byte, error) {
+		return marshalQuery(app.ProveLiabilities(data))
+	}},
+	{"memos", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		return marshalQuery(app.GetMemoOutputs())
+	}},
+	{"stealth", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		return marshalQuery(app.GetStealthOutputs())
+	}},
+	// Data holds the transaction hash
+	{"tx", false, func(app *HELB, _ string, data []byte) ([]byte, error) {
+		tx, err := app.GetTransaction(data)
+		if err != nil {
+			return nil, err
+		}
+		return tx.Serialize(), nil
+	}},
+	// Data holds the lender key
+	{"issuer", false, func(app *HELB, _ string, data []byte) ([]byte, error) {
+		return marshalQuery(app.GetIssuer(data))
+	}},
+	// Data holds the collateral id
+	{"collateral", false, func(app *HELB, _ string, data []byte) ([]byte, error) {
+		return marshalQuery(app.GetCollateralValue(string(data)))
+	}},
+	{"assets", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		return marshalQuery(app.GetAssets())
+	}},
+	{"delinquent", false, func(app *HELB, _ string, _ []byte) ([]byte, error) {
+		return marshalQuery(app.GetDelinquentLoans())
+	}},
+}
+
+// provableRoutes name the store and key a parameterized path reads.
+var provableRoutes = map[string]func(param string) (string, []byte, error){
+	"tx": storeKey("transactions"),
+	"debt": storeKey("debt"),
+	"loan": storeKey("loans"),
+}
+
+func storeKey(store string) func(param string) (string, []byte, error) {
+	return func(param string) (string, []byte, error) {
+		key, err := base64.URLEncoding.DecodeString(param)
+		if err != nil {
+			return "", nil, envelope.ErrEncoding.Wrap(err)
+		}
+		return store, key, nil
+	}
+}
+
+// route finds the route of a path and its parameter.
+func route(path string) (queryRoute, string, bool) {
+	path = strings.TrimPrefix(path, "/")
+	for _, r := range queryRoutes {
+		if !r.param && path == r.path {
+			return r, "", true
+		}
+		if r.param && strings.HasPrefix(path, r.path + "/") {
+			param := strings.TrimPrefix(path, r.path + "/")
+			if param != "" && !strings.Contains(param, "/") {
+				return r, param, true
+			}
+		}
+	}
+	return queryRoute{}, "", false
+}
+
+func marshalQuery(v interface{}, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func parseOutpoint(outpoint string) ([]byte, int64, error) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 {
+		return nil, 0, errors.New("outpoint is <txid>:<vout>")
+	}
+	txid, err := base64.URLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	vout, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return txid, vout, nil
+}
+
+// queryError reports a failed query with its registered code; a missing key
+// is reported as not found.
+func queryError(err error) abcitypes.ResponseQuery {
+	if err == badger.ErrKeyNotFound {
+		err = envelope.ErrNotFound
+	}
+	var e *envelope.Error
+	if !errors.As(err, &e) {
+		err = envelope.ErrInternal.Wrap(err)
+	}
+	codespace, code, log := envelope.ABCIInfo(err)
+	return abcitypes.ResponseQuery{Code: code, Codespace: codespace, Log: log}
+}
+
+// GetRecord returns a stored transaction or record as stored.
+func (app *HELB) GetRecord(hash []byte) ([]byte, error) {
+	var record []byte
+	err := app.transactions.View(func(txn *Txn) error {
+		item, err := txn.Get(hash)
+		if err != nil {
+			return err
+		}
+		record, err = item.ValueCopy(nil)
+		return err
+	})
+	return record, err
+}
+
+// GetUTXO returns an output of a transaction if it is still in the utxo pool.
+func (app *HELB) GetUTXO(txid []byte, vout int64) (utxi.RecordedOutput, error) {
+	tx, err := app.GetTransaction(txid)
+	if err != nil {
+		return utxi.RecordedOutput{}, err
+	}
+	if vout < 0 || vout >= int64(len(tx.Outputs)) {
+		return utxi.RecordedOutput{}, envelope.ErrNotFound.Wrapf("transaction has %v outputs", len(tx.Outputs))
+	}
+	output := tx.Outputs[vout]
+	unspent, err := app.GetUTXOsByAddress(output.RecipientAddr())
+	if err != nil {
+		return utxi.RecordedOutput{}, err
+	}
+	for _, utxo := range unspent {
+		if bytes.Equal(utxo.Serialize(), output.Serialize()) {
+			return utxi.RecordedOutput{Txid: txid, Vout: vout, Output: output}, nil
+		}
+	}
+	return utxi.RecordedOutput{}, envelope.ErrNotFound.Wrapf("output %v:%v is spent", base64.URLEncoding.EncodeToString(txid), vout)
+}
+
+// GetUTXOsByAddress lists the unspent outputs paying to an address. The pool
+// is keyed by address, so there is at most one.
+func (app *HELB) GetUTXOsByAddress(address []byte) ([]utxi.TxOutput, error) {
+	outputs := []utxi.TxOutput{}
+	err := app.utxoPool.View(func(txn *Txn) error {
+		item, err := txn.Get(address)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			var output utxi.TxOutput
+			jsonErr := json.Unmarshal(v, &output)
+			if jsonErr != nil {
+				return jsonErr
+			}
+			outputs = append(outputs, output)
+			return nil
+		})
+	})
+	return outputs, err
+}
+
+func (app *HELB) Query(reqQuery abcitypes.RequestQuery) abcitypes.ResponseQuery {
+	// queries answer from committed state, not the block being executed
+	defer app.use(nil)()
+	if reqQuery.Height != 0 && reqQuery.Height != app.height {
+		restore, err := app.at(reqQuery.Height)
+		if err != nil {
+			res := queryError(err)
+			res.Height = app.height
+			return res
+		}
+		defer restore()
+	}
+
+	r, param, ok := route(reqQuery.Path)
+	if !ok {
+		res := queryError(envelope.ErrUnknownPath.Wrapf("%q", reqQuery.Path))
+		res.Height = app.height
+		return res
+	}
+	if reqQuery.Prove {
+		return app.proveQuery(r, param)
+	}
+	value, err := r.handle(app, param, reqQuery.Data)
+	if err != nil {
+		res := queryError(err)
+		res.Height = app.height
+		return res
+	}
+	return abcitypes.ResponseQuery{Value: value, Height: app.height}
+}
+
+func (app *HELB) proveQuery(r queryRoute, param string) abcitypes.ResponseQuery {
+	keyOf, ok := provableRoutes[r.path]
+	if !ok || !r.param {
+		res := queryError(envelope.ErrNoProof.Wrapf("%q", r.path))
+		res.Height = app.height
+		return res
+	}
+	store, key, err := keyOf(param)
+	if err != nil {
+		res := queryError(err)
+		res.Height = app.height
+		return res
+	}
+	value, proof, err := app.ProveKey(store, key)
+	if err != nil {
+		res := queryError(err)
+		res.Height = app.height
+		return res
+	}
+	return abcitypes.ResponseQuery{Key: key, Value: value, Proof: proof, Height: app.height}
+}
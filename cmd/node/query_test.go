@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"debtchain/internal/envelope"
+	"debtchain/pkg/smt"
+	"debtchain/pkg/utxi"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
+)
+
+func TestRoute(t *testing.T) {
+	paths := []struct {
+		path	string
+		route	string
+		param	string
+		ok		bool
+	}{
+		{"/debt/total", "debt/total", "", true},
+		{"debt/total", "debt/total", "", true},
+		{"/tx/abc", "tx", "abc", true},
+		{"/utxo/by-address/abc", "utxo/by-address", "abc", true},
+		{"/utxo/abc:0", "utxo", "abc:0", true},
+		{"tx", "tx", "", true},
+		{"/tx/", "", "", false},
+		{"/tx/abc/def", "", "", false},
+		{"/debt/total/abc", "", "", false},
+		{"/nope", "", "", false},
+	}
+	for _, p := range paths {
+		r, param, ok := route(p.path)
+		if ok != p.ok || r.path != p.route || param != p.param {
+			t.Errorf("%q routed to %q %q %v", p.path, r.path, param, ok)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	chain.beginBlock(time.Unix(1000, 0))
+	debtTx := chain.issue(t, borrowerAddr, 100)
+	chain.Commit()
+
+	query := func(path string, height int64) ([]byte, error) {
+		t.Helper()
+		res := chain.Query(abcitypes.RequestQuery{Path: path, Height: height})
+		if res.Height != 1 {
+			t.Errorf("%v read at height %v", path, res.Height)
+		}
+		return res.Value, envelope.Decode(res.Codespace, res.Code, res.Log)
+	}
+	b64 := base64.URLEncoding.EncodeToString
+
+	for _, path := range []string{"/debt/total", "/credits/total"} {
+		if total, err := query(path, 0); err != nil || string(total) != "100" {
+			t.Errorf("%v: %s, %v", path, total, err)
+		}
+	}
+	if _, err := query("/tx/" + b64(debtTx.Hash()), 0); err != nil {
+		t.Error(err)
+	}
+	if _, err := query("/utxo/" + b64(debtTx.Hash()) + ":0", 0); err != nil {
+		t.Error(err)
+	}
+	var outputs []utxi.TxOutput
+	value, err := query("/utxo/by-address/" + b64(borrowerAddr), 0)
+	if err != nil || json.Unmarshal(value, &outputs) != nil || len(outputs) != 1 {
+		t.Errorf("outputs of the borrower: %s, %v", value, err)
+	}
+
+	failing := []struct {
+		path	string
+		height	int64
+		err		error
+	}{
+		{"/nope", 0, envelope.ErrUnknownPath},
+		{"/tx/" + b64([]byte("missing")), 0, envelope.ErrNotFound},
+		{"/utxo/" + b64(debtTx.Hash()) + ":1", 0, envelope.ErrNotFound},
+		{"/tx/not base64", 0, envelope.ErrEncoding},
+		{"/debt/total", 2, envelope.ErrUnknownHeight},
+	}
+	for _, f := range failing {
+		if _, err := query(f.path, f.height); !errors.Is(err, f.err) {
+			t.Errorf("%v at height %v: %v, want %v", f.path, f.height, err, f.err)
+		}
+	}
+}
+
+// verifyProof checks a proven query response against an app hash, as the client does.
+func verifyProof(res abcitypes.ResponseQuery, appHash []byte) error {
+	if res.Proof == nil || len(res.Proof.Ops) != 2 {
+		return errors.New("response carries no proof")
+	}
+	keyPath := merkle.KeyPath{}.
+		AppendKey(res.Proof.Ops[1].Key, merkle.KeyEncodingURL).
+		AppendKey(res.Key, merkle.KeyEncodingHex)
+	return smt.ProofRuntime().VerifyValue(res.Proof, appHash, keyPath.String(), res.Value)
+}
+
+func TestProvenQuery(t *testing.T) {
+	chain := newTestChain(t)
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.beginBlock(time.Unix(1000, 0))
+	debtTx := chain.issue(t, borrowerAddr, 100)
+	chain.issue(t, borrowerAddr, 50)
+	appHash := chain.Commit().Data
+	b64 := base64.URLEncoding.EncodeToString
+
+	value, proof, err := chain.ProveKey("transactions", debtTx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, debtTx.Serialize()) {
+		t.Errorf("proven value %s, want the debt transaction", value)
+	}
+	res := abcitypes.ResponseQuery{Key: debtTx.Hash(), Value: value, Proof: proof}
+	if err := verifyProof(res, appHash); err != nil {
+		t.Errorf("ProveKey proof: %v", err)
+	}
+
+	res = chain.Query(abcitypes.RequestQuery{Path: "/tx/" + b64(debtTx.Hash()), Prove: true})
+	if res.Code != 0 || res.Height != 1 {
+		t.Fatalf("proven query failed at height %v: %v", res.Height, res.Log)
+	}
+	if err := verifyProof(res, appHash); err != nil {
+		t.Errorf("query proof: %v", err)
+	}
+	// a proof does not verify another value or another app hash
+	forged := res
+	forgedTx := chain.lender.ConstructDebtTransaction(borrowerAddr, 1000)
+	forged.Value = forgedTx.Serialize()
+	if verifyProof(forged, appHash) == nil {
+		t.Error("proof verified a forged value")
+	}
+	if verifyProof(res, make([]byte, len(appHash))) == nil {
+		t.Error("proof verified against another app hash")
+	}
+
+	failing := []struct {
+		path	string
+		err		error
+	}{
+		{"/tx/" + b64([]byte("missing")), envelope.ErrNotFound},
+		{"/debt/total", envelope.ErrNoProof},
+		// the stored output is not the list the query answers with
+		{"/utxo/by-address/" + b64(borrowerAddr), envelope.ErrNoProof},
+	}
+	for _, f := range failing {
+		res := chain.Query(abcitypes.RequestQuery{Path: f.path, Prove: true})
+		if err := envelope.Decode(res.Codespace, res.Code, res.Log); !errors.Is(err, f.err) {
+			t.Errorf("proving %v: %v, want %v", f.path, err, f.err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+/*
+	Refinancing closes loans and originates their replacement in one
+	transaction. The closed loans point to the refinance record and the new
+	loan lists the loans it replaces, so the history can be walked both ways.
+*/
+
+// RefinanceLoans returns the loan that replaces the refinanced ones, and the
+// events closing the refinanced loans and issuing the replacement.
+func (app *HELB) RefinanceLoans(rf utxi.Refinance) (utxi.Loan, []abcitypes.Event, error) {
+	if len(rf.Loans) == 0 {
+		return utxi.Loan{}, nil, envelope.ErrInvalidTx.Wrapf("nothing to refinance")
+	}
+
+	var lender, borrower []byte
+	var asset utxi.AssetId
+	var collateral string
+	var product utxi.ProductType
+	// the output of the first refinanced debt, which pays the borrower
+	var borrowerOutput utxi.TxOutput
+	var outstanding uint64
+	seen := make(map[string]bool)
+	refinanced := make([]utxi.Loan, 0, len(rf.Loans))
+	balances := make([]uint64, 0, len(rf.Loans))
+	for _, id := range rf.Loans {
+		idStr := base64.URLEncoding.EncodeToString(id)
+		if seen[idStr] {
+			return utxi.Loan{}, nil, envelope.ErrInvalidTx.Wrapf("loan %v listed twice", idStr)
+		}
+		seen[idStr] = true
+
+		loan, err := app.GetLoan(id)
+		if err != nil {
+			return utxi.Loan{}, nil, envelope.ErrUnknownLoan.Wrapf("loan %v: %v", idStr, err)
+		}
+		if loan.IsClosed() {
+			return utxi.Loan{}, nil, envelope.ErrLoanClosed.Wrapf("loan %v", idStr)
+		}
+		if loan.IsPooled() {
+			return utxi.Loan{}, nil, envelope.ErrUnauthorized.Wrapf("loan %v belongs to the tranche holders", idStr)
+		}
+		first := lender == nil
+		if first {
+			lender, borrower, asset = loan.Lender, loan.Borrower, loan.Asset
+			collateral, product = loan.Collateral, loan.Product
+		} else if !bytes.Equal(lender, loan.Lender) || !bytes.Equal(borrower, loan.Borrower) {
+			return utxi.Loan{}, nil, envelope.ErrInvalidTx.Wrapf("refinanced loans must share lender and borrower")
+		} else if asset != loan.Asset {
+			return utxi.Loan{}, nil, envelope.ErrInvalidTx.Wrapf("refinanced loans must share an asset")
+		} else if collateral != loan.Collateral || product != loan.Product {
+			return utxi.Loan{}, nil, envelope.ErrInvalidTx.Wrapf("refinanced loans must share collateral and product")
+		}
+		debtTx, err := app.GetOutstandingDebt(id)
+		if err != nil {
+			return utxi.Loan{}, nil, envelope.ErrUnknownLoan.Wrapf("loan %v: %v", idStr, err)
+		}
+		if debtTx.IsConfidential() {
+			return utxi.Loan{}, nil, envelope.ErrInvalidTx.Wrapf("loan %v has a confidential amount", idStr)
+		}
+		if first {
+			borrowerOutput = debtTx.Outputs[0]
+		}
+		if outstanding + debtTx.DebtIssued() < outstanding {
+			return utxi.Loan{}, nil, envelope.ErrInvalidTx.Wrapf("outstanding debt overflows")
+		}
+		outstanding = outstanding + debtTx.DebtIssued()
+		refinanced = append(refinanced, loan)
+		balances = append(balances, debtTx.DebtIssued())
+	}
+	// the replacement loan is new debt, so the lender must still be registered,
+	// the borrower attested for the product and the collateral worth the
+	// new principal
+	if err := app.checkIssuer(lender); err != nil {
+		return utxi.Loan{}, nil, err
+	}
+	if err := app.checkBorrower(product, borrowerOutput); err != nil {
+		return utxi.Loan{}, nil, err
+	}
+	if !rf.IsSignedBy(lender) || !rf.IsSignedBy(borrower) {
+		return utxi.Loan{}, nil, envelope.ErrSignature.Wrapf("refinance must be signed by lender and borrower")
+	}
+	if rf.Principal < outstanding {
+		return utxi.Loan{}, nil, envelope.ErrInsufficientFunds.Wrapf("principal does not cover the outstanding debt")
+	}
+	if err := app.checkRefinancedCollateral(collateral, rf.Principal, outstanding); err != nil {
+		return utxi.Loan{}, nil, envelope.ErrCollateral.Wrap(err)
+	}
+
+	closingHash := rf.Hash()
+	err := app.AddRecord(closingHash, rf.Serialize())
+	if err != nil {
+		return utxi.Loan{}, nil, err
+	}
+	var events []abcitypes.Event
+	for i, loan := range refinanced {
+		err = app.RemoveFromDebtPool(loan.Id)
+		if err != nil {
+			return utxi.Loan{}, nil, err
+		}
+		loan.Closed = closingHash
+		err = app.AddLoan(loan)
+		if err != nil {
+			return utxi.Loan{}, nil, err
+		}
+		events = append(events, amountEvent("loan.closed", loan, balances[i], false))
+	}
+
+	terms := rf.Terms
+	loan := utxi.Loan{
+		Id: utxi.DerivedLoanId(closingHash, 0),
+		Lender: lender,
+		Borrower: borrower,
+		Principal: rf.Principal,
+		Asset: asset,
+		Terms: &terms,
+		Collateral: collateral,
+		Product: product,
+		Replaces: rf.Loans,
+	}
+	// a stealth borrower keeps the ephemeral key and proof its attestations
+	// are checked against
+	output := utxi.ConstructAssetOutput(borrower, asset, rf.Principal)
+	output.Ephemeral, output.StealthProof = borrowerOutput.Ephemeral, borrowerOutput.StealthProof
+	odtx := utxi.Transaction{
+		Outputs: []utxi.TxOutput{output},
+	}
+	err = app.SetOutstandingDebt(loan.Id, odtx)
+	if err != nil {
+		return utxi.Loan{}, nil, err
+	}
+	err = app.AddLoan(loan)
+	if err != nil {
+		return utxi.Loan{}, nil, err
+	}
+
+	// cash out: the part of the new principal not used to close the old loans
+	if rf.Principal > outstanding {
+		disbursement := utxi.Transaction{
+			Inputs: []utxi.TxInput{{Txid: lender, Vout: -2}},
+			Outputs: []utxi.TxOutput{utxi.ConstructAssetOutput(borrower, asset, rf.Principal - outstanding)},
+		}
+		err = app.AddTransaction(disbursement)
+		if err != nil {
+			return utxi.Loan{}, nil, err
+		}
+		err = app.AddToUXTOPool(disbursement)
+		if err != nil {
+			return utxi.Loan{}, nil, err
+		}
+	}
+	events = append(events, amountEvent("debt.issued", loan, loan.Principal, false))
+	return loan, events, nil
+}
+
+// checkRefinancedCollateral keeps the collateral of refinanced loans within
+// the maximum loan-to-value once principal replaces their outstanding debt.
+func (app *HELB) checkRefinancedCollateral(collateral string, principal, outstanding uint64) error {
+	if collateral == "" {
+		return nil
+	}
+	value, err := app.GetCollateralValue(collateral)
+	if err != nil {
+		return err
+	}
+	params, err := app.getOracleParams()
+	if err != nil || params.MaxLtvBps == 0 {
+		return err
+	}
+	return app.checkLoanToValue(value, params, principal, outstanding)
+}
+
+func decodeRefinance(encoded string) (utxi.Refinance, error) {
+	var rf utxi.Refinance
+	refinanceBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return rf, err
+	}
+	err = json.Unmarshal(refinanceBytes, &rf)
+	return rf, err
+}
+
+func refinanceCommand(app *HELB, encoded string) (command, error) {
+	rf, err := decodeRefinance(encoded)
+	if err != nil {
+		return command{}, err
+	}
+	return command{
+		validate: func() error {
+			if !rf.IsRefinanceValid() {
+				return envelope.ErrInvalidTx.Wrapf("refinance")
+			}
+			return nil
+		},
+		execute: func() (abcitypes.ResponseDeliverTx, error) {
+			loan, events, err := app.RefinanceLoans(rf)
+			if err != nil {
+				return abcitypes.ResponseDeliverTx{}, fmt.Errorf("Refinance Error: %w", err)
+			}
+			debtQueryErr, systemDebt := app.GetPlaintextDebt()
+			if debtQueryErr != nil {
+				return abcitypes.ResponseDeliverTx{
+					Code: 0,
+					GasWanted: 1,
+					Info: fmt.Sprintf("error: %v", debtQueryErr),
+					Events: events,
+				}, nil
+			}
+			return abcitypes.ResponseDeliverTx{
+				Code: 0,
+				GasWanted: 1,
+				Info: fmt.Sprintf("Loan: %v, Total System Debt: %v", base64.URLEncoding.EncodeToString(loan.Id), systemDebt),
+				Events: events,
+			}, nil
+		},
+	}, nil
+}
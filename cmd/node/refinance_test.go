@@ -0,0 +1,171 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"debtchain/internal/wallet"
+	"debtchain/pkg/utxi"
+)
+
+func refinance(loans [][]byte, principal uint64, signers ...*wallet.Wallet) utxi.Refinance {
+	rf := utxi.Refinance{Loans: loans, Principal: principal, Terms: utxi.LoanTerms{RateBps: 400, TermMonths: 120}}
+	for _, signer := range signers {
+		signer.SignRefinance(&rf)
+	}
+	return rf
+}
+
+func TestRefinance(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	first := loanOf(chain.issue(t, borrowerAddr, 100))
+	second := loanOf(chain.issue(t, borrowerAddr, 50))
+
+	rf := refinance([][]byte{first, second}, 200, chain.lender, borrower)
+	if err := chain.deliver(t, "Refinance", rf); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range [][]byte{first, second} {
+		loan, err := chain.GetLoan(id)
+		if err != nil || string(loan.Closed) != string(rf.Hash()) {
+			t.Errorf("refinanced loan %+v, %v", loan, err)
+		}
+		if _, err := chain.GetOutstandingDebt(id); err == nil {
+			t.Error("refinanced loan left in the debt pool")
+		}
+	}
+	replacement, err := chain.GetLoan(utxi.DerivedLoanId(rf.Hash(), 0))
+	if err != nil || replacement.Principal != 200 || len(replacement.Replaces) != 2 || replacement.Terms == nil || replacement.Terms.RateBps != 400 {
+		t.Fatalf("replacement %+v, %v", replacement, err)
+	}
+	if err, debt := chain.GetTotalDebt(); err != nil || debt != 200 {
+		t.Errorf("total debt %v, %v; want 200", debt, err)
+	}
+
+	if err := chain.deliver(t, "Refinance", refinance([][]byte{first}, 100, chain.lender, borrower)); err == nil {
+		t.Error("closed loan refinanced")
+	}
+}
+
+func TestRefinanceRejected(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	otherAddr, _ := newTestWallet(t).PublicKey(1)
+	loan := loanOf(chain.issue(t, borrowerAddr, 100))
+	other := loanOf(chain.issue(t, otherAddr, 100))
+
+	unordered := utxi.Refinance{Loans: [][]byte{loan}, Principal: 100, Terms: utxi.LoanTerms{
+		RateBps: 400,
+		TermMonths: 2,
+		Schedule: []utxi.Installment{{DueTime: 2000, Amount: 50}, {DueTime: 1000, Amount: 50}},
+	}}
+	chain.lender.SignRefinance(&unordered)
+	borrower.SignRefinance(&unordered)
+
+	rejected := []struct {
+		name	string
+		rf		utxi.Refinance
+	}{
+		{"lender only", refinance([][]byte{loan}, 100, chain.lender)},
+		{"borrower only", refinance([][]byte{loan}, 100, borrower)},
+		{"principal short of the debt", refinance([][]byte{loan}, 99, chain.lender, borrower)},
+		{"loan listed twice", refinance([][]byte{loan, loan}, 200, chain.lender, borrower)},
+		{"another borrower", refinance([][]byte{loan, other}, 200, chain.lender, borrower)},
+		{"no loans", refinance(nil, 100, chain.lender, borrower)},
+		{"schedule out of order", unordered},
+	}
+	for _, tc := range rejected {
+		if err := chain.deliver(t, "Refinance", tc.rf); err == nil {
+			t.Errorf("%v: refinance accepted", tc.name)
+		}
+	}
+	if _, err := chain.GetOutstandingDebt(loan); err != nil {
+		t.Error("rejected refinance closed the loan")
+	}
+}
+
+func TestRefinanceCollateral(t *testing.T) {
+	chain, oracles := newOracleChain(t)
+	now := time.Now().Unix()
+	chain.beginBlock(time.Unix(now, 0))
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	for _, oracle := range oracles[:2] {
+		if err := chain.deliver(t, "Appraisal", oracle.ConstructAppraisal("house", 1000, now)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secured := securedDebt(chain.lender, borrowerAddr, 500, "house")
+	if err := chain.deliver(t, "IssueDebt", secured); err != nil {
+		t.Fatal(err)
+	}
+	otherAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, otherAddr)
+	if err := chain.deliver(t, "IssueDebt", securedDebt(chain.lender, otherAddr, 200, "house")); err != nil {
+		t.Fatal(err)
+	}
+	unsecured := loanOf(chain.issue(t, borrowerAddr, 50))
+
+	// the other loan's 200 counts towards the 80% limit
+	if err := chain.deliver(t, "Refinance", refinance([][]byte{loanOf(secured)}, 601, chain.lender, borrower)); err == nil {
+		t.Error("cash out past the maximum loan-to-value refinanced")
+	}
+	if err := chain.deliver(t, "Refinance", refinance([][]byte{loanOf(secured), unsecured}, 550, chain.lender, borrower)); err == nil {
+		t.Error("secured and unsecured loans refinanced together")
+	}
+	rf := refinance([][]byte{loanOf(secured)}, 600, chain.lender, borrower)
+	if err := chain.deliver(t, "Refinance", rf); err != nil {
+		t.Fatal(err)
+	}
+	replacement, err := chain.GetLoan(utxi.DerivedLoanId(rf.Hash(), 0))
+	if err != nil || replacement.Collateral != "house" {
+		t.Fatalf("replacement %+v, %v", replacement, err)
+	}
+	if debt, err := chain.collateralDebt("house"); err != nil || debt != 800 {
+		t.Errorf("debt against the collateral %v, %v; want 800", debt, err)
+	}
+}
+
+func TestRefinanceProduct(t *testing.T) {
+	counselor := newTestWallet(t)
+	chain := newTestChain(t, withCounselor(counselor))
+	now := time.Now()
+	chain.beginBlock(now)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	counseling := counselor.ConstructAttestation(utxi.AttestCounseling, borrowerAddr, now.Add(time.Hour).Unix())
+	if err := chain.deliver(t, "Attestation", counseling); err != nil {
+		t.Fatal(err)
+	}
+	debtTx := reverseMortgage(chain.lender, borrowerAddr, 100)
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+
+	// counseling lapses before KYC does
+	chain.Commit()
+	chain.beginBlock(now.Add(90 * time.Minute))
+	extended := chain.provider.ConstructAttestation(utxi.AttestKYC, borrowerAddr, now.Add(3 * time.Hour).Unix())
+	if err := chain.deliver(t, "Attestation", extended); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "Refinance", refinance([][]byte{loanOf(debtTx)}, 100, chain.lender, borrower)); err == nil {
+		t.Error("reverse mortgage refinanced without current counseling")
+	}
+	renewed := counselor.ConstructAttestation(utxi.AttestCounseling, borrowerAddr, now.Add(3 * time.Hour).Unix())
+	if err := chain.deliver(t, "Attestation", renewed); err != nil {
+		t.Fatal(err)
+	}
+	rf := refinance([][]byte{loanOf(debtTx)}, 100, chain.lender, borrower)
+	if err := chain.deliver(t, "Refinance", rf); err != nil {
+		t.Fatal(err)
+	}
+	if replacement, err := chain.GetLoan(utxi.DerivedLoanId(rf.Hash(), 0)); err != nil || replacement.Product != utxi.ProductReverseMortgage {
+		t.Errorf("replacement %+v, %v", replacement, err)
+	}
+}
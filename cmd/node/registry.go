@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	"github.com/dgraph-io/badger/v2"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+/*
+	The lender registry. Genesis app_state lists the lenders allowed to
+	originate debt, the attesters for each kind of attestation, the
+	attestations required per product type and the governor keys whose
+	signatures are needed to change the lists afterwards:
+
+		{
+			"Lenders": ["<pubkey>", ...],
+			"Attesters": {"kyc": ["<pubkey>", ...], "counseling": ["<pubkey>", ...]},
+			"Requirements": {"reverse-mortgage": ["counseling"]},
+			"Oracles": ["<pubkey>", ...],
+			"Oracle": {"Quorum": 3, "MaxAge": 7776000, "MaxLtvBps": 8000, "LiquidationLtvBps": 9500},
+			"Assets": [{"Id": "usd", "Name": "US Dollar", "Decimals": 2, "Issuer": "<pubkey>"}, ...],
+			"Governors": ["<pubkey>", ...],
+			"Threshold": 2
+		}
+
+	Keys are base64 encoded compressed secp256k1 public keys. Without a
+	threshold a majority of the governors must sign. Governors are not tied
+	to the validator set; AddGovernor and RemoveGovernor transactions rotate
+	them. A chain without governors, e.g. from an empty app_state, has no
+	governance: the registry stays as the genesis state left it.
+*/
+type GenesisState struct {
+	Lenders			[][]byte
+	Attesters		map[utxi.AttestationKind][][]byte
+	// overrides utxi.DefaultRequirements per product
+	Requirements	map[utxi.ProductType][]utxi.AttestationKind
+	Oracles			[][]byte
+	Oracle			OracleParams
+	Assets			[]utxi.Asset
+	Governors		[][]byte
+	Threshold		int
+}
+
+// how collateral values are aggregated and used; zero values disable a limit
+type OracleParams struct {
+	// appraisals needed to value collateral, at least one
+	Quorum				int
+	// seconds after which an appraisal no longer counts
+	MaxAge				int64
+	// highest loan-to-value at issuance, in basis points
+	MaxLtvBps			uint64
+	// loan-to-value at which a loan is reported for liquidation
+	LiquidationLtvBps	uint64
+}
+
+type governorSet struct {
+	Governors		[][]byte
+	Threshold		int
+}
+
+const governorsKey = "governors"
+const oracleParamsKey = "oracleparams"
+
+func issuerKey(publicKey []byte) []byte {
+	return append([]byte("lender/"), publicKey...)
+}
+
+func oracleKey(publicKey []byte) []byte {
+	return append([]byte("oracles/"), publicKey...)
+}
+
+func assetKey(asset utxi.AssetId) []byte {
+	return []byte("assets/" + string(asset))
+}
+
+func requirementsKey(product utxi.ProductType) []byte {
+	return []byte("requirements/" + string(product))
+}
+
+func attesterKey(kind utxi.AttestationKind, publicKey []byte) []byte {
+	return append([]byte("attester/" + string(kind) + "/"), publicKey...)
+}
+
+func (app *HELB) InitRegistry(appState []byte) error {
+	var state GenesisState
+	if len(appState) > 0 {
+		err := json.Unmarshal(appState, &state)
+		if err != nil {
+			return err
+		}
+	}
+	if state.Threshold == 0 && len(state.Governors) > 0 {
+		state.Threshold = len(state.Governors) / 2 + 1
+	}
+	if state.Threshold > len(state.Governors) {
+		return errors.New("threshold exceeds the number of governors")
+	}
+	if state.Threshold < 1 && len(state.Governors) > 0 {
+		return errors.New("threshold must be at least one")
+	}
+	return app.registry.Update(func(txn *Txn) error {
+		for _, lender := range state.Lenders {
+			issuer := utxi.Issuer{PublicKey: lender, Status: utxi.IssuerActive}
+			issuerAsJson, _ := json.Marshal(issuer)
+			err := txn.Set(issuerKey(lender), issuerAsJson)
+			if err != nil {
+				return err
+			}
+		}
+		for kind, attesters := range state.Attesters {
+			for _, attester := range attesters {
+				err := txn.Set(attesterKey(kind, attester), []byte{1})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		requirements := make(map[utxi.ProductType][]utxi.AttestationKind)
+		for product, kinds := range utxi.DefaultRequirements {
+			requirements[product] = kinds
+		}
+		for product, kinds := range state.Requirements {
+			requirements[product] = kinds
+		}
+		for product, kinds := range requirements {
+			kindsAsJson, _ := json.Marshal(kinds)
+			err := txn.Set(requirementsKey(product), kindsAsJson)
+			if err != nil {
+				return err
+			}
+		}
+		for _, oracle := range state.Oracles {
+			err := txn.Set(oracleKey(oracle), []byte{1})
+			if err != nil {
+				return err
+			}
+		}
+		for _, asset := range state.Assets {
+			if asset.Id == utxi.NativeAsset {
+				return errors.New("the native asset is not registered")
+			}
+			assetAsJson, _ := json.Marshal(asset)
+			err := txn.Set(assetKey(asset.Id), assetAsJson)
+			if err != nil {
+				return err
+			}
+		}
+		if state.Oracle.Quorum < 1 {
+			state.Oracle.Quorum = 1
+		}
+		paramsAsJson, _ := json.Marshal(state.Oracle)
+		err := txn.Set([]byte(oracleParamsKey), paramsAsJson)
+		if err != nil {
+			return err
+		}
+		governorsAsJson, _ := json.Marshal(governorSet{state.Governors, state.Threshold})
+		return txn.Set([]byte(governorsKey), governorsAsJson)
+	})
+}
+
+func (app *HELB) GetIssuer(publicKey []byte) (utxi.Issuer, error) {
+	var issuer utxi.Issuer
+	err := app.registry.View(func(txn *Txn) error {
+		item, err := txn.Get(issuerKey(publicKey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &issuer)
+		})
+	})
+	return issuer, err
+}
+
+// GetRequirements returns the attestations required for a product; products
+// without requirements only need KYC.
+func (app *HELB) GetRequirements(product utxi.ProductType) ([]utxi.AttestationKind, error) {
+	var kinds []utxi.AttestationKind
+	err := app.registry.View(func(txn *Txn) error {
+		item, err := txn.Get(requirementsKey(product))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &kinds)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	return kinds, err
+}
+
+func (app *HELB) getGovernors() (governorSet, error) {
+	var governors governorSet
+	err := app.registry.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte(governorsKey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &governors)
+		})
+	})
+	return governors, err
+}
+
+// checkIssuer rejects lenders that are not registered or are suspended.
+func (app *HELB) checkIssuer(lender []byte) error {
+	issuer, err := app.GetIssuer(lender)
+	if err == badger.ErrKeyNotFound {
+		return envelope.ErrUnauthorizedIssuer.Wrapf("lender is not registered")
+	}
+	if err != nil {
+		return err
+	}
+	if !issuer.IsActive() {
+		return envelope.ErrUnauthorizedIssuer.Wrapf("lender is suspended")
+	}
+	return nil
+}
+
+// checkOriginator rejects debt not signed by an active registered lender.
+func (app *HELB) checkOriginator(debtTx utxi.Transaction) error {
+	if !debtTx.IsSignedByOriginator() {
+		return envelope.ErrSignature.Wrapf("debt is not signed by its lender")
+	}
+	return app.checkIssuer(debtTx.Originator())
+}
+
+// ApplyGovernance changes the registry once enough governors have signed.
+func (app *HELB) ApplyGovernance(gov utxi.Governance) error {
+	governors, err := app.getGovernors()
+	if err != nil {
+		return err
+	}
+	signers := gov.SignedBy()
+	signed := 0
+	for _, governor := range governors.Governors {
+		if signers[base64.URLEncoding.EncodeToString(governor)] {
+			signed = signed + 1
+		}
+	}
+	if len(governors.Governors) == 0 || governors.Threshold < 1 || signed < governors.Threshold {
+		return envelope.ErrUnauthorized.Wrapf("%v of %v required governor signatures", signed, governors.Threshold)
+	}
+
+	// a governance transaction is applied once, however many times it is re-signed
+	recordHash := gov.Id()
+	_, err = app.GetRecord(recordHash)
+	if err == nil {
+		return envelope.ErrDuplicateTx.Wrapf("governance transaction already applied")
+	}
+
+	switch gov.Action {
+	case utxi.AddAttester, utxi.RemoveAttester:
+		err = app.updateAttester(gov)
+	case utxi.AddOracle, utxi.RemoveOracle:
+		err = app.updateOracle(gov)
+	case utxi.AddAsset:
+		err = app.addAsset(gov)
+	case utxi.AddGovernor, utxi.RemoveGovernor:
+		err = app.updateGovernors(gov, governors)
+	default:
+		err = app.updateIssuer(gov)
+	}
+	if err != nil {
+		return err
+	}
+	return app.AddRecord(recordHash, gov.Serialize())
+}
+
+func (app *HELB) updateIssuer(gov utxi.Governance) error {
+	_, issuerErr := app.GetIssuer(gov.Key)
+	if issuerErr != nil && issuerErr != badger.ErrKeyNotFound {
+		return issuerErr
+	}
+	registered := issuerErr == nil
+	return app.registry.Update(func(txn *Txn) error {
+		switch gov.Action {
+		case utxi.AddIssuer, utxi.SuspendIssuer:
+			if gov.Action == utxi.SuspendIssuer && !registered {
+				return envelope.ErrUnauthorizedIssuer.Wrapf("lender is not registered")
+			}
+			issuer := utxi.Issuer{PublicKey: gov.Key, Status: utxi.IssuerActive}
+			if gov.Action == utxi.SuspendIssuer {
+				issuer.Status = utxi.IssuerSuspended
+			}
+			issuerAsJson, _ := json.Marshal(issuer)
+			return txn.Set(issuerKey(gov.Key), issuerAsJson)
+		case utxi.RemoveIssuer:
+			if !registered {
+				return envelope.ErrUnauthorizedIssuer.Wrapf("lender is not registered")
+			}
+			return txn.Delete(issuerKey(gov.Key))
+		}
+		return errors.New("unknown governance action")
+	})
+}
+
+// updateGovernors adds or removes a governor. The threshold is kept unless
+// the transaction sets one, and must stay within the new set.
+func (app *HELB) updateGovernors(gov utxi.Governance, governors governorSet) error {
+	var rotated [][]byte
+	found := false
+	for _, governor := range governors.Governors {
+		if bytes.Equal(governor, gov.Key) {
+			found = true
+			if gov.Action == utxi.RemoveGovernor {
+				continue
+			}
+		}
+		rotated = append(rotated, governor)
+	}
+	if gov.Action == utxi.AddGovernor {
+		if found {
+			return envelope.ErrUnauthorized.Wrapf("governor is already registered")
+		}
+		rotated = append(rotated, gov.Key)
+	} else if !found {
+		return envelope.ErrUnauthorized.Wrapf("governor is not registered")
+	}
+	threshold := governors.Threshold
+	if gov.Threshold > 0 {
+		threshold = gov.Threshold
+	}
+	if threshold < 1 || threshold > len(rotated) {
+		return envelope.ErrInvalidTx.Wrapf("threshold %v with %v governors", threshold, len(rotated))
+	}
+	governorsAsJson, _ := json.Marshal(governorSet{rotated, threshold})
+	return app.registry.Update(func(txn *Txn) error {
+		return txn.Set([]byte(governorsKey), governorsAsJson)
+	})
+}
+
+func (app *HELB) updateAttester(gov utxi.Governance) error {
+	if gov.Action == utxi.RemoveAttester {
+		registered, err := app.IsAttester(gov.Kind, gov.Key)
+		if err != nil {
+			return err
+		}
+		if !registered {
+			return envelope.ErrUnauthorized.Wrapf("attester is not registered")
+		}
+	}
+	return app.registry.Update(func(txn *Txn) error {
+		if gov.Action == utxi.RemoveAttester {
+			return txn.Delete(attesterKey(gov.Kind, gov.Key))
+		}
+		return txn.Set(attesterKey(gov.Kind, gov.Key), []byte{1})
+	})
+}
+
+func (app *HELB) IsAttester(kind utxi.AttestationKind, publicKey []byte) (bool, error) {
+	err := app.registry.View(func(txn *Txn) error {
+		_, err := txn.Get(attesterKey(kind, publicKey))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (app *HELB) updateOracle(gov utxi.Governance) error {
+	if gov.Action == utxi.RemoveOracle {
+		registered, err := app.IsOracle(gov.Key)
+		if err != nil {
+			return err
+		}
+		if !registered {
+			return envelope.ErrUnauthorized.Wrapf("oracle is not registered")
+		}
+	}
+	return app.registry.Update(func(txn *Txn) error {
+		if gov.Action == utxi.RemoveOracle {
+			return txn.Delete(oracleKey(gov.Key))
+		}
+		return txn.Set(oracleKey(gov.Key), []byte{1})
+	})
+}
+
+func (app *HELB) IsOracle(publicKey []byte) (bool, error) {
+	err := app.registry.View(func(txn *Txn) error {
+		_, err := txn.Get(oracleKey(publicKey))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (app *HELB) addAsset(gov utxi.Governance) error {
+	_, err := app.GetAsset(gov.Asset.Id)
+	if err == nil {
+		return envelope.ErrDuplicateTx.Wrapf("asset is already registered")
+	}
+	if err != badger.ErrKeyNotFound {
+		return err
+	}
+	asset := *gov.Asset
+	asset.Issuer = gov.Key
+	assetAsJson, _ := json.Marshal(asset)
+	return app.registry.Update(func(txn *Txn) error {
+		return txn.Set(assetKey(asset.Id), assetAsJson)
+	})
+}
+
+func (app *HELB) GetAsset(id utxi.AssetId) (utxi.Asset, error) {
+	var asset utxi.Asset
+	err := app.registry.View(func(txn *Txn) error {
+		item, err := txn.Get(assetKey(id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &asset)
+		})
+	})
+	return asset, err
+}
+
+func (app *HELB) GetAssets() ([]utxi.Asset, error) {
+	assets := []utxi.Asset{}
+	err := app.registry.View(func(txn *Txn) error {
+		prefix := []byte("assets/")
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var asset utxi.Asset
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, &asset)
+			})
+			if err != nil {
+				return err
+			}
+			assets = append(assets, asset)
+		}
+		return nil
+	})
+	return assets, err
+}
+
+// checkAssets rejects outputs in assets that are not registered.
+func (app *HELB) checkAssets(tx utxi.Transaction) error {
+	for _, output := range tx.Outputs {
+		if output.Asset == utxi.NativeAsset {
+			continue
+		}
+		_, err := app.GetAsset(output.Asset)
+		if err == badger.ErrKeyNotFound {
+			return envelope.ErrUnknownAsset.Wrapf("%v", output.Asset)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *HELB) getOracleParams() (OracleParams, error) {
+	var params OracleParams
+	err := app.registry.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte(oracleParamsKey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &params)
+		})
+	})
+	return params, err
+}
+
+func decodeGovernance(encoded string) (utxi.Governance, error) {
+	var gov utxi.Governance
+	governanceBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return gov, err
+	}
+	err = json.Unmarshal(governanceBytes, &gov)
+	return gov, err
+}
+
+func governanceCommand(app *HELB, encoded string) (command, error) {
+	gov, err := decodeGovernance(encoded)
+	if err != nil {
+		return command{}, err
+	}
+	return command{
+		validate: func() error {
+			if !gov.IsGovernanceValid() {
+				return envelope.ErrInvalidTx.Wrapf("governance transaction")
+			}
+			return nil
+		},
+		execute: func() (abcitypes.ResponseDeliverTx, error) {
+			err := app.ApplyGovernance(gov)
+			if err != nil {
+				return abcitypes.ResponseDeliverTx{}, fmt.Errorf("ApplyGovernance Error: %w", err)
+			}
+			return abcitypes.ResponseDeliverTx{
+				Code: 0,
+				GasWanted: 1,
+				Info: fmt.Sprintf("%v %v", gov.Action, base64.URLEncoding.EncodeToString(gov.Key)),
+			}, nil
+		},
+	}, nil
+}
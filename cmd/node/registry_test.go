@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"debtchain/internal/wallet"
+	"debtchain/pkg/utxi"
+)
+
+func governance(action utxi.GovernanceAction, key []byte, nonce uint64, signers ...*wallet.Wallet) utxi.Governance {
+	gov := utxi.Governance{Action: action, Key: key, Nonce: nonce}
+	for _, signer := range signers {
+		signer.SignGovernance(&gov)
+	}
+	return gov
+}
+
+func TestUnregisteredLender(t *testing.T) {
+	chain := newTestChain(t)
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	if err := chain.deliver(t, "IssueDebt", newTestWallet(t).ConstructDebtTransaction(borrowerAddr, 100)); err == nil {
+		t.Error("debt issued by an unregistered lender")
+	}
+}
+
+func TestGovernanceThreshold(t *testing.T) {
+	chain := newTestChain(t)
+	newLender := newTestWallet(t)
+	key, _ := newLender.PublicKey(1)
+	g := chain.governors
+
+	rejected := []struct {
+		name	string
+		gov		utxi.Governance
+	}{
+		{"one governor", governance(utxi.AddIssuer, key, 1, g[0])},
+		{"one governor signing twice", governance(utxi.AddIssuer, key, 1, g[0], g[0])},
+		{"a governor and an outsider", governance(utxi.AddIssuer, key, 1, g[0], newTestWallet(t))},
+	}
+	for _, tc := range rejected {
+		if err := chain.deliver(t, "Governance", tc.gov); err == nil {
+			t.Errorf("%v: governance applied", tc.name)
+		}
+	}
+	if _, err := chain.GetIssuer(key); err == nil {
+		t.Fatal("rejected governance registered the lender")
+	}
+
+	add := governance(utxi.AddIssuer, key, 1, g[0], g[2])
+	if err := chain.deliver(t, "Governance", add); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "Governance", add); err == nil {
+		t.Error("governance transaction applied twice")
+	}
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	if err := chain.deliver(t, "IssueDebt", newLender.ConstructDebtTransaction(borrowerAddr, 100)); err != nil {
+		t.Errorf("added lender cannot issue: %v", err)
+	}
+}
+
+func TestSuspendLender(t *testing.T) {
+	chain := newTestChain(t)
+	key, _ := chain.lender.PublicKey(1)
+	g := chain.governors
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+
+	if err := chain.deliver(t, "Governance", governance(utxi.SuspendIssuer, key, 1, g[0], g[1])); err != nil {
+		t.Fatal(err)
+	}
+	if issuer, err := chain.GetIssuer(key); err != nil || issuer.IsActive() {
+		t.Fatalf("issuer %+v, %v", issuer, err)
+	}
+	if err := chain.deliver(t, "IssueDebt", chain.lender.ConstructDebtTransaction(borrowerAddr, 100)); err == nil {
+		t.Error("suspended lender issued debt")
+	}
+
+	// re-adding the lender needs a fresh nonce
+	if err := chain.deliver(t, "Governance", governance(utxi.AddIssuer, key, 2, g[1], g[2])); err != nil {
+		t.Fatal(err)
+	}
+	chain.issue(t, borrowerAddr, 100)
+
+	if err := chain.deliver(t, "Governance", governance(utxi.RemoveIssuer, key, 3, g[0], g[1])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.GetIssuer(key); err == nil {
+		t.Error("removed lender still registered")
+	}
+	otherAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, otherAddr)
+	if err := chain.deliver(t, "IssueDebt", chain.lender.ConstructDebtTransaction(otherAddr, 100)); err == nil {
+		t.Error("removed lender issued debt")
+	}
+	unknown, _ := newTestWallet(t).PublicKey(1)
+	if err := chain.deliver(t, "Governance", governance(utxi.SuspendIssuer, unknown, 1, g[0], g[1])); err == nil {
+		t.Error("unregistered lender suspended")
+	}
+}
+
+func TestGenesisThreshold(t *testing.T) {
+	governor, _ := newTestWallet(t).PublicKey(1)
+	cases := []struct {
+		name	string
+		state	GenesisState
+		valid	bool
+	}{
+		{"no governors", GenesisState{}, true},
+		{"majority by default", GenesisState{Governors: [][]byte{governor}}, true},
+		{"negative threshold", GenesisState{Governors: [][]byte{governor}, Threshold: -1}, false},
+		{"threshold above the governors", GenesisState{Governors: [][]byte{governor}, Threshold: 2}, false},
+	}
+	for _, tc := range cases {
+		appState, err := json.Marshal(tc.state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app := NewHELB(openDB(t), openDB(t), openDB(t), openDB(t), openDB(t), openDB(t), openDB(t), openDB(t), openDB(t))
+		if err := app.InitRegistry(appState); (err == nil) != tc.valid {
+			t.Errorf("%v: %v", tc.name, err)
+		}
+	}
+}
+
+func TestRotateGovernors(t *testing.T) {
+	chain := newTestChain(t)
+	g := chain.governors
+	newGovernor := newTestWallet(t)
+	newKey, _ := newGovernor.PublicKey(1)
+	oldKey, _ := g[0].PublicKey(1)
+
+	rotation := func(action utxi.GovernanceAction, key []byte, threshold int, signers ...*wallet.Wallet) utxi.Governance {
+		gov := utxi.Governance{Action: action, Key: key, Threshold: threshold, Nonce: 1}
+		for _, signer := range signers {
+			signer.SignGovernance(&gov)
+		}
+		return gov
+	}
+	if err := chain.deliver(t, "Governance", rotation(utxi.AddGovernor, newKey, 3, g[0], g[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "Governance", rotation(utxi.RemoveGovernor, oldKey, 0, g[1], g[2])); err == nil {
+		t.Error("governance applied below the raised threshold")
+	}
+	if err := chain.deliver(t, "Governance", rotation(utxi.RemoveGovernor, oldKey, 2, g[1], g[2], newGovernor)); err != nil {
+		t.Fatal(err)
+	}
+	governors, err := chain.getGovernors()
+	if err != nil || len(governors.Governors) != 3 || governors.Threshold != 2 {
+		t.Fatalf("governors %+v, %v", governors, err)
+	}
+
+	lenderKey, _ := newTestWallet(t).PublicKey(1)
+	if err := chain.deliver(t, "Governance", governance(utxi.AddIssuer, lenderKey, 1, g[0], g[1])); err == nil {
+		t.Error("removed governor signed governance")
+	}
+	if err := chain.deliver(t, "Governance", governance(utxi.AddIssuer, lenderKey, 1, g[2], newGovernor)); err != nil {
+		t.Errorf("added governor cannot sign governance: %v", err)
+	}
+
+	rejected := []struct {
+		name	string
+		gov		utxi.Governance
+	}{
+		{"governor added twice", rotation(utxi.AddGovernor, newKey, 0, g[1], g[2])},
+		{"unknown governor removed", rotation(utxi.RemoveGovernor, oldKey, 0, g[1], g[2])},
+		{"threshold above the governors", rotation(utxi.RemoveGovernor, newKey, 3, g[1], g[2])},
+		{"negative threshold", rotation(utxi.RemoveGovernor, newKey, -1, g[1], g[2])},
+	}
+	for _, tc := range rejected {
+		if err := chain.deliver(t, "Governance", tc.gov); err == nil {
+			t.Errorf("%v: governance applied", tc.name)
+		}
+	}
+}
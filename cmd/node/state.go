@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"debtchain/pkg/smt"
+	"debtchain/pkg/sumtree"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/tendermint/tendermint/crypto/merkle"
+)
+
+/*
+	The app hash. Every store is committed as a sparse Merkle tree of its
+	keys and values, and the app hash is the Tendermint simple map of the
+	store roots by store name, so a value can be proven against the app
+	hash with an smt:v operator (store root) followed by a simple:v one (app
+	hash). The liabilities sum tree root is committed under "liabilities"
+	as its hash followed by the big endian total.
+	The trees are built from the stores when the node starts and then
+	updated with the writes of each block, so a commit costs O(block
+	writes), not O(total state). Past heights are proven against the
+	current trees with the later heights' undo records applied.
+*/
+
+const liabilitiesStore = "liabilities"
+
+// stateStores names the stores covered by the app hash.
+func (app *HELB) stateStores() map[string]*Store {
+	return map[string]*Store{
+		"transactions": app.transactions,
+		"utxo": app.utxoPool,
+		"debt": app.debtPool,
+		"loans": app.loans,
+		"pools": app.loanPools,
+		"registry": app.registry,
+		"attestations": app.attestations,
+		"oracles": app.oracles,
+	}
+}
+
+// buildTrees hashes the committed contents of every store.
+func (app *HELB) buildTrees() error {
+	trees := make(map[string]*smt.Tree)
+	for name, store := range app.stateStores() {
+		tree := smt.New()
+		err := store.db.View(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				tree = tree.Set(item.KeyCopy(nil), value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		trees[name] = tree
+	}
+	app.trees = trees
+	return nil
+}
+
+func applyWrites(tree *smt.Tree, writes []journalWrite) *smt.Tree {
+	for _, write := range writes {
+		if write.Deleted {
+			tree = tree.Delete(write.Key)
+		} else {
+			tree = tree.Set(write.Key, write.Value)
+		}
+	}
+	return tree
+}
+
+// updateTrees applies the writes of the batch to the store trees.
+func (app *HELB) updateTrees(batch *Batch) error {
+	trees := make(map[string]*smt.Tree)
+	for name, store := range app.stateStores() {
+		writes, err := batch.Writes(store)
+		if err != nil {
+			return err
+		}
+		trees[name] = applyWrites(app.trees[name], writes)
+	}
+	app.trees = trees
+	return nil
+}
+
+func (app *HELB) liabilitiesRootBytes() []byte {
+	sumBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(sumBytes, app.liabilitiesRoot.Sum)
+	return append(append([]byte{}, app.liabilitiesRoot.Hash...), sumBytes...)
+}
+
+// storeRoots returns the root of every store, and of the liabilities tree.
+func (app *HELB) storeRoots() map[string][]byte {
+	roots := make(map[string][]byte)
+	for name, tree := range app.trees {
+		roots[name] = tree.Root()
+	}
+	roots[liabilitiesStore] = app.liabilitiesRootBytes()
+	return roots
+}
+
+func (app *HELB) AppHash() []byte {
+	return merkle.SimpleHashFromMap(app.storeRoots())
+}
+
+// ProveKey returns the stored value of a key with its proof against the
+// app hash: an smt:v operator for the key in its store root, then a
+// simple:v one for the store root in the app hash. Absent keys cannot be
+// proven.
+func (app *HELB) ProveKey(storeName string, key []byte) ([]byte, *merkle.Proof, error) {
+	store, ok := app.stateStores()[storeName]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown store %v", storeName)
+	}
+	var value []byte
+	err := store.View(func(txn *Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	keyProof, ok := app.trees[storeName].Prove(key)
+	if !ok {
+		return nil, nil, fmt.Errorf("key is not in the %v tree", storeName)
+	}
+	_, rootProofs, _ := merkle.SimpleProofsFromMap(app.storeRoots())
+	return value, &merkle.Proof{Ops: []merkle.ProofOp{
+		keyProof.ProofOp(),
+		merkle.NewSimpleValueOp([]byte(storeName), rootProofs[storeName]).ProofOp(),
+	}}, nil
+}
+
+/*
+	What the node needs to resume after a restart, written with every
+	commit. Info reports the height and app hash so Tendermint replays only
+	the blocks committed after them.
+*/
+type committedState struct {
+	Height			int64
+	AppHash			[]byte
+	BlockTime		int64
+	Liabilities		sumtree.Node
+}
+
+/*
+	Commit first writes the block's writes and the new committed state to
+	the state DB in one journal entry, then applies them to the stores and
+	replaces the journal with the committed state. A node that stops in
+	between finishes the commit from the journal when it restarts.
+*/
+type blockJournal struct {
+	State			committedState
+	Writes			map[string][]journalWrite
+	// the values the writes replace, kept for queries at past heights
+	Undo			map[string][]journalWrite
+}
+
+const (
+	committedStateKey	= "committed"
+	journalKey			= "journal"
+)
+
+// LoadState restores the last committed state and rebuilds the store trees
+// from it; a new node starts from zero.
+func (app *HELB) LoadState() error {
+	err := app.recoverJournal()
+	if err != nil {
+		return err
+	}
+	var state committedState
+	err = app.state.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(committedStateKey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &state)
+		})
+	})
+	if err == nil {
+		app.setCommittedState(state)
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+	app.resetLiabilities()
+	return app.buildTrees()
+}
+
+func (app *HELB) committedState() committedState {
+	return committedState{
+		Height: app.height,
+		AppHash: app.lastHash,
+		BlockTime: app.blockTime,
+		Liabilities: app.liabilitiesRoot,
+	}
+}
+
+func (app *HELB) setCommittedState(state committedState) {
+	app.height = state.Height
+	app.lastHash = state.AppHash
+	app.blockTime = state.BlockTime
+	app.liabilitiesRoot = state.Liabilities
+}
+
+// recoverJournal finishes a commit that was interrupted after its journal was written.
+func (app *HELB) recoverJournal() error {
+	var journal blockJournal
+	err := app.state.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(journalKey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &journal)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	stores := app.stateStores()
+	for name, writes := range journal.Writes {
+		err = stores[name].apply(writes)
+		if err != nil {
+			return err
+		}
+	}
+	return app.finishCommit(journal)
+}
+
+// saveState commits the block batch together with the committed state.
+func (app *HELB) saveState() error {
+	journal, err := app.newJournal()
+	if err != nil {
+		return err
+	}
+	err = app.writeJournal(journal)
+	if err != nil {
+		return err
+	}
+	err = app.currentBatch.Flush()
+	if err != nil {
+		return err
+	}
+	return app.finishCommit(journal)
+}
+
+// newJournal collects the writes of the block and the state it commits.
+func (app *HELB) newJournal() (blockJournal, error) {
+	journal := blockJournal{
+		State: app.committedState(),
+		Writes: make(map[string][]journalWrite),
+		Undo: make(map[string][]journalWrite),
+	}
+	for name, store := range app.stateStores() {
+		writes, err := app.currentBatch.Writes(store)
+		if err != nil {
+			return journal, err
+		}
+		if len(writes) == 0 {
+			continue
+		}
+		journal.Writes[name] = writes
+		journal.Undo[name], err = undoWrites(store, writes)
+		if err != nil {
+			return journal, err
+		}
+	}
+	return journal, nil
+}
+
+func (app *HELB) writeJournal(journal blockJournal) error {
+	journalAsJson, _ := json.Marshal(journal)
+	return app.state.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(journalKey), journalAsJson)
+	})
+}
+
+// finishCommit records the committed state and the height's undo record,
+// pruning the records that left the retention window.
+func (app *HELB) finishCommit(journal blockJournal) error {
+	stateAsJson, _ := json.Marshal(journal.State)
+	recordAsJson, _ := json.Marshal(heightRecord{State: journal.State, Undo: journal.Undo})
+	return app.state.Update(func(txn *badger.Txn) error {
+		err := txn.Set([]byte(committedStateKey), stateAsJson)
+		if err != nil {
+			return err
+		}
+		err = txn.Set(historyKey(journal.State.Height), recordAsJson)
+		if err != nil {
+			return err
+		}
+		err = app.pruneHistory(txn, journal.State.Height)
+		if err != nil {
+			return err
+		}
+		return txn.Delete([]byte(journalKey))
+	})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"debtchain/pkg/utxi"
+
+	"github.com/dgraph-io/badger/v2"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+func TestRestart(t *testing.T) {
+	chain := newTestChain(t)
+	if info := chain.Info(abcitypes.RequestInfo{}); info.LastBlockHeight != 0 || len(info.LastBlockAppHash) != 0 {
+		t.Fatalf("new node reports height %v, app hash %x", info.LastBlockHeight, info.LastBlockAppHash)
+	}
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	chain.beginBlock(time.Unix(1000, 0))
+	chain.issue(t, borrowerAddr, 100)
+	first := chain.Commit().Data
+	chain.beginBlock(time.Unix(2000, 0))
+	chain.issue(t, borrowerAddr, 50)
+	second := chain.Commit().Data
+	if bytes.Equal(first, second) {
+		t.Error("app hash did not change with the state")
+	}
+
+	chain.restart(t)
+	info := chain.Info(abcitypes.RequestInfo{})
+	if info.LastBlockHeight != 2 || !bytes.Equal(info.LastBlockAppHash, second) {
+		t.Errorf("restarted node reports height %v, app hash %x; want 2, %x", info.LastBlockHeight, info.LastBlockAppHash, second)
+	}
+	if chain.blockTime != 2000 {
+		t.Errorf("restarted node has block time %v, want 2000", chain.blockTime)
+	}
+	if chain.liabilitiesRoot.Sum != 150 {
+		t.Errorf("restarted node has liabilities %v, want 150", chain.liabilitiesRoot.Sum)
+	}
+	if hash := chain.AppHash(); !bytes.Equal(hash, second) {
+		t.Errorf("app hash recomputed after restart is %x", hash)
+	}
+
+	// an empty block after the restart commits the same state at the next height
+	chain.beginBlock(time.Unix(3000, 0))
+	if third := chain.Commit().Data; !bytes.Equal(third, second) {
+		t.Errorf("empty block changed the app hash to %x", third)
+	}
+	if info := chain.Info(abcitypes.RequestInfo{}); info.LastBlockHeight != 3 {
+		t.Errorf("height %v after another commit, want 3", info.LastBlockHeight)
+	}
+}
+
+func TestReplayedBlockRejected(t *testing.T) {
+	chain := newTestChain(t)
+	chain.beginBlock(time.Unix(1000, 0))
+	chain.Commit()
+	defer func() {
+		if recover() == nil {
+			t.Error("block at a committed height was begun")
+		}
+	}()
+	req := abcitypes.RequestBeginBlock{}
+	req.Header.Height = 1
+	chain.BeginBlock(req)
+}
+
+func TestBatchRollback(t *testing.T) {
+	store := NewStore(openDB(t))
+	err := store.Update(func(txn *Txn) error {
+		return txn.Set([]byte("kept"), []byte("committed"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewBatch()
+	store.batch = batch
+	write := func(key, value string) {
+		err := store.Update(func(txn *Txn) error {
+			return txn.Set([]byte(key), []byte(value))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(key string) string {
+		var value []byte
+		err := store.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte(key))
+			if err != nil {
+				return err
+			}
+			value, err = item.ValueCopy(nil)
+			return err
+		})
+		if err == badger.ErrKeyNotFound {
+			return ""
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(value)
+	}
+
+	batch.Begin()
+	write("first", "1")
+	batch.Begin()
+	write("kept", "overwritten")
+	write("kept", "twice")
+	write("second", "2")
+	if err := batch.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if read("kept") != "committed" || read("second") != "" {
+		t.Errorf("after rollback kept=%q second=%q", read("kept"), read("second"))
+	}
+	if read("first") != "1" {
+		t.Error("rollback undid an earlier transaction")
+	}
+
+	// nothing reaches the DB before Flush
+	store.batch = nil
+	if read("first") != "" {
+		t.Error("batch write visible before flush")
+	}
+	if err := batch.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if read("first") != "1" || read("kept") != "committed" {
+		t.Errorf("after flush first=%q kept=%q", read("first"), read("kept"))
+	}
+}
+
+func TestFailedDeliverTxRolledBack(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+
+	chain.beginBlock(time.Unix(1000, 0))
+	debtTx := chain.issue(t, borrowerAddr, 100)
+	chain.Commit()
+	odtx := utxi.MakeOutstandingDebtTx(debtTx)
+	before := chain.AppHash()
+
+	chain.beginBlock(time.Unix(2000, 0))
+	// the repayment is stored before the overpayment is found
+	overpaid := borrower.ConstructRepaymentTransaction(1, lenderAddr, 500, odtx, 0)
+	if err := chain.deliver(t, "Repayment", overpaid); err == nil {
+		t.Fatal("overpayment delivered")
+	}
+	if _, err := chain.GetTransaction(overpaid.Hash()); err == nil {
+		t.Error("failed repayment left in the transactions store")
+	}
+	if after := chain.AppHash(); !bytes.Equal(after, before) {
+		t.Error("failed repayment changed the state")
+	}
+
+	// a later transaction in the same block still applies
+	repayment := borrower.ConstructRepaymentTransaction(1, lenderAddr, 30, odtx, 0)
+	if err := chain.deliver(t, "Repayment", repayment); err != nil {
+		t.Fatal(err)
+	}
+	chain.Commit()
+	if _, err := chain.GetTransaction(overpaid.Hash()); err == nil {
+		t.Error("failed repayment committed")
+	}
+	if _, err := chain.GetTransaction(repayment.Hash()); err != nil {
+		t.Errorf("repayment not committed: %v", err)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	chain := newTestChain(t)
+	borrowerAddr, _ := newTestWallet(t).PublicKey(1)
+	chain.attest(t, borrowerAddr)
+	chain.beginBlock(time.Unix(1000, 0))
+	debtTx := chain.issue(t, borrowerAddr, 100)
+
+	// Commit as far as the journal, then flush only the debt pool
+	tree, err := chain.LiabilitiesTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.liabilitiesRoot = tree.Root()
+	if err := chain.updateTrees(chain.currentBatch); err != nil {
+		t.Fatal(err)
+	}
+	chain.lastHash = chain.AppHash()
+	chain.height++
+	journal, err := chain.newJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.writeJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.currentBatch.txns[chain.debtPool].Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	chain.restart(t)
+	info := chain.Info(abcitypes.RequestInfo{})
+	if info.LastBlockHeight != 1 || !bytes.Equal(info.LastBlockAppHash, journal.State.AppHash) {
+		t.Errorf("recovered node reports height %v, app hash %x; want 1, %x", info.LastBlockHeight, info.LastBlockAppHash, journal.State.AppHash)
+	}
+	if hash := chain.AppHash(); !bytes.Equal(hash, journal.State.AppHash) {
+		t.Errorf("recovered stores hash to %x", hash)
+	}
+	if _, err := chain.GetTransaction(debtTx.Hash()); err != nil {
+		t.Errorf("debt transaction not recovered: %v", err)
+	}
+	if err, total := chain.GetTotalDebt(); err != nil || total != 100 {
+		t.Errorf("recovered total debt %v, %v; want 100", total, err)
+	}
+	err = chain.state.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(journalKey))
+		return err
+	})
+	if err != badger.ErrKeyNotFound {
+		t.Errorf("journal left after recovery: %v", err)
+	}
+}
+
+func TestIncrementalTrees(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	borrowerAddr, _ := borrower.PublicKey(1)
+	lenderAddr, _ := chain.lender.PublicKey(2)
+	chain.beginBlock(time.Unix(1000, 0))
+	debtTx := chain.issue(t, borrowerAddr, 100)
+	chain.issue(t, borrowerAddr, 50)
+	chain.Commit()
+	// repaid in full, the debt leaves the debt pool
+	chain.beginBlock(time.Unix(2000, 0))
+	repayment := borrower.ConstructRepaymentTransaction(1, lenderAddr, 100, utxi.MakeOutstandingDebtTx(debtTx), 0)
+	if err := chain.deliver(t, "Repayment", repayment); err != nil {
+		t.Fatal(err)
+	}
+	hash := chain.Commit().Data
+
+	// the trees updated from the block writes match ones built from the stores
+	if err := chain.buildTrees(); err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt := chain.AppHash(); !bytes.Equal(rebuilt, hash) {
+		t.Errorf("rebuilt trees hash to %x, want %x", rebuilt, hash)
+	}
+	if chain.currentBatch.liabilities != 50 || chain.checkBatch.liabilities != 50 {
+		t.Errorf("running liabilities %v, %v after commit; want 50", chain.currentBatch.liabilities, chain.checkBatch.liabilities)
+	}
+}
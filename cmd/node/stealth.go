@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/base64"
+
+	"debtchain/pkg/utxi"
+	"debtchain/internal/envelope"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+// GetStealthOutputs lists every recorded stealth output for wallets to scan
+// with their scan keys.
+func (app *HELB) GetStealthOutputs() ([]utxi.RecordedOutput, error) {
+	return app.recordedOutputs(func(output utxi.TxOutput) bool {
+		return output.IsStealth()
+	})
+}
+
+// checkStealthOutputs rejects stealth outputs paying to an address that is
+// already in the UTXO pool or paid by another output of the transaction,
+// since the point of a one-time address is that it is never reused.
+func (app *HELB) checkStealthOutputs(tx utxi.Transaction) error {
+	seen := make(map[string]bool)
+	return app.utxoPool.View(func(txn *Txn) error {
+		for _, output := range tx.Outputs {
+			if !output.IsStealth() {
+				continue
+			}
+			if seen[string(output.RecipientAddr())] {
+				return envelope.ErrInvalidTx.Wrapf("stealth address reused: %v", base64.URLEncoding.EncodeToString(output.RecipientAddr()))
+			}
+			seen[string(output.RecipientAddr())] = true
+			_, err := txn.Get(output.RecipientAddr())
+			if err == nil {
+				return envelope.ErrInvalidTx.Wrapf("stealth address reused: %v", base64.URLEncoding.EncodeToString(output.RecipientAddr()))
+			}
+			if err != badger.ErrKeyNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
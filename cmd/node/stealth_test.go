@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"debtchain/pkg/utxi"
+)
+
+func TestStealthAddressReused(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	chain.attest(t, borrower.StealthAddress().SpendKey)
+	debtTx, err := chain.lender.ConstructStealthDebtTransaction(borrower.StealthAddress(), 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oneTime := debtTx.Outputs[0].RecipientAddr()
+
+	twice := debtTx
+	twice.Inputs = append([]utxi.TxInput{}, debtTx.Inputs...)
+	twice.Outputs = append([]utxi.TxOutput{}, debtTx.Outputs[0], debtTx.Outputs[0])
+	chain.lender.SignTransaction(1, &twice)
+	if err := chain.deliver(t, "IssueDebt", twice); err == nil {
+		t.Error("stealth address paid twice in a transaction")
+	}
+
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+	reused := chain.lender.ConstructDebtTransaction(oneTime, 50)
+	reused.Outputs[0] = debtTx.Outputs[0]
+	reused.Outputs[0].Value = 50
+	chain.lender.SignTransaction(1, &reused)
+	if err := chain.deliver(t, "IssueDebt", reused); err == nil {
+		t.Error("stealth address in the UTXO pool paid again")
+	}
+
+	outputs, err := chain.GetStealthOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := borrower.FindStealthOutputs(outputs)
+	if len(found) != 1 || found[0].Output.Value != 100 {
+		t.Errorf("borrower found %+v", found)
+	}
+}
+
+func TestStealthKYC(t *testing.T) {
+	chain := newTestChain(t)
+	borrower := newTestWallet(t)
+	spendKey := borrower.StealthAddress().SpendKey
+	attested := newTestWallet(t).StealthAddress().SpendKey
+	unattested := newTestWallet(t).StealthAddress().SpendKey
+	chain.attest(t, spendKey)
+	chain.attest(t, attested)
+
+	unproven, err := chain.lender.ConstructStealthDebtTransaction(borrower.StealthAddress(), 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unproven.Outputs[0].StealthProof = nil
+	chain.lender.SignTransaction(1, &unproven)
+	withUnattested, err := chain.lender.ConstructStealthDebtTransaction(borrower.StealthAddress(), 100, [][]byte{attested, unattested})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger, err := chain.lender.ConstructStealthDebtTransaction(newTestWallet(t).StealthAddress(), 100, [][]byte{spendKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := []struct {
+		name	string
+		debtTx	utxi.Transaction
+	}{
+		{"no proof", unproven},
+		{"unattested decoy", withUnattested},
+		{"unattested borrower", stranger},
+	}
+	for _, tc := range rejected {
+		if err := chain.deliver(t, "IssueDebt", tc.debtTx); err == nil {
+			t.Errorf("%v: debt issued", tc.name)
+		}
+	}
+
+	// the one-time address itself is never attested
+	debtTx, err := chain.lender.ConstructStealthDebtTransaction(borrower.StealthAddress(), 100, [][]byte{attested})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.deliver(t, "IssueDebt", debtTx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.GetAttestation(utxi.AttestKYC, debtTx.Outputs[0].RecipientAddr()); err == nil {
+		t.Error("one-time address attested")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+/*
+	Block writes. Every state store is a Store, a badger DB whose reads and
+	writes go through the batch in use: one badger transaction per DB. The
+	block batch is committed only in Commit; the check batch holds what
+	CheckTx has admitted to the mempool and is discarded after every
+	Commit. Each transaction keeps an undo journal so a failed one is
+	rolled back without touching the rest of the batch. Without a batch
+	(InitChain, queries) a Store reads and writes the committed DB directly.
+*/
+
+type Store struct {
+	db		*badger.DB
+	// nil when reading committed state
+	batch	*Batch
+}
+
+// Txn is a badger transaction whose writes are journaled in the batch.
+type Txn struct {
+	*badger.Txn
+	store	*Store
+}
+
+type undoEntry struct {
+	store	*Store
+	key		[]byte
+	value	[]byte
+	existed	bool
+}
+
+type Batch struct {
+	txns		map[*Store]*badger.Txn
+	// keys written in the batch, by store
+	written		map[*Store]map[string]bool
+	// previous values of the keys written by the current transaction
+	undo		[]undoEntry
+	// total of the liabilities tree with the batch's writes, kept by the app
+	liabilities	uint64
+}
+
+func NewBatch() *Batch {
+	return &Batch{
+		txns: make(map[*Store]*badger.Txn),
+		written: make(map[*Store]map[string]bool),
+	}
+}
+
+func NewStore(db *badger.DB) *Store {
+	return &Store{db: db}
+}
+
+// txn returns the transaction of the store in the batch, opening it on first use.
+func (batch *Batch) txn(s *Store) *badger.Txn {
+	txn, ok := batch.txns[s]
+	if !ok {
+		txn = s.db.NewTransaction(true)
+		batch.txns[s] = txn
+		batch.written[s] = make(map[string]bool)
+	}
+	return txn
+}
+
+// use routes every store through the batch, or to committed state for a
+// nil batch, until the returned function is called.
+func (app *HELB) use(batch *Batch) func() {
+	previous := app.transactions.batch
+	for _, s := range app.stateStores() {
+		s.batch = batch
+	}
+	return func() {
+		for _, s := range app.stateStores() {
+			s.batch = previous
+		}
+	}
+}
+
+func (s *Store) View(fn func(txn *Txn) error) error {
+	if s.batch != nil {
+		return fn(&Txn{s.batch.txn(s), s})
+	}
+	return s.db.View(func(txn *badger.Txn) error {
+		return fn(&Txn{txn, s})
+	})
+}
+
+func (s *Store) Update(fn func(txn *Txn) error) error {
+	if s.batch != nil {
+		return fn(&Txn{s.batch.txn(s), s})
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return fn(&Txn{txn, s})
+	})
+}
+
+func (txn *Txn) Set(key, value []byte) error {
+	err := txn.journal(key)
+	if err != nil {
+		return err
+	}
+	return txn.Txn.Set(key, value)
+}
+
+func (txn *Txn) Delete(key []byte) error {
+	err := txn.journal(key)
+	if err != nil {
+		return err
+	}
+	return txn.Txn.Delete(key)
+}
+
+// journal records the value a key had before the current transaction first wrote it.
+func (txn *Txn) journal(key []byte) error {
+	batch := txn.store.batch
+	if batch == nil {
+		return nil
+	}
+	batch.written[txn.store][string(key)] = true
+	for _, entry := range batch.undo {
+		if entry.store == txn.store && bytes.Equal(entry.key, key) {
+			return nil
+		}
+	}
+	entry := undoEntry{store: txn.store, key: append([]byte{}, key...)}
+	item, err := txn.Txn.Get(key)
+	if err == nil {
+		entry.value, err = item.ValueCopy(nil)
+		entry.existed = true
+	}
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	batch.undo = append(batch.undo, entry)
+	return nil
+}
+
+// Begin starts a transaction within the batch.
+func (batch *Batch) Begin() {
+	batch.undo = batch.undo[:0]
+}
+
+// Rollback restores every key written since Begin.
+func (batch *Batch) Rollback() error {
+	for i := len(batch.undo) - 1; i >= 0; i-- {
+		entry := batch.undo[i]
+		var err error
+		if entry.existed {
+			err = batch.txn(entry.store).Set(entry.key, entry.value)
+		} else {
+			err = batch.txn(entry.store).Delete(entry.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	batch.undo = batch.undo[:0]
+	return nil
+}
+
+type journalWrite struct {
+	Key		[]byte
+	Value	[]byte
+	Deleted	bool
+}
+
+// changes lists the keys of the store written since Begin with their previous values.
+func (batch *Batch) changes(s *Store) []undoEntry {
+	var changes []undoEntry
+	for _, entry := range batch.undo {
+		if entry.store == s {
+			changes = append(changes, entry)
+		}
+	}
+	return changes
+}
+
+// Wrote reports whether the batch wrote any key of the store.
+func (batch *Batch) Wrote(s *Store) bool {
+	return len(batch.written[s]) > 0
+}
+
+// Writes lists the final value of every key the block wrote in the store.
+func (batch *Batch) Writes(s *Store) ([]journalWrite, error) {
+	keys := make([]string, 0, len(batch.written[s]))
+	for key := range batch.written[s] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writes := make([]journalWrite, 0, len(keys))
+	for _, key := range keys {
+		write := journalWrite{Key: []byte(key)}
+		item, err := batch.txn(s).Get(write.Key)
+		if err == badger.ErrKeyNotFound {
+			write.Deleted = true
+		} else if err != nil {
+			return nil, err
+		} else if write.Value, err = item.ValueCopy(nil); err != nil {
+			return nil, err
+		}
+		writes = append(writes, write)
+	}
+	return writes, nil
+}
+
+// Flush commits the transaction of every store and empties the batch.
+func (batch *Batch) Flush() error {
+	for s, txn := range batch.txns {
+		err := txn.Commit()
+		if err != nil {
+			return err
+		}
+		delete(batch.txns, s)
+		delete(batch.written, s)
+	}
+	batch.undo = batch.undo[:0]
+	return nil
+}
+
+// Discard drops every write in the batch.
+func (batch *Batch) Discard() {
+	for s, txn := range batch.txns {
+		txn.Discard()
+		delete(batch.txns, s)
+		delete(batch.written, s)
+	}
+	batch.undo = batch.undo[:0]
+}
+
+// stage writes journaled writes into the batch without journaling them.
+func (batch *Batch) stage(s *Store, writes []journalWrite) error {
+	for _, write := range writes {
+		var err error
+		if write.Deleted {
+			err = batch.txn(s).Delete(write.Key)
+		} else {
+			err = batch.txn(s).Set(write.Key, write.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply writes journaled writes straight to the committed DB.
+func (s *Store) apply(writes []journalWrite) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		for _, write := range writes {
+			var err error
+			if write.Deleted {
+				err = txn.Delete(write.Key)
+			} else {
+				err = txn.Set(write.Key, write.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -11,7 +11,6 @@ require (
 	github.com/dgraph-io/badger/v2 v2.2007.2
 	github.com/spf13/viper v1.6.3
 	github.com/tendermint/tendermint v0.33.8
-	github.com/tendermint/tmlibs v0.9.0
 	github.com/tyler-smith/go-bip32 v0.0.0-20170922074101-2c9cfd177564
 	github.com/tyler-smith/go-bip39 v1.0.2
 	launchpad.net/gocheck v0.0.0-20140225173054-000000000087 // indirect
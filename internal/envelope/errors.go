@@ -0,0 +1,119 @@
+package envelope
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+	Error codes returned in the Code and Codespace of CheckTx, DeliverTx
+	and Query responses, with the message in Log. Codes are stable: a code
+	is never reused for another error within its codespace, so clients can
+	map a response back to the error with Decode.
+*/
+
+const (
+	CodespaceTx			= "tx"
+	CodespaceDebt		= "debt"
+	CodespaceRegistry	= "registry"
+	CodespaceCollateral	= "collateral"
+	CodespaceQuery		= "query"
+)
+
+type Error struct {
+	Codespace	string
+	Code		uint32
+	Desc		string
+}
+
+var registered = make(map[string]map[uint32]*Error)
+
+func register(codespace string, code uint32, desc string) *Error {
+	if code == 0 {
+		panic("code 0 is reserved for success")
+	}
+	if registered[codespace] == nil {
+		registered[codespace] = make(map[uint32]*Error)
+	}
+	if _, ok := registered[codespace][code]; ok {
+		panic(fmt.Sprintf("code %v registered twice in codespace %v", code, codespace))
+	}
+	e := &Error{Codespace: codespace, Code: code, Desc: desc}
+	registered[codespace][code] = e
+	return e
+}
+
+var (
+	ErrEncoding				= register(CodespaceTx, 1, "encoding error")
+	ErrUnknownCommand		= register(CodespaceTx, 2, "unknown command")
+	ErrInvalidTx			= register(CodespaceTx, 3, "invalid transaction")
+	ErrSignature			= register(CodespaceTx, 4, "invalid signature")
+	ErrDuplicateTx			= register(CodespaceTx, 5, "transaction already delivered")
+	ErrInternal				= register(CodespaceTx, 6, "internal error")
+
+	ErrUnknownLoan			= register(CodespaceDebt, 1, "unknown loan")
+	ErrInsufficientFunds	= register(CodespaceDebt, 2, "insufficient funds")
+	ErrLoanClosed			= register(CodespaceDebt, 3, "loan is closed")
+
+	ErrUnauthorizedIssuer	= register(CodespaceRegistry, 1, "unauthorized issuer")
+	ErrNotAttested			= register(CodespaceRegistry, 2, "borrower not attested")
+	ErrUnknownAsset			= register(CodespaceRegistry, 3, "unknown asset")
+	ErrUnauthorized			= register(CodespaceRegistry, 4, "unauthorized")
+
+	ErrCollateral			= register(CodespaceCollateral, 1, "insufficient collateral")
+
+	ErrUnknownPath			= register(CodespaceQuery, 1, "unknown query path")
+	ErrNotFound				= register(CodespaceQuery, 2, "not found")
+	ErrNoProof				= register(CodespaceQuery, 3, "path cannot be proven")
+	ErrUnknownHeight		= register(CodespaceQuery, 4, "height not available")
+)
+
+func (e *Error) Error() string {
+	return e.Desc
+}
+
+// Wrap adds the cause to the error, keeping its code.
+func (e *Error) Wrap(cause error) error {
+	return fmt.Errorf("%w: %v", e, cause)
+}
+
+func (e *Error) Wrapf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %v", e, fmt.Sprintf(format, args...))
+}
+
+// ABCIInfo returns the codespace, code and log of a response failing with
+// err. Errors without a code are reported as invalid transactions.
+func ABCIInfo(err error) (string, uint32, string) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = ErrInvalidTx
+		err = e.Wrap(err)
+	}
+	return e.Codespace, e.Code, err.Error()
+}
+
+// Decode maps a failed response back to its error, which errors.Is matches
+// against the registered errors.
+func Decode(codespace string, code uint32, log string) error {
+	if code == 0 {
+		return nil
+	}
+	e, ok := registered[codespace][code]
+	if !ok {
+		return fmt.Errorf("code %v in codespace %q: %v", code, codespace, log)
+	}
+	return &decodedError{e, log}
+}
+
+type decodedError struct {
+	err		*Error
+	log		string
+}
+
+func (e *decodedError) Error() string {
+	return e.log
+}
+
+func (e *decodedError) Unwrap() error {
+	return e.err
+}
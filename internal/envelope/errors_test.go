@@ -0,0 +1,43 @@
+package envelope
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name	string
+		err		error
+		want	*Error
+	}{
+		{"registered", ErrUnknownLoan, ErrUnknownLoan},
+		{"wrapped", ErrInsufficientFunds.Wrapf("repayment of %v", 500), ErrInsufficientFunds},
+		{"uncoded", errors.New("bad output"), ErrInvalidTx},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codespace, code, log := ABCIInfo(test.err)
+			if codespace != test.want.Codespace || code != test.want.Code {
+				t.Errorf("reported as %v/%v, want %v/%v", codespace, code, test.want.Codespace, test.want.Code)
+			}
+			err := Decode(codespace, code, log)
+			if !errors.Is(err, test.want) {
+				t.Errorf("decoded %v, want %v", err, test.want)
+			}
+			if err.Error() != log {
+				t.Errorf("decoded message %q, want the log %q", err.Error(), log)
+			}
+		})
+	}
+	if err := Decode(CodespaceDebt, 0, ""); err != nil {
+		t.Errorf("code 0 decoded as %v", err)
+	}
+	// codes are per codespace
+	if errors.Is(Decode(CodespaceTx, ErrUnknownLoan.Code, ""), ErrUnknownLoan) {
+		t.Error("code decoded in the wrong codespace")
+	}
+	if err := Decode("unknown", 1, "log"); err == nil {
+		t.Error("unregistered codespace decoded as success")
+	}
+}
@@ -3,11 +3,13 @@ package wallet
 import (
 	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"io/ioutil"
 	"math/big"
 
-	"github.com/FactomProject/btcutilecc"
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/tyler-smith/go-bip32"
 
 	"debtchain/pkg/utxi"
@@ -19,6 +21,14 @@ type Wallet struct {
 	mostRecentKey	uint32
 }
 
+// view keys are derived on a hardened path so handing one out reveals nothing
+// about the spending keys
+const viewKeyIndex uint32 = bip32.FirstHardenedChild + 1
+
+// stealth address keys, hardened for the same reason
+const scanKeyIndex uint32 = bip32.FirstHardenedChild + 2
+const spendKeyIndex uint32 = bip32.FirstHardenedChild + 3
+
 func (w *Wallet) GetToWork() {
 }
 
@@ -46,27 +56,106 @@ func (w *Wallet) PublicKey(which uint32) ([]byte, string) {
 	return childkey_pk.Key, childkey_pk.String()
 }
 
-func (w *Wallet) signPublicKey(address []byte) utxi.UnLockingScript {
+// ViewKey decrypts the memos on outputs paid to this wallet. It can be given
+// to an auditor without giving away spend authority.
+func (w *Wallet) ViewKey() *btcec.PrivateKey {
+	return w.btcecKey(viewKeyIndex)
+}
 
-	curve := btcutil.Secp256k1()
+func (w *Wallet) ViewPublicKey() *btcec.PublicKey {
+	return w.ViewKey().PubKey()
+}
 
-	// we use the first child key to sign everything from the perspective of the creditor
-	childKey1, _ := w.MasterKey.NewChildKey(0)
-	x, y := curve.ScalarBaseMult(childKey1.Key)
+func (w *Wallet) btcecKey(which uint32) *btcec.PrivateKey {
+	childKey, _ := w.MasterKey.NewChildKey(which)
+	key, _ := btcec.PrivKeyFromBytes(btcec.S256(), childKey.Key)
+	return key
+}
 
-	gopublic := ecdsa.PublicKey{curve,x,y}
+// ScanKey finds stealth payments to this wallet but cannot spend them.
+func (w *Wallet) ScanKey() *btcec.PrivateKey {
+	return w.btcecKey(scanKeyIndex)
+}
 
-	var pkInt big.Int
-	pkInt.SetBytes(childKey1.Key)
-	gopriv := ecdsa.PrivateKey{gopublic, &pkInt}
+// StealthAddress is published once; lenders derive a fresh address from it
+// for every payment.
+func (w *Wallet) StealthAddress() utxi.StealthAddress {
+	return utxi.StealthAddress{
+		ScanKey: w.ScanKey().PubKey().SerializeCompressed(),
+		SpendKey: w.btcecKey(spendKeyIndex).PubKey().SerializeCompressed(),
+	}
+}
 
-	r, s, _ := ecdsa.Sign(rand.Reader, &gopriv, address)
-	signed := utxi.EcdsaSignature{r,s}
+// an output paid to one of this wallet's stealth addresses, with the one-time
+// key that signs for it
+type StealthOutput struct {
+	utxi.RecordedOutput
+	Key				*btcec.PrivateKey
+}
 
-	return utxi.UnLockingScript{address, signed}
+// FindStealthOutputs picks out the outputs paid to this wallet's stealth address.
+func (w *Wallet) FindStealthOutputs(outputs []utxi.RecordedOutput) []StealthOutput {
+	spendKey := w.btcecKey(spendKeyIndex)
+	owned := []StealthOutput{}
+	for _, output := range outputs {
+		tweak, ok := utxi.StealthTweak(w.ScanKey(), spendKey.PubKey(), output.Output)
+		if !ok {
+			continue
+		}
+		d := new(big.Int).Add(spendKey.D, tweak)
+		key, _ := btcec.PrivKeyFromBytes(btcec.S256(), d.Mod(d, btcec.S256().N).Bytes())
+		owned = append(owned, StealthOutput{output, key})
+	}
+	return owned
 }
 
-func (w* Wallet) createDebtInput(debtorAddress []byte) utxi.TxInput {
+// OpenTransactionMemo decrypts the memo of a transaction paid to the given child key.
+func (w *Wallet) OpenTransactionMemo(which uint32, tx utxi.Transaction) ([]byte, error) {
+	if len(tx.Memo) == 0 {
+		return nil, errors.New("transaction has no memo")
+	}
+	return utxi.OpenMemo(w.btcecKey(which), tx.Memo)
+}
+
+// Sign signs the sha256 digest of msg with the given child key. The
+// unlocking script names the signing key, so the node can check it with
+// UnLockingScript.Verify.
+func (w *Wallet) Sign(which uint32, msg []byte) utxi.UnLockingScript {
+	return sign(w.btcecKey(which), msg)
+}
+
+func sign(key *btcec.PrivateKey, msg []byte) utxi.UnLockingScript {
+	digest := sha256.Sum256(msg)
+	r, s, _ := ecdsa.Sign(rand.Reader, key.ToECDSA(), digest[:])
+	// the node only accepts the low-S form, see UnLockingScript.Verify
+	if s.Cmp(utxi.HalfOrder) > 0 {
+		s.Sub(btcec.S256().N, s)
+	}
+
+	return utxi.UnLockingScript{
+		PublicKey: key.PubKey().SerializeCompressed(),
+		Signature: utxi.EcdsaSignature{R: r, S: s},
+	}
+}
+
+// SignTransaction signs every input of tx with the given child key over
+// tx.SigningBytes, so it has to be called once every other field is set.
+func (w *Wallet) SignTransaction(which uint32, tx *utxi.Transaction) {
+	SignTransactionWithKey(w.btcecKey(which), tx)
+}
+
+// SignTransactionWithKey signs with a key outside the wallet's tree, such as
+// the one-time key of a stealth output.
+func SignTransactionWithKey(key *btcec.PrivateKey, tx *utxi.Transaction) {
+	msg := tx.SigningBytes()
+	for i := range tx.Inputs {
+		tx.Inputs[i].ScriptSig = sign(key, msg)
+	}
+}
+
+// createDebtInput records the lender; the input is signed by
+// SignTransaction once the transaction is complete.
+func (w* Wallet) createDebtInput() utxi.TxInput {
 
 	var vout int64
 	// one way to indicate that this is a debt input 
@@ -74,43 +163,227 @@ func (w* Wallet) createDebtInput(debtorAddress []byte) utxi.TxInput {
 
 	// note that we can choose the publick key to record onto the blockchain
 	childKey, _ := w.MasterKey.NewChildKey(1)
-	signature := w.signPublicKey(debtorAddress)
 
 	return utxi.TxInput{
 		// this allows us to record the originator of the debt 
 		Txid: childKey.PublicKey().Key,
 		Vout: vout,
-		ScriptSig: signature,
 	}
 }
 
+// ConstructDebtTransaction returns a signed debt transaction; changing it
+// afterwards (a memo, terms) needs a new SignTransaction(1, ...).
 func (w *Wallet) ConstructDebtTransaction(debtorAddress []byte, amount uint64) utxi.Transaction {
 
 	// construct input
-	input := w.createDebtInput(debtorAddress)
+	input := w.createDebtInput()
 	output := utxi.ConstructOutput(debtorAddress, amount)
 
-	return utxi.Transaction{
-		[]utxi.TxInput{input}, 
-		[]utxi.TxOutput{output},
+	debtTx := utxi.Transaction{
+		Inputs: []utxi.TxInput{input}, 
+		Outputs: []utxi.TxOutput{output},
 	}
+	w.SignTransaction(1, &debtTx)
+	return debtTx
 }
 
-func (w *Wallet) CreatePaymentInput(txId, utxoAddress []byte, vout int64) utxi.TxInput {
-	return utxi.TxInput {
-		Txid: txId,
-		Vout: vout,
-		ScriptSig: w.signPublicKey(utxoAddress),
+// ConstructStealthDebtTransaction pays the debt to a one-time address derived
+// from the borrower's stealth address. decoys are spend keys of other
+// attested borrowers that hide which one the output pays.
+func (w *Wallet) ConstructStealthDebtTransaction(to utxi.StealthAddress, amount uint64, decoys [][]byte) (utxi.Transaction, error) {
+	output, err := utxi.ConstructStealthOutput(to, amount, decoys)
+	if err != nil {
+		return utxi.Transaction{}, err
+	}
+	input := w.createDebtInput()
+
+	debtTx := utxi.Transaction{
+		Inputs: []utxi.TxInput{input},
+		Outputs: []utxi.TxOutput{output},
+	}
+	w.SignTransaction(1, &debtTx)
+	return debtTx, nil
+}
+
+// ConstructAssetDebtTransaction issues debt in a registered asset.
+func (w *Wallet) ConstructAssetDebtTransaction(debtorAddress []byte, asset utxi.AssetId, amount uint64) utxi.Transaction {
+	debtTx := w.ConstructDebtTransaction(debtorAddress, amount)
+	debtTx.Outputs[0].Asset = asset
+	w.SignTransaction(1, &debtTx)
+	return debtTx
+}
+
+// ConstructScheduledDebtTransaction issues debt repayable on the given terms.
+func (w *Wallet) ConstructScheduledDebtTransaction(debtorAddress []byte, amount uint64, terms utxi.LoanTerms) utxi.Transaction {
+	debtTx := w.ConstructDebtTransaction(debtorAddress, amount)
+	debtTx.Terms = &terms
+	w.SignTransaction(1, &debtTx)
+	return debtTx
+}
+
+// ConstructConfidentialDebtTransaction issues debt with a committed amount. The
+// opening goes to the borrower, who needs it to repay.
+func (w *Wallet) ConstructConfidentialDebtTransaction(debtorAddress []byte, amount uint64, auditKey *btcec.PublicKey) (utxi.Transaction, utxi.Opening, error) {
+	value, opening, err := utxi.NewConfidentialValue(amount, auditKey)
+	if err != nil {
+		return utxi.Transaction{}, utxi.Opening{}, err
 	}
+	input := w.createDebtInput()
+	output := utxi.ConstructConfidentialOutput(debtorAddress, value)
+
+	debtTx := utxi.Transaction{
+		Inputs: []utxi.TxInput{input},
+		Outputs: []utxi.TxOutput{output},
+	}
+	w.SignTransaction(1, &debtTx)
+	return debtTx, opening, nil
 }
 
-func (w *Wallet) ConstructRepaymentTransaction(repaymentAddress []byte, repaymentAmt uint64, utxoTx utxi.Transaction, vout int64) utxi.Transaction {
+/*
+	ConstructRepaymentTransaction repays the debt utxoTx records, signed by
+	the borrower with the child key the debt was issued to. Changing it
+	afterwards (a memo) needs a new SignTransaction(which, ...); a debt paid
+	to a stealth address is signed with SignTransactionWithKey and the
+	output's one-time key.
+*/
+func (w *Wallet) ConstructRepaymentTransaction(which uint32, repaymentAddress []byte, repaymentAmt uint64, utxoTx utxi.Transaction, vout int64) utxi.Transaction {
+
+	input := utxi.TxInput{Txid: utxoTx.Hash(), Vout: vout}
+	// repaid in the asset of the debt
+	output := utxi.ConstructAssetOutput(repaymentAddress, utxoTx.Outputs[vout].Asset, repaymentAmt)
 
-	input := w.CreatePaymentInput(utxoTx.Hash(), utxoTx.Outputs[vout].RecipientAddr(), vout)
-	output := utxi.ConstructOutput(repaymentAddress, repaymentAmt)
+	repaymentTx := utxi.Transaction{
+		Inputs: []utxi.TxInput{input},
+		Outputs: []utxi.TxOutput{output},
+	}
+	w.SignTransaction(which, &repaymentTx)
+	return repaymentTx
+}
 
-	return utxi.Transaction{
-		[]utxi.TxInput{input},
-		[]utxi.TxOutput{output},
+/*
+	ConstructConfidentialRepaymentTransaction repays part of a confidential
+	debt. It commits to the payment and to the remaining debt, choosing the
+	blinding factors so that the two add up to the debt commitment, and
+	returns the opening of the remaining debt for the next repayment. Like a
+	plaintext repayment it is signed by the borrower's child key which.
+*/
+func (w *Wallet) ConstructConfidentialRepaymentTransaction(which uint32, repaymentAddress []byte, repaymentAmt uint64, loanId []byte, debtTx utxi.Transaction, debtOpening utxi.Opening, auditKey *btcec.PublicKey) (utxi.Transaction, utxi.Opening, error) {
+	if repaymentAmt > debtOpening.Value {
+		return utxi.Transaction{}, utxi.Opening{}, errors.New("repayment exceeds the debt")
 	}
-}
\ No newline at end of file
+	payment, paymentOpening, err := utxi.NewConfidentialValue(repaymentAmt, auditKey)
+	if err != nil {
+		return utxi.Transaction{}, utxi.Opening{}, err
+	}
+	remainingBlinding := new(big.Int).Sub(debtOpening.BlindingFactor(), paymentOpening.BlindingFactor())
+	remaining, remainingOpening, err := utxi.NewConfidentialValueWithBlinding(debtOpening.Value - repaymentAmt, remainingBlinding, auditKey)
+	if err != nil {
+		return utxi.Transaction{}, utxi.Opening{}, err
+	}
+
+	debtorAddress := debtTx.Outputs[0].RecipientAddr()
+	repaymentTx := utxi.Transaction{
+		Inputs: []utxi.TxInput{{Txid: loanId, Vout: 0}},
+		Outputs: []utxi.TxOutput{
+			utxi.ConstructConfidentialOutput(repaymentAddress, payment),
+			utxi.ConstructConfidentialOutput(debtorAddress, remaining),
+		},
+	}
+	w.SignTransaction(which, &repaymentTx)
+	return repaymentTx, remainingOpening, nil
+}
+
+// ConstructLoanPool pools loans issued by this wallet. The pool is signed with
+// the key recorded as lender in the wallet's debt inputs.
+func (w *Wallet) ConstructLoanPool(loans [][]byte, tranches []utxi.Tranche) utxi.LoanPool {
+	originator, _ := w.PublicKey(1)
+	pool := utxi.NewLoanPool(originator, loans, tranches)
+	pool.ScriptSig = w.Sign(1, pool.SigningBytes())
+	return pool
+}
+
+func (w *Wallet) ConstructTrancheTransfer(which uint32, poolId []byte, tranche int, to []byte, amount uint64) utxi.TrancheTransfer {
+	transfer := utxi.TrancheTransfer{
+		Pool: poolId,
+		Tranche: tranche,
+		To: to,
+		Amount: amount,
+		Nonce: newNonce(),
+	}
+	transfer.ScriptSig = w.Sign(which, transfer.SigningBytes())
+	return transfer
+}
+
+// SignNetting adds this wallet's lender signature to a netting transaction.
+func (w *Wallet) SignNetting(ntx *utxi.NettingTransaction) {
+	ntx.ScriptSigs = append(ntx.ScriptSigs, w.Sign(1, ntx.SigningBytes()))
+}
+
+// ConstructDebtReduction forgives amount of a loan issued by this wallet.
+func (w *Wallet) ConstructDebtReduction(loanId []byte, amount uint64, reason utxi.ReasonCode) utxi.DebtReduction {
+	return w.signDebtReduction(utxi.DebtReduction{
+		Command: utxi.ForgiveDebtCommand,
+		Loan: loanId,
+		Amount: amount,
+		Reason: reason,
+	})
+}
+
+// ConstructWriteOff writes off the remaining balance of a loan issued by this wallet.
+func (w *Wallet) ConstructWriteOff(loanId []byte, reason utxi.ReasonCode) utxi.DebtReduction {
+	return w.signDebtReduction(utxi.DebtReduction{
+		Command: utxi.WriteOffCommand,
+		Loan: loanId,
+		Reason: reason,
+	})
+}
+
+// signDebtReduction signs a reduction under a fresh nonce, so reductions
+// with the same terms are distinct.
+func (w *Wallet) signDebtReduction(dr utxi.DebtReduction) utxi.DebtReduction {
+	dr.Nonce = newNonce()
+	dr.ScriptSig = w.Sign(1, dr.SigningBytes())
+	return dr
+}
+
+// newNonce tells apart records the node applies only once but that a
+// signer may want to send twice with the same contents.
+func newNonce() uint64 {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	return binary.BigEndian.Uint64(nonce)
+}
+
+// SignRefinance adds this wallet's signature; lender and borrower both sign.
+func (w *Wallet) SignRefinance(rf *utxi.Refinance) {
+	rf.ScriptSigs = append(rf.ScriptSigs, w.Sign(1, rf.SigningBytes()))
+}
+
+// SignGovernance adds this wallet's governor signature to a governance transaction.
+func (w *Wallet) SignGovernance(gov *utxi.Governance) {
+	gov.ScriptSigs = append(gov.ScriptSigs, w.Sign(1, gov.SigningBytes()))
+}
+
+// ConstructAttestation vouches for subject until expires (unix seconds),
+// signed with the key registered as attester.
+func (w *Wallet) ConstructAttestation(kind utxi.AttestationKind, subject []byte, expires int64) utxi.Attestation {
+	at := utxi.Attestation{
+		Kind: kind,
+		Subject: subject,
+		Expires: expires,
+	}
+	at.ScriptSig = w.Sign(1, at.SigningBytes())
+	return at
+}
+
+// ConstructAppraisal values collateral as of time (unix seconds), signed with
+// the key registered as oracle.
+func (w *Wallet) ConstructAppraisal(collateral string, value uint64, time int64) utxi.Appraisal {
+	ap := utxi.Appraisal{
+		Collateral: collateral,
+		Value: value,
+		Time: time,
+	}
+	ap.ScriptSig = w.Sign(1, ap.SigningBytes())
+	return ap
+}
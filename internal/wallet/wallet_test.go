@@ -0,0 +1,55 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"debtchain/pkg/utxi"
+)
+
+func newTestWallet(t *testing.T) *Wallet {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	seed := make([]byte, 32)
+	rand.Read(seed)
+	seedPath := filepath.Join(dir, "seed.dat")
+	err = ioutil.WriteFile(seedPath, seed, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWallet(seedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestViewKeyOpensOutputMemos(t *testing.T) {
+	borrower := newTestWallet(t)
+	sealed, err := utxi.SealOutputMemo(borrower.ViewPublicKey(), utxi.OutputMemo{Value: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memo, err := utxi.OpenOutputMemo(borrower.ViewKey(), sealed)
+	if err != nil || memo.Value != 50 {
+		t.Fatalf("opened %+v, %v", memo, err)
+	}
+	if _, err := utxi.OpenOutputMemo(newTestWallet(t).ViewKey(), sealed); err == nil {
+		t.Error("memo opened with another wallet's view key")
+	}
+	// the view key is not one of the spending keys
+	if _, err := utxi.OpenOutputMemo(borrower.btcecKey(1), sealed); err == nil {
+		t.Error("memo opened with a spending key")
+	}
+	spendKey, _ := borrower.PublicKey(1)
+	if string(borrower.ViewPublicKey().SerializeCompressed()) == string(spendKey) {
+		t.Error("view key is a spending key")
+	}
+}
@@ -0,0 +1,275 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/tendermint/tendermint/crypto/merkle"
+)
+
+/*
+	Sparse Merkle tree. A key is stored at the path given by the bits of
+	its sha256 hash, most significant first, and the tree is compact: a
+	subtree holding a single key is the leaf itself, placed where its path
+	leaves the others', so a tree of n keys is about log2(n) deep. The root
+	only depends on the keys and values held, not on the order they were
+	written in.
+
+	Trees are immutable. Set and Delete copy the path they change and share
+	the rest, so an update costs O(log n) and the tree it was applied to
+	stays valid.
+*/
+
+const HashSize = sha256.Size
+
+// ProofOpType is the Tendermint proof operator type of a key's proof.
+const ProofOpType = "smt:v"
+
+// an empty subtree hashes to zeros
+var emptyHash = make([]byte, HashSize)
+
+type node struct {
+	hash			[]byte
+	// set on leaves only
+	keyHash			[]byte
+	left, right		*node
+}
+
+type Tree struct {
+	root			*node
+}
+
+func hashKey(key []byte) []byte {
+	h := sha256.Sum256(key)
+	return h[:]
+}
+
+func leafHash(keyHash, value []byte) []byte {
+	valueHash := sha256.Sum256(value)
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(keyHash)
+	h.Write(valueHash[:])
+	return h.Sum(nil)
+}
+
+func innerHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// bit returns the bit of the path at depth, 0 being the root's.
+func bit(keyHash []byte, depth int) byte {
+	return keyHash[depth / 8] >> (7 - uint(depth % 8)) & 1
+}
+
+func (n *node) isLeaf() bool {
+	return n.keyHash != nil
+}
+
+func hashOf(n *node) []byte {
+	if n == nil {
+		return emptyHash
+	}
+	return n.hash
+}
+
+func newInner(left, right *node) *node {
+	return &node{hash: innerHash(hashOf(left), hashOf(right)), left: left, right: right}
+}
+
+// child returns the child of an inner node on the path at depth.
+func (n *node) child(keyHash []byte, depth int) *node {
+	if bit(keyHash, depth) == 0 {
+		return n.left
+	}
+	return n.right
+}
+
+// withChild copies an inner node with the child on the path at depth replaced.
+func (n *node) withChild(keyHash []byte, depth int, child *node) *node {
+	if bit(keyHash, depth) == 0 {
+		return newInner(child, n.right)
+	}
+	return newInner(n.left, child)
+}
+
+func New() *Tree {
+	return &Tree{}
+}
+
+// Root returns the root hash; the empty tree's is all zeros.
+func (tree *Tree) Root() []byte {
+	return append([]byte{}, hashOf(tree.root)...)
+}
+
+// Set returns the tree with key holding value.
+func (tree *Tree) Set(key, value []byte) *Tree {
+	keyHash := hashKey(key)
+	leaf := &node{hash: leafHash(keyHash, value), keyHash: keyHash}
+	return &Tree{insert(tree.root, 0, leaf)}
+}
+
+func insert(n *node, depth int, leaf *node) *node {
+	if n == nil {
+		return leaf
+	}
+	if n.isLeaf() {
+		if bytes.Equal(n.keyHash, leaf.keyHash) {
+			return leaf
+		}
+		// split where the two paths part
+		if bit(n.keyHash, depth) == bit(leaf.keyHash, depth) {
+			return onPath(leaf.keyHash, depth, insert(n, depth + 1, leaf))
+		}
+		if bit(leaf.keyHash, depth) == 0 {
+			return newInner(leaf, n)
+		}
+		return newInner(n, leaf)
+	}
+	return n.withChild(leaf.keyHash, depth, insert(n.child(leaf.keyHash, depth), depth + 1, leaf))
+}
+
+// onPath makes an inner node whose only child is on the path at depth.
+func onPath(keyHash []byte, depth int, child *node) *node {
+	if bit(keyHash, depth) == 0 {
+		return newInner(child, nil)
+	}
+	return newInner(nil, child)
+}
+
+// Delete returns the tree without key.
+func (tree *Tree) Delete(key []byte) *Tree {
+	return &Tree{remove(tree.root, 0, hashKey(key))}
+}
+
+func remove(n *node, depth int, keyHash []byte) *node {
+	if n == nil {
+		return nil
+	}
+	if n.isLeaf() {
+		if bytes.Equal(n.keyHash, keyHash) {
+			return nil
+		}
+		return n
+	}
+	child := n.child(keyHash, depth)
+	removed := remove(child, depth + 1, keyHash)
+	if removed == child {
+		return n
+	}
+	updated := n.withChild(keyHash, depth, removed)
+	// a subtree left with a single leaf is the leaf
+	switch {
+	case updated.left == nil && updated.right == nil:
+		return nil
+	case updated.left == nil && updated.right.isLeaf():
+		return updated.right
+	case updated.right == nil && updated.left.isLeaf():
+		return updated.left
+	}
+	return updated
+}
+
+// Proof lists the siblings on the path of a key, from the root down.
+type Proof struct {
+	Key				[]byte
+	Siblings		[][]byte
+}
+
+// Prove returns the proof of a key the tree holds.
+func (tree *Tree) Prove(key []byte) (Proof, bool) {
+	keyHash := hashKey(key)
+	proof := Proof{Key: key}
+	n := tree.root
+	for depth := 0; n != nil && !n.isLeaf(); depth++ {
+		if bit(keyHash, depth) == 0 {
+			proof.Siblings = append(proof.Siblings, hashOf(n.right))
+		} else {
+			proof.Siblings = append(proof.Siblings, hashOf(n.left))
+		}
+		n = n.child(keyHash, depth)
+	}
+	if n == nil || !bytes.Equal(n.keyHash, keyHash) {
+		return Proof{}, false
+	}
+	return proof, true
+}
+
+// ComputeRoot returns the root the proof leads to with the key holding value.
+func (proof Proof) ComputeRoot(value []byte) ([]byte, error) {
+	if len(proof.Siblings) > HashSize * 8 {
+		return nil, errors.New("proof is deeper than the key hash")
+	}
+	keyHash := hashKey(proof.Key)
+	h := leafHash(keyHash, value)
+	for depth := len(proof.Siblings) - 1; depth >= 0; depth-- {
+		sibling := proof.Siblings[depth]
+		if len(sibling) != HashSize {
+			return nil, fmt.Errorf("sibling at depth %v is not a hash", depth)
+		}
+		if bit(keyHash, depth) == 0 {
+			h = innerHash(h, sibling)
+		} else {
+			h = innerHash(sibling, h)
+		}
+	}
+	return h, nil
+}
+
+func (proof Proof) Verify(root, value []byte) bool {
+	computed, err := proof.ComputeRoot(value)
+	return err == nil && bytes.Equal(computed, root)
+}
+
+/*
+	Tendermint proof operator. The operator takes the key's value and
+	returns the root, so it chains with the simple:v operators of
+	crypto/merkle in a ProofRuntime. Its data is the siblings concatenated.
+*/
+
+func (proof Proof) Run(args [][]byte) ([][]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 arg, got %v", len(args))
+	}
+	root, err := proof.ComputeRoot(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{root}, nil
+}
+
+func (proof Proof) GetKey() []byte {
+	return proof.Key
+}
+
+func (proof Proof) ProofOp() merkle.ProofOp {
+	return merkle.ProofOp{Type: ProofOpType, Key: proof.Key, Data: bytes.Join(proof.Siblings, nil)}
+}
+
+// ProofOpDecoder decodes the operator of a ProofOpType proof op.
+func ProofOpDecoder(op merkle.ProofOp) (merkle.ProofOperator, error) {
+	if op.Type != ProofOpType {
+		return nil, fmt.Errorf("unexpected proof op type %v, want %v", op.Type, ProofOpType)
+	}
+	if len(op.Data) % HashSize != 0 || len(op.Data) > HashSize * HashSize * 8 {
+		return nil, errors.New("proof op data is not a list of siblings")
+	}
+	proof := Proof{Key: op.Key}
+	for i := 0; i < len(op.Data); i += HashSize {
+		proof.Siblings = append(proof.Siblings, op.Data[i:i + HashSize])
+	}
+	return proof, nil
+}
+
+// ProofRuntime verifies chains of simple:v and smt:v operators.
+func ProofRuntime() *merkle.ProofRuntime {
+	prt := merkle.DefaultProofRuntime()
+	prt.RegisterOpDecoder(ProofOpType, ProofOpDecoder)
+	return prt
+}
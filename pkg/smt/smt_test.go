@@ -0,0 +1,99 @@
+package smt
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func testTree(n int) *Tree {
+	tree := New()
+	for i := 0; i < n; i++ {
+		tree = tree.Set([]byte(fmt.Sprintf("key-%v", i)), []byte(fmt.Sprintf("value-%v", i)))
+	}
+	return tree
+}
+
+func TestRootIndependentOfOrder(t *testing.T) {
+	forward := testTree(50)
+	backward := New()
+	for i := 49; i >= 0; i-- {
+		backward = backward.Set([]byte(fmt.Sprintf("key-%v", i)), []byte(fmt.Sprintf("value-%v", i)))
+	}
+	if !bytes.Equal(forward.Root(), backward.Root()) {
+		t.Error("insertion order changes the root")
+	}
+
+	// deleting keys gives the root of the tree that never held them
+	deleted := forward
+	for i := 10; i < 50; i++ {
+		deleted = deleted.Delete([]byte(fmt.Sprintf("key-%v", i)))
+	}
+	if !bytes.Equal(deleted.Root(), testTree(10).Root()) {
+		t.Error("deleted keys change the root")
+	}
+	for i := 0; i < 10; i++ {
+		deleted = deleted.Delete([]byte(fmt.Sprintf("key-%v", i)))
+	}
+	if !bytes.Equal(deleted.Root(), New().Root()) {
+		t.Error("tree with every key deleted does not have the empty root")
+	}
+	if bytes.Equal(forward.Root(), New().Root()) {
+		t.Error("delete changed the tree it was applied to")
+	}
+
+	overwritten := forward.Set([]byte("key-3"), []byte("changed"))
+	if bytes.Equal(overwritten.Root(), forward.Root()) {
+		t.Error("new value does not change the root")
+	}
+	if !bytes.Equal(overwritten.Set([]byte("key-3"), []byte("value-3")).Root(), forward.Root()) {
+		t.Error("restored value does not restore the root")
+	}
+	if !bytes.Equal(forward.Delete([]byte("absent")).Root(), forward.Root()) {
+		t.Error("deleting an absent key changes the root")
+	}
+}
+
+func TestProofsVerify(t *testing.T) {
+	for _, n := range []int{1, 2, 7, 100} {
+		tree := testTree(n)
+		root := tree.Root()
+		for i := 0; i < n; i++ {
+			key, value := []byte(fmt.Sprintf("key-%v", i)), []byte(fmt.Sprintf("value-%v", i))
+			proof, ok := tree.Prove(key)
+			if !ok || !proof.Verify(root, value) {
+				t.Fatalf("%v keys: proof of %s does not verify", n, key)
+			}
+			if proof.Verify(root, []byte("other")) {
+				t.Errorf("%v keys: proof of %s verifies another value", n, key)
+			}
+			// the proof op round trips through its encoding
+			op, err := ProofOpDecoder(proof.ProofOp())
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := op.Run([][]byte{value})
+			if err != nil || !bytes.Equal(out[0], root) {
+				t.Errorf("%v keys: decoded proof of %s gives %x, %v", n, key, out, err)
+			}
+		}
+		if _, ok := tree.Prove([]byte("absent")); ok {
+			t.Errorf("%v keys: absent key proven", n)
+		}
+	}
+
+	tree := testTree(20)
+	proof, _ := tree.Prove([]byte("key-5"))
+	moved := proof
+	moved.Key = []byte("key-6")
+	if moved.Verify(tree.Root(), []byte("value-5")) {
+		t.Error("proof verifies for another key")
+	}
+	if len(proof.Siblings) > 0 {
+		short := proof
+		short.Siblings = proof.Siblings[1:]
+		if short.Verify(tree.Root(), []byte("value-5")) {
+			t.Error("shortened proof verifies")
+		}
+	}
+}
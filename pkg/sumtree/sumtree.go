@@ -0,0 +1,146 @@
+package sumtree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+/*
+	Merkle sum tree. Every node commits to the hash and the sum of its
+	children, so the root commits to the total of all leaves and an
+	inclusion proof shows that a leaf's amount is counted in that total
+	without revealing the other leaves.
+
+	Leaves are paired left to right; an unpaired node at the end of a level
+	is promoted to the next level unchanged.
+*/
+
+type Node struct {
+	Hash			[]byte
+	Sum				uint64
+}
+
+type Leaf struct {
+	// identifies the leaf to its owner, e.g. loan id followed by borrower address
+	Key				[]byte
+	Amount			uint64
+}
+
+// one step from a node towards the root
+type ProofStep struct {
+	Sibling			Node
+	// true if the sibling is the left child
+	Left			bool
+}
+
+type Proof struct {
+	Leaf			Leaf
+	Steps			[]ProofStep
+}
+
+type Tree struct {
+	levels			[][]Node
+}
+
+func sumBytes(sum uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, sum)
+	return b
+}
+
+func LeafNode(leaf Leaf) Node {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(leaf.Key)
+	h.Write(sumBytes(leaf.Amount))
+	return Node{h.Sum(nil), leaf.Amount}
+}
+
+func parent(left, right Node) (Node, error) {
+	sum := left.Sum + right.Sum
+	if sum < left.Sum {
+		return Node{}, errors.New("sum overflows")
+	}
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left.Hash)
+	h.Write(sumBytes(left.Sum))
+	h.Write(right.Hash)
+	h.Write(sumBytes(right.Sum))
+	return Node{h.Sum(nil), sum}, nil
+}
+
+// EmptyRoot is the root of a tree without leaves.
+func EmptyRoot() Node {
+	h := sha256.Sum256(nil)
+	return Node{h[:], 0}
+}
+
+func New(leaves []Leaf) (*Tree, error) {
+	level := make([]Node, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = LeafNode(leaf)
+	}
+	tree := &Tree{levels: [][]Node{level}}
+
+	for len(level) > 1 {
+		next := make([]Node, 0, (len(level) + 1) / 2)
+		for i := 0; i < len(level); i += 2 {
+			if i + 1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			node, err := parent(level[i], level[i+1])
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, node)
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	return tree, nil
+}
+
+func (tree *Tree) Root() Node {
+	top := tree.levels[len(tree.levels) - 1]
+	if len(top) == 0 {
+		return EmptyRoot()
+	}
+	return top[0]
+}
+
+// Prove builds the inclusion proof for the index-th leaf.
+func (tree *Tree) Prove(index int, leaf Leaf) (Proof, error) {
+	if index < 0 || index >= len(tree.levels[0]) {
+		return Proof{}, errors.New("leaf index out of range")
+	}
+	proof := Proof{Leaf: leaf}
+	for _, level := range tree.levels[:len(tree.levels) - 1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Steps = append(proof.Steps, ProofStep{level[sibling], sibling < index})
+		}
+		index = index / 2
+	}
+	return proof, nil
+}
+
+// Verify checks that the proof leads from the leaf to root.
+func (proof *Proof) Verify(root Node) bool {
+	node := LeafNode(proof.Leaf)
+	for _, step := range proof.Steps {
+		var err error
+		if step.Left {
+			node, err = parent(step.Sibling, node)
+		} else {
+			node, err = parent(node, step.Sibling)
+		}
+		if err != nil {
+			return false
+		}
+	}
+	return bytes.Equal(node.Hash, root.Hash) && node.Sum == root.Sum
+}
@@ -0,0 +1,99 @@
+package sumtree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testLeaves(n int) []Leaf {
+	leaves := make([]Leaf, n)
+	for i := range leaves {
+		leaves[i] = Leaf{Key: []byte(fmt.Sprintf("loan-%v", i)), Amount: uint64(10 * (i + 1))}
+	}
+	return leaves
+}
+
+func TestRootSum(t *testing.T) {
+	for n := 0; n <= 9; n++ {
+		tree, err := New(testLeaves(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 10 + 20 + ... + 10n
+		want := uint64(5 * n * (n + 1))
+		if tree.Root().Sum != want {
+			t.Errorf("%v leaves: root sum %v, want %v", n, tree.Root().Sum, want)
+		}
+	}
+	empty, _ := New(nil)
+	if string(empty.Root().Hash) != string(EmptyRoot().Hash) {
+		t.Error("tree without leaves does not have the empty root")
+	}
+}
+
+func TestProofsVerify(t *testing.T) {
+	// odd counts promote an unpaired node
+	for _, n := range []int{1, 2, 5, 8} {
+		leaves := testLeaves(n)
+		tree, err := New(leaves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, leaf := range leaves {
+			proof, err := tree.Prove(i, leaf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proof.Verify(tree.Root()) {
+				t.Errorf("%v leaves: proof of leaf %v does not verify", n, i)
+			}
+		}
+	}
+}
+
+func TestProofRejectsTampering(t *testing.T) {
+	leaves := testLeaves(5)
+	tree, err := New(leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.Root()
+
+	tampered := []struct {
+		name	string
+		tamper	func(proof *Proof)
+	}{
+		{"leaf amount", func(proof *Proof) { proof.Leaf.Amount = proof.Leaf.Amount - 1 }},
+		{"leaf key", func(proof *Proof) { proof.Leaf.Key = []byte("loan-9") }},
+		{"sibling sum", func(proof *Proof) { proof.Steps[0].Sibling.Sum = proof.Steps[0].Sibling.Sum + 1 }},
+		{"sibling side", func(proof *Proof) { proof.Steps[1].Left = !proof.Steps[1].Left }},
+		{"missing step", func(proof *Proof) { proof.Steps = proof.Steps[:len(proof.Steps) - 1] }},
+	}
+	for _, tc := range tampered {
+		proof, err := tree.Prove(2, leaves[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.tamper(&proof)
+		if proof.Verify(root) {
+			t.Errorf("%v: tampered proof verifies", tc.name)
+		}
+	}
+
+	proof, _ := tree.Prove(2, leaves[2])
+	lower := root
+	lower.Sum = root.Sum - 1
+	if proof.Verify(lower) {
+		t.Error("proof verifies against a root with another sum")
+	}
+	if _, err := tree.Prove(5, leaves[0]); err == nil {
+		t.Error("proved a leaf out of range")
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	leaves := []Leaf{{Key: []byte("a"), Amount: ^uint64(0)}, {Key: []byte("b"), Amount: 1}}
+	if _, err := New(leaves); err == nil {
+		t.Error("overflowing sum accepted")
+	}
+}
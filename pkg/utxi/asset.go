@@ -0,0 +1,45 @@
+package utxi
+
+/*
+	Outputs are denominated in an asset. Outputs without an asset id are in
+	the chain's native unit; other assets are registered with their metadata
+	before outputs may use them. Amounts are never converted between assets:
+	a loan is issued, repaid, netted and pooled in a single asset.
+*/
+type AssetId string
+
+const NativeAsset AssetId = ""
+
+type Asset struct {
+	Id				AssetId
+	Name			string
+	// amounts are in units of 10^-Decimals
+	Decimals		uint8
+	// public key of the issuer of the currency or token
+	Issuer			[]byte		`json:",omitempty"`
+}
+
+func ConstructAssetOutput(address []byte, asset AssetId, value uint64) TxOutput {
+	output := ConstructOutput(address, value)
+	output.Asset = asset
+	return output
+}
+
+// Asset returns the asset of the transaction's outputs, or false if they mix assets.
+func (tx *Transaction) Asset() (AssetId, bool) {
+	if len(tx.Outputs) == 0 {
+		return NativeAsset, true
+	}
+	asset := tx.Outputs[0].Asset
+	for _, output := range tx.Outputs[1:] {
+		if output.Asset != asset {
+			return asset, false
+		}
+	}
+	return asset, true
+}
+
+func (tx *Transaction) IsSingleAsset() bool {
+	_, single := tx.Asset()
+	return single
+}
@@ -0,0 +1,20 @@
+package utxi
+
+import (
+	"testing"
+)
+
+func TestSingleAsset(t *testing.T) {
+	usd := ConstructAssetOutput([]byte("a"), "usd", 10)
+	native := ConstructOutput([]byte("b"), 10)
+
+	if asset, single := (&Transaction{Outputs: []TxOutput{usd, usd}}).Asset(); asset != "usd" || !single {
+		t.Errorf("usd outputs are in %q, single %v", asset, single)
+	}
+	if (&Transaction{Outputs: []TxOutput{usd, native}}).IsSingleAsset() {
+		t.Error("mixed outputs are in a single asset")
+	}
+	if asset, single := (&Transaction{}).Asset(); asset != NativeAsset || !single {
+		t.Errorf("transaction without outputs is in %q", asset)
+	}
+}
@@ -0,0 +1,63 @@
+package utxi
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+)
+
+// what an attestation vouches for
+type AttestationKind string
+
+const (
+	// the borrower's identity was verified by a KYC provider
+	AttestKYC		AttestationKind = "kyc"
+	// the borrower completed counseling with an approved counselor, as HECM
+	// reverse mortgages require
+	AttestCounseling	AttestationKind = "counseling"
+)
+
+/*
+	An Attestation is signed by a registered attester for the given kind and
+	vouches for an address until it expires. A newer attestation of the same
+	kind for the same address replaces the older one.
+*/
+type Attestation struct {
+	Kind			AttestationKind
+	Subject			[]byte
+	// unix seconds, compared with the block time
+	Expires			int64
+	ScriptSig		UnLockingScript
+}
+
+func (at *Attestation) SigningBytes() []byte {
+	unsigned := *at
+	unsigned.ScriptSig = UnLockingScript{}
+	attestationAsJson, _ := json.Marshal(unsigned)
+	return attestationAsJson
+}
+
+func (at *Attestation) Serialize() []byte {
+	attestationAsJson, _ := json.Marshal(at)
+	return attestationAsJson
+}
+
+func (at *Attestation) Hash() []byte {
+	h := sha256.New()
+	h.Write(at.Serialize())
+	return h.Sum(nil)
+}
+
+func (at *Attestation) Attester() []byte {
+	return at.ScriptSig.PublicKey
+}
+
+func (at *Attestation) IsAttestationValid() bool {
+	if at.Kind == "" || len(at.Subject) == 0 || at.Expires <= 0 {
+		return false
+	}
+	return at.ScriptSig.Verify(at.SigningBytes())
+}
+
+func (at *Attestation) IsCurrent(now int64) bool {
+	return now < at.Expires
+}
@@ -0,0 +1,398 @@
+package utxi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+/*
+	Confidential amounts. A confidential output replaces its plaintext Value
+	by a Pedersen commitment C = v*H + r*G on secp256k1 together with a range
+	proof that v fits in 64 bits. Commitments are additive, so the node can
+	check that the commitments of a transaction balance without learning the
+	amounts.
+
+	Each output also carries its opening (v, r) encrypted to the system audit
+	key. The holder of that key can open the sum of all commitments and
+	publish the total with its blinding factor, which anyone can check
+	against the commitments on chain while individual amounts stay hidden.
+*/
+
+const rangeProofBits = 64
+
+var curve = btcec.S256()
+
+// H is the second generator; its discrete log with respect to G is unknown
+// because it is found by hashing a fixed string onto the curve.
+var hX, hY = generatorH()
+
+func generatorH() (*big.Int, *big.Int) {
+	for counter := uint32(0); ; counter++ {
+		counterBytes := make([]byte, 4)
+		binary.BigEndian.PutUint32(counterBytes, counter)
+		h := sha256.New()
+		h.Write([]byte("debtchain pedersen generator H"))
+		h.Write(counterBytes)
+		candidate := append([]byte{0x02}, h.Sum(nil)...)
+		point, err := btcec.ParsePubKey(candidate, curve)
+		if err == nil {
+			return point.X, point.Y
+		}
+	}
+}
+
+type Opening struct {
+	Value			uint64
+	Blinding		[]byte
+}
+
+/*
+	Proof that Commitment opens to a value below 2^64. Bits[i] commits to
+	0 or 2^i; each bit carries a Schnorr OR proof (E0, E1, S0, S1) that it is
+	one of the two, and the bit commitments add up to the output commitment.
+*/
+type RangeProof struct {
+	Bits			[][]byte
+	E0				[][]byte
+	E1				[][]byte
+	S0				[][]byte
+	S1				[][]byte
+}
+
+type ConfidentialValue struct {
+	Commitment		[]byte
+	RangeProof		RangeProof
+	// json encoded Opening, encrypted to the audit key
+	AuditOpening	[]byte
+}
+
+func scalarBytes(k *big.Int) []byte {
+	return new(big.Int).Mod(k, curve.N).Bytes()
+}
+
+func randomScalar() (*big.Int, error) {
+	for {
+		k, err := rand.Int(rand.Reader, curve.N)
+		if err != nil {
+			return nil, err
+		}
+		if k.Sign() != 0 {
+			return k, nil
+		}
+	}
+}
+
+func mulH(v *big.Int) (*big.Int, *big.Int) {
+	return curve.ScalarMult(hX, hY, scalarBytes(v))
+}
+
+func mulG(r *big.Int) (*big.Int, *big.Int) {
+	return curve.ScalarBaseMult(scalarBytes(r))
+}
+
+func negatePoint(x, y *big.Int) (*big.Int, *big.Int) {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return x, y
+	}
+	return x, new(big.Int).Sub(curve.P, y)
+}
+
+// subtract returns P1 - P2.
+func subtract(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	nx, ny := negatePoint(x2, y2)
+	return curve.Add(x1, y1, nx, ny)
+}
+
+func pedersen(v, r *big.Int) (*big.Int, *big.Int) {
+	vx, vy := mulH(v)
+	rx, ry := mulG(r)
+	return curve.Add(vx, vy, rx, ry)
+}
+
+func serializePoint(x, y *big.Int) []byte {
+	return (&btcec.PublicKey{Curve: curve, X: x, Y: y}).SerializeCompressed()
+}
+
+func parsePoint(b []byte) (*big.Int, *big.Int, error) {
+	point, err := btcec.ParsePubKey(b, curve)
+	if err != nil {
+		return nil, nil, err
+	}
+	return point.X, point.Y, nil
+}
+
+// Commit returns the compressed commitment to value with blinding factor r.
+func Commit(value uint64, blinding *big.Int) []byte {
+	return serializePoint(pedersen(new(big.Int).SetUint64(value), blinding))
+}
+
+func bitChallenge(commitment []byte, bit int, bitCommitment []byte, r0x, r0y, r1x, r1y *big.Int) *big.Int {
+	h := sha256.New()
+	h.Write(commitment)
+	h.Write([]byte{byte(bit)})
+	h.Write(bitCommitment)
+	h.Write(serializePoint(r0x, r0y))
+	h.Write(serializePoint(r1x, r1y))
+	return new(big.Int).Mod(new(big.Int).SetBytes(h.Sum(nil)), curve.N)
+}
+
+func proveRange(commitment []byte, value uint64, blinding *big.Int) (RangeProof, error) {
+	var proof RangeProof
+	remaining := new(big.Int).Set(blinding)
+
+	for i := 0; i < rangeProofBits; i++ {
+		bit := (value >> uint(i)) & 1
+
+		// the bit blindings add up to the output blinding
+		var r *big.Int
+		if i == rangeProofBits - 1 {
+			r = new(big.Int).Mod(remaining, curve.N)
+		} else {
+			var err error
+			r, err = randomScalar()
+			if err != nil {
+				return proof, err
+			}
+			remaining.Sub(remaining, r)
+		}
+
+		power := new(big.Int).Lsh(big.NewInt(1), uint(i))
+		cx, cy := pedersen(new(big.Int).Mul(power, big.NewInt(int64(bit))), r)
+		bitCommitment := serializePoint(cx, cy)
+
+		// P0 = C, P1 = C - 2^i H; the prover knows the discrete log of P_bit
+		hx, hy := mulH(power)
+		p1x, p1y := subtract(cx, cy, hx, hy)
+		px := [2]*big.Int{cx, p1x}
+		py := [2]*big.Int{cy, p1y}
+
+		var e, s [2]*big.Int
+		var rx, ry [2]*big.Int
+		fake := 1 - bit
+
+		k, err := randomScalar()
+		if err != nil {
+			return proof, err
+		}
+		rx[bit], ry[bit] = mulG(k)
+
+		e[fake], err = randomScalar()
+		if err != nil {
+			return proof, err
+		}
+		s[fake], err = randomScalar()
+		if err != nil {
+			return proof, err
+		}
+		sx, sy := mulG(s[fake])
+		ex, ey := curve.ScalarMult(px[fake], py[fake], scalarBytes(e[fake]))
+		rx[fake], ry[fake] = subtract(sx, sy, ex, ey)
+
+		challenge := bitChallenge(commitment, i, bitCommitment, rx[0], ry[0], rx[1], ry[1])
+		e[bit] = new(big.Int).Mod(new(big.Int).Sub(challenge, e[fake]), curve.N)
+		s[bit] = new(big.Int).Mod(new(big.Int).Add(k, new(big.Int).Mul(e[bit], r)), curve.N)
+
+		proof.Bits = append(proof.Bits, bitCommitment)
+		proof.E0 = append(proof.E0, scalarBytes(e[0]))
+		proof.E1 = append(proof.E1, scalarBytes(e[1]))
+		proof.S0 = append(proof.S0, scalarBytes(s[0]))
+		proof.S1 = append(proof.S1, scalarBytes(s[1]))
+	}
+	return proof, nil
+}
+
+// VerifyRange checks the proof against the output commitment.
+func (proof *RangeProof) VerifyRange(commitment []byte) bool {
+	if len(proof.Bits) != rangeProofBits || len(proof.E0) != rangeProofBits || len(proof.E1) != rangeProofBits ||
+		len(proof.S0) != rangeProofBits || len(proof.S1) != rangeProofBits {
+		return false
+	}
+	sumX, sumY := new(big.Int), new(big.Int)
+
+	for i := 0; i < rangeProofBits; i++ {
+		cx, cy, err := parsePoint(proof.Bits[i])
+		if err != nil {
+			return false
+		}
+		power := new(big.Int).Lsh(big.NewInt(1), uint(i))
+		hx, hy := mulH(power)
+		p1x, p1y := subtract(cx, cy, hx, hy)
+
+		e0 := new(big.Int).SetBytes(proof.E0[i])
+		e1 := new(big.Int).SetBytes(proof.E1[i])
+		s0 := new(big.Int).SetBytes(proof.S0[i])
+		s1 := new(big.Int).SetBytes(proof.S1[i])
+
+		// R_j = s_j G - e_j P_j
+		s0x, s0y := mulG(s0)
+		e0x, e0y := curve.ScalarMult(cx, cy, scalarBytes(e0))
+		r0x, r0y := subtract(s0x, s0y, e0x, e0y)
+		s1x, s1y := mulG(s1)
+		e1x, e1y := curve.ScalarMult(p1x, p1y, scalarBytes(e1))
+		r1x, r1y := subtract(s1x, s1y, e1x, e1y)
+
+		challenge := bitChallenge(commitment, i, proof.Bits[i], r0x, r0y, r1x, r1y)
+		if new(big.Int).Mod(new(big.Int).Add(e0, e1), curve.N).Cmp(challenge) != 0 {
+			return false
+		}
+		sumX, sumY = curve.Add(sumX, sumY, cx, cy)
+	}
+
+	x, y, err := parsePoint(commitment)
+	if err != nil {
+		return false
+	}
+	return x.Cmp(sumX) == 0 && y.Cmp(sumY) == 0
+}
+
+/*
+	NewConfidentialValue commits to value with a fresh blinding factor and
+	returns the opening so the caller can hand it to the recipient.
+*/
+func NewConfidentialValue(value uint64, auditKey *btcec.PublicKey) (ConfidentialValue, Opening, error) {
+	blinding, err := randomScalar()
+	if err != nil {
+		return ConfidentialValue{}, Opening{}, err
+	}
+	return NewConfidentialValueWithBlinding(value, blinding, auditKey)
+}
+
+// NewConfidentialValueWithBlinding is used when the blinding factor has to
+// make a transaction balance.
+func NewConfidentialValueWithBlinding(value uint64, blinding *big.Int, auditKey *btcec.PublicKey) (ConfidentialValue, Opening, error) {
+	blinding = new(big.Int).Mod(blinding, curve.N)
+	if blinding.Sign() == 0 {
+		return ConfidentialValue{}, Opening{}, errors.New("blinding factor must not be zero")
+	}
+	commitment := Commit(value, blinding)
+	proof, err := proveRange(commitment, value, blinding)
+	if err != nil {
+		return ConfidentialValue{}, Opening{}, err
+	}
+	opening := Opening{Value: value, Blinding: scalarBytes(blinding)}
+	openingAsJson, _ := json.Marshal(opening)
+	auditOpening, err := btcec.Encrypt(auditKey, openingAsJson)
+	if err != nil {
+		return ConfidentialValue{}, Opening{}, err
+	}
+	return ConfidentialValue{commitment, proof, auditOpening}, opening, nil
+}
+
+func (cv *ConfidentialValue) IsValueValid() bool {
+	return cv.RangeProof.VerifyRange(cv.Commitment)
+}
+
+// an encrypted Opening: IV, ephemeral key (curve id, then X and Y with their
+// lengths), the padded JSON and the MAC
+const (
+	auditEphemeralKeySize	= 70
+	maxAuditOpeningSize		= aes.BlockSize + auditEphemeralKeySize + 128 + sha256.Size
+)
+
+/*
+	IsAuditOpeningWellFormed checks the shape of the audit opening: a
+	ciphertext to a point on the curve, padded to whole blocks and no larger
+	than an Opening needs. What it decrypts to can only be checked with the
+	audit key, which consensus never uses; Open reports a wrong opening when
+	the totals are queried.
+*/
+func (cv *ConfidentialValue) IsAuditOpeningWellFormed() bool {
+	in := cv.AuditOpening
+	if len(in) < aes.BlockSize + auditEphemeralKeySize + aes.BlockSize + sha256.Size || len(in) > maxAuditOpeningSize {
+		return false
+	}
+	if (len(in) - aes.BlockSize - auditEphemeralKeySize - sha256.Size) % aes.BlockSize != 0 {
+		return false
+	}
+	key := in[aes.BlockSize:aes.BlockSize + auditEphemeralKeySize]
+	coordLength := []byte{0x00, 0x20}
+	// secp256k1, as btcec.Encrypt writes it
+	if !bytes.Equal(key[0:2], []byte{0x02, 0xCA}) || !bytes.Equal(key[2:4], coordLength) || !bytes.Equal(key[36:38], coordLength) {
+		return false
+	}
+	point := append(append([]byte{0x04}, key[4:36]...), key[38:70]...)
+	_, err := btcec.ParsePubKey(point, curve)
+	return err == nil
+}
+
+// Open decrypts the audit opening and checks it against the commitment.
+func (cv *ConfidentialValue) Open(auditKey *btcec.PrivateKey) (Opening, error) {
+	var opening Opening
+	openingAsJson, err := btcec.Decrypt(auditKey, cv.AuditOpening)
+	if err != nil {
+		return opening, err
+	}
+	err = json.Unmarshal(openingAsJson, &opening)
+	if err != nil {
+		return opening, err
+	}
+	if string(Commit(opening.Value, opening.BlindingFactor())) != string(cv.Commitment) {
+		return opening, errors.New("audit opening does not match commitment")
+	}
+	return opening, nil
+}
+
+func (opening Opening) BlindingFactor() *big.Int {
+	return new(big.Int).SetBytes(opening.Blinding)
+}
+
+// CommitmentsBalance reports whether the inputs and outputs commit to the same total.
+func CommitmentsBalance(inputs, outputs [][]byte) bool {
+	inX, inY, err := sumCommitments(inputs)
+	if err != nil {
+		return false
+	}
+	outX, outY, err := sumCommitments(outputs)
+	if err != nil {
+		return false
+	}
+	return inX.Cmp(outX) == 0 && inY.Cmp(outY) == 0
+}
+
+func sumCommitments(commitments [][]byte) (*big.Int, *big.Int, error) {
+	sumX, sumY := new(big.Int), new(big.Int)
+	for _, commitment := range commitments {
+		x, y, err := parsePoint(commitment)
+		if err != nil {
+			return nil, nil, err
+		}
+		sumX, sumY = curve.Add(sumX, sumY, x, y)
+	}
+	return sumX, sumY, nil
+}
+
+/*
+	TotalProof opens the sum of a set of commitments. Plaintext amounts
+	take part as commitments with a zero blinding factor. Commitments the
+	prover could not open are listed in Unopened and left out of the sum.
+*/
+type TotalProof struct {
+	Total			uint64
+	Blinding		[]byte
+	Commitments		[][]byte
+	Unopened		[][]byte	`json:",omitempty"`
+}
+
+// Verify checks that the commitments add up to Total*H + Blinding*G.
+func (proof *TotalProof) Verify() bool {
+	sumX, sumY, err := sumCommitments(proof.Commitments)
+	if err != nil {
+		return false
+	}
+	x, y := pedersen(new(big.Int).SetUint64(proof.Total), new(big.Int).SetBytes(proof.Blinding))
+	return sumX.Cmp(x) == 0 && sumY.Cmp(y) == 0
+}
+
+// PlainCommitment is the commitment a plaintext amount contributes to a total;
+// zero amounts contribute nothing and must be left out.
+func PlainCommitment(value uint64) []byte {
+	return serializePoint(mulH(new(big.Int).SetUint64(value)))
+}
@@ -0,0 +1,143 @@
+package utxi
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestRangeProofVerifies(t *testing.T) {
+	audit := newKey(t)
+	for _, value := range []uint64{0, 1, 42, 1 << 63, ^uint64(0)} {
+		cv, opening, err := NewConfidentialValue(value, audit.PubKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cv.IsValueValid() {
+			t.Fatalf("range proof of %v does not verify", value)
+		}
+		if !cv.IsAuditOpeningWellFormed() {
+			t.Fatalf("audit opening of %v is not well formed", value)
+		}
+		opened, err := cv.Open(audit)
+		if err != nil || opened.Value != value || opened.BlindingFactor().Cmp(opening.BlindingFactor()) != 0 {
+			t.Fatalf("opened %v, %v; want %v", opened.Value, err, value)
+		}
+	}
+}
+
+func TestRangeProofRejectsTampering(t *testing.T) {
+	audit := newKey(t)
+	cv, _, err := NewConfidentialValue(1000, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := NewConfidentialValue(1000, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := []struct {
+		name	string
+		tamper	func(cv *ConfidentialValue)
+	}{
+		{"commitment of another value", func(cv *ConfidentialValue) { cv.Commitment = other.Commitment }},
+		{"bit commitment", func(cv *ConfidentialValue) { cv.RangeProof.Bits[3] = other.RangeProof.Bits[3] }},
+		{"challenge", func(cv *ConfidentialValue) { cv.RangeProof.E0[5] = other.RangeProof.E0[5] }},
+		{"response", func(cv *ConfidentialValue) { cv.RangeProof.S1[7] = other.RangeProof.S1[7] }},
+		{"missing bit", func(cv *ConfidentialValue) { cv.RangeProof.Bits = cv.RangeProof.Bits[1:] }},
+	}
+	for _, tc := range tampered {
+		copied := cv
+		copied.RangeProof = RangeProof{
+			Bits: append([][]byte{}, cv.RangeProof.Bits...),
+			E0: append([][]byte{}, cv.RangeProof.E0...),
+			E1: append([][]byte{}, cv.RangeProof.E1...),
+			S0: append([][]byte{}, cv.RangeProof.S0...),
+			S1: append([][]byte{}, cv.RangeProof.S1...),
+		}
+		tc.tamper(&copied)
+		if copied.IsValueValid() {
+			t.Errorf("%v: tampered range proof verifies", tc.name)
+		}
+	}
+}
+
+func TestAuditOpening(t *testing.T) {
+	audit := newKey(t)
+	cv, _, err := NewConfidentialValue(7, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cv.Open(newKey(t)); err == nil {
+		t.Error("opened with another key")
+	}
+
+	// an opening of another commitment decrypts but does not match
+	other, _, err := NewConfidentialValue(8, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped := cv
+	swapped.AuditOpening = other.AuditOpening
+	if _, err := swapped.Open(audit); err == nil {
+		t.Error("opening of another commitment accepted")
+	}
+
+	malformed := cv
+	malformed.AuditOpening = append([]byte{}, cv.AuditOpening...)
+	malformed.AuditOpening[16] = 0x00
+	if malformed.IsAuditOpeningWellFormed() {
+		t.Error("opening to another curve is well formed")
+	}
+	malformed.AuditOpening = make([]byte, maxAuditOpeningSize + 16)
+	if malformed.IsAuditOpeningWellFormed() {
+		t.Error("oversized opening is well formed")
+	}
+}
+
+func TestCommitmentsBalance(t *testing.T) {
+	audit := newKey(t)
+	debt, debtOpening, err := NewConfidentialValue(100, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, paymentOpening, err := NewConfidentialValue(30, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remainingBlinding := new(big.Int).Sub(debtOpening.BlindingFactor(), paymentOpening.BlindingFactor())
+	remaining, _, err := NewConfidentialValueWithBlinding(70, remainingBlinding, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CommitmentsBalance([][]byte{debt.Commitment}, [][]byte{payment.Commitment, remaining.Commitment}) {
+		t.Error("balanced commitments do not balance")
+	}
+	overpaid, _, err := NewConfidentialValueWithBlinding(71, remainingBlinding, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CommitmentsBalance([][]byte{debt.Commitment}, [][]byte{payment.Commitment, overpaid.Commitment}) {
+		t.Error("unbalanced commitments balance")
+	}
+}
+
+func TestTotalProof(t *testing.T) {
+	audit := newKey(t)
+	cv, opening, err := NewConfidentialValue(40, audit.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := TotalProof{
+		Total: 45,
+		Blinding: opening.Blinding,
+		Commitments: [][]byte{cv.Commitment, PlainCommitment(5)},
+	}
+	if !proof.Verify() {
+		t.Fatal("total proof does not verify")
+	}
+	proof.Total = 46
+	if proof.Verify() {
+		t.Error("total proof of a wrong total verifies")
+	}
+}
@@ -0,0 +1,82 @@
+package utxi
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+)
+
+// reason codes recorded with every forgiveness and write-off
+type ReasonCode uint32
+
+const (
+	ReasonUnspecified	ReasonCode = iota
+	ReasonHardship
+	ReasonSettlement
+	ReasonDisaster
+	ReasonBankruptcy
+	ReasonDeceased
+	ReasonUncollectable
+	numReasonCodes
+)
+
+func (code ReasonCode) IsValid() bool {
+	return code < numReasonCodes
+}
+
+// the commands a DebtReduction is signed for
+const (
+	ForgiveDebtCommand	= "ForgiveDebt"
+	WriteOffCommand		= "WriteOff"
+)
+
+/*
+	DebtReduction is signed by the lender to forgive part or all of a loan
+	(ForgiveDebt) or to write off its remaining balance as uncollectable
+	(WriteOff, which ignores Amount). The command is signed so a forgiveness
+	cannot be sent as a write-off, and the nonce lets the lender sign the
+	same reduction twice; the node applies each signed reduction once.
+*/
+type DebtReduction struct {
+	Command			string
+	Loan			[]byte
+	Amount			uint64
+	Reason			ReasonCode
+	Nonce			uint64
+	ScriptSig		UnLockingScript
+}
+
+func (dr *DebtReduction) SigningBytes() []byte {
+	unsigned := *dr
+	unsigned.ScriptSig = UnLockingScript{}
+	reductionAsJson, _ := json.Marshal(unsigned)
+	return reductionAsJson
+}
+
+func (dr *DebtReduction) Serialize() []byte {
+	reductionAsJson, _ := json.Marshal(dr)
+	return reductionAsJson
+}
+
+func (dr *DebtReduction) Hash() []byte {
+	h := sha256.New()
+	h.Write(dr.Serialize())
+	return h.Sum(nil)
+}
+
+// Id names the reduction by what was signed, so a new signature over the
+// same reduction does not make it a new one.
+func (dr *DebtReduction) Id() []byte {
+	h := sha256.New()
+	h.Write(dr.SigningBytes())
+	return h.Sum(nil)
+}
+
+func (dr *DebtReduction) IsReductionValid() bool {
+	if !dr.Reason.IsValid() {
+		return false
+	}
+	if dr.Command != ForgiveDebtCommand && dr.Command != WriteOffCommand {
+		return false
+	}
+	return dr.ScriptSig.Verify(dr.SigningBytes())
+}
@@ -1,10 +1,14 @@
 package utxi
 
 import (
+	"crypto/ecdsa"
+	"crypto/sha256"
 	"encoding/base64"
 	"math/big"
 	"strconv"
 	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
 )
 
 type EcdsaSignature struct {
@@ -16,6 +20,30 @@ type UnLockingScript struct {
 	Signature		EcdsaSignature
 }
 
+// HalfOrder is half the order of secp256k1; signatures with a larger S are
+// rejected.
+var HalfOrder = new(big.Int).Rsh(btcec.S256().N, 1)
+
+// Verify reports whether the script carries a valid signature over msg by the
+// compressed secp256k1 public key it names. msg is hashed before verifying.
+// Only the low-S form of a signature is valid: (r, N-s) verifies whenever
+// (r, s) does, so accepting both would let anyone change the hash of a
+// signed transaction and slip it past the duplicate checks.
+func (script UnLockingScript) Verify(msg []byte) bool {
+	if script.Signature.R == nil || script.Signature.S == nil {
+		return false
+	}
+	if script.Signature.S.Cmp(HalfOrder) > 0 {
+		return false
+	}
+	pubKey, err := btcec.ParsePubKey(script.PublicKey, btcec.S256())
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(msg)
+	return ecdsa.Verify(pubKey.ToECDSA(), digest[:], script.Signature.R, script.Signature.S)
+}
+
 // TxInputs can be one of three types: CoinbaseInput, DebtInput, or a normal TxInput
 type TxInput struct {
 	// transaction hash; pointer to the transaction containing the utxo
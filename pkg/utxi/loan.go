@@ -0,0 +1,91 @@
+package utxi
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+)
+
+/*
+	Terms agreed for a loan. Rates are in basis points per year.
+*/
+type LoanTerms struct {
+	RateBps			uint32
+	TermMonths		uint32
+	Schedule		[]Installment	`json:",omitempty"`
+	LateFee			LateFeeRule
+}
+
+/*
+	Servicing record kept by the node for every outstanding debt.
+	The debt pool and the loan store both key a loan by the hash of its
+	outstanding debt transaction at issuance; the key does not change when
+	the loan is repaid.
+*/
+type Loan struct {
+	Id				[]byte
+	// public key recorded in the debt input (Txid) when the debt was issued
+	Lender			[]byte
+	Borrower		[]byte
+	// zero when the debt is confidential
+	Principal		uint64
+	Asset			AssetId		`json:",omitempty"`
+	Terms			*LoanTerms	`json:",omitempty"`
+	Collateral		string		`json:",omitempty"`
+	Product			ProductType	`json:",omitempty"`
+	// id of the securitization pool holding the loan, if any
+	Pool			[]byte		`json:",omitempty"`
+	// ids of the loans this loan was created to replace
+	Replaces		[][]byte	`json:",omitempty"`
+	// hash of the transaction that closed the loan; closed loans leave the debt pool
+	Closed			[]byte		`json:",omitempty"`
+	// amounts removed from the debt without being repaid
+	Forgiven		uint64		`json:",omitempty"`
+	WrittenOff		uint64		`json:",omitempty"`
+	// hashes of the forgiveness and write-off records applied to the loan
+	Adjustments		[][]byte	`json:",omitempty"`
+	Repaid			uint64		`json:",omitempty"`
+	// late fees are added to the outstanding debt when they are charged
+	LateFees		uint64		`json:",omitempty"`
+	// number of scheduled installments already charged a late fee
+	FeesAssessed	int			`json:",omitempty"`
+	Delinquency		*Delinquency	`json:",omitempty"`
+}
+
+func NewLoan(debtTx Transaction) Loan {
+	odtx := MakeOutstandingDebtTx(debtTx)
+	return Loan{
+		Id: odtx.Hash(),
+		Lender: debtTx.Inputs[0].Txid,
+		Borrower: debtTx.Outputs[0].RecipientAddr(),
+		Principal: debtTx.DebtIssued(),
+		Asset: debtTx.Outputs[0].Asset,
+		Terms: debtTx.Terms,
+		Collateral: debtTx.Collateral,
+		Product: debtTx.Product,
+	}
+}
+
+// DerivedLoanId names the index-th loan created by a transaction that replaces
+// existing loans; outstanding debt transactions alone need not be unique.
+func DerivedLoanId(txHash []byte, index int) []byte {
+	indexBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(indexBytes, uint64(index))
+	h := sha256.New()
+	h.Write(txHash)
+	h.Write(indexBytes)
+	return h.Sum(nil)
+}
+
+func (loan *Loan) IsPooled() bool {
+	return len(loan.Pool) > 0
+}
+
+func (loan *Loan) IsClosed() bool {
+	return len(loan.Closed) > 0
+}
+
+func (loan *Loan) Serialize() []byte {
+	loanAsJson, _ := json.Marshal(loan)
+	return loanAsJson
+}
@@ -0,0 +1,81 @@
+package utxi
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+/*
+	Encrypted memos. Memos are sealed with ECIES on secp256k1 (ECDH,
+	AES-256-CBC and HMAC-SHA256), so only the holder of the matching private
+	key can read them.
+*/
+
+func SealMemo(recipient *btcec.PublicKey, plaintext []byte) ([]byte, error) {
+	return btcec.Encrypt(recipient, plaintext)
+}
+
+func OpenMemo(key *btcec.PrivateKey, sealed []byte) ([]byte, error) {
+	return btcec.Decrypt(key, sealed)
+}
+
+/*
+	Details of an output readable with the recipient's view key. The view
+	key only decrypts memos; it cannot sign for the output.
+*/
+type OutputMemo struct {
+	Value			uint64
+	// blinding factor of a confidential output
+	Blinding		[]byte		`json:",omitempty"`
+	Terms			*LoanTerms	`json:",omitempty"`
+}
+
+func SealOutputMemo(viewKey *btcec.PublicKey, memo OutputMemo) ([]byte, error) {
+	memoAsJson, _ := json.Marshal(memo)
+	return SealMemo(viewKey, memoAsJson)
+}
+
+func OpenOutputMemo(viewKey *btcec.PrivateKey, sealed []byte) (OutputMemo, error) {
+	var memo OutputMemo
+	memoAsJson, err := OpenMemo(viewKey, sealed)
+	if err != nil {
+		return memo, err
+	}
+	err = json.Unmarshal(memoAsJson, &memo)
+	return memo, err
+}
+
+// MaxMemoSize bounds a sealed transaction memo. ECIES adds 113 bytes of
+// overhead plus padding, leaving a little under 400 bytes of reference.
+const MaxMemoSize = 512
+
+// MaxOutputMemoSize bounds a sealed OutputMemo, leaving room for the terms
+// of a 30 year monthly schedule.
+const MaxOutputMemoSize = 16384
+
+func (tx *Transaction) IsMemoValid() bool {
+	return len(tx.Memo) <= MaxMemoSize
+}
+
+// AttachMemo seals a payment reference to the recipient of the first output,
+// whose address is its public key.
+func (tx *Transaction) AttachMemo(memo []byte) error {
+	if len(tx.Outputs) == 0 {
+		return errors.New("transaction has no recipient")
+	}
+	recipient, err := btcec.ParsePubKey(tx.Outputs[0].RecipientAddr(), btcec.S256())
+	if err != nil {
+		return err
+	}
+	sealed, err := SealMemo(recipient, memo)
+	if err != nil {
+		return err
+	}
+	if len(sealed) > MaxMemoSize {
+		return errors.New("memo too long")
+	}
+	tx.Memo = sealed
+	return nil
+}
@@ -0,0 +1,90 @@
+package utxi
+
+import (
+	"testing"
+)
+
+func TestOutputMemo(t *testing.T) {
+	viewKey := newKey(t)
+	memo := OutputMemo{
+		Value: 250,
+		Blinding: []byte{1, 2, 3},
+		Terms: &LoanTerms{RateBps: 450, TermMonths: 360},
+	}
+	sealed, err := SealOutputMemo(viewKey.PubKey(), memo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := OpenOutputMemo(viewKey, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Value != 250 || string(opened.Blinding) != string(memo.Blinding) || opened.Terms == nil || opened.Terms.TermMonths != 360 {
+		t.Fatalf("opened %+v, want %+v", opened, memo)
+	}
+
+	if _, err := OpenOutputMemo(newKey(t), sealed); err == nil {
+		t.Error("memo opened with another key")
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered) - 40] ^= 1
+	if _, err := OpenOutputMemo(viewKey, tampered); err == nil {
+		t.Error("tampered memo opened")
+	}
+}
+
+func TestOutputMemoSize(t *testing.T) {
+	output := ConstructOutput(newKey(t).PubKey().SerializeCompressed(), 10)
+	output.Memo = make([]byte, MaxOutputMemoSize)
+	if !output.IsOutputValid() {
+		t.Error("memo of the maximum size rejected")
+	}
+	output.Memo = make([]byte, MaxOutputMemoSize + 1)
+	if output.IsOutputValid() {
+		t.Error("oversized memo accepted")
+	}
+}
+
+// a 30 year monthly schedule fits in an output memo
+func TestOutputMemoScheduleFits(t *testing.T) {
+	terms := LoanTerms{RateBps: 450, TermMonths: 360}
+	for i := int64(0); i < 360; i++ {
+		terms.Schedule = append(terms.Schedule, Installment{DueTime: 1700000000 + i * 2629800, Amount: 250000})
+	}
+	sealed, err := SealOutputMemo(newKey(t).PubKey(), OutputMemo{Value: 90000000, Terms: &terms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) > MaxOutputMemoSize {
+		t.Errorf("sealed schedule is %v bytes, more than %v", len(sealed), MaxOutputMemoSize)
+	}
+}
+
+func TestTransactionMemo(t *testing.T) {
+	recipient := newKey(t)
+	tx := Transaction{Outputs: []TxOutput{ConstructOutput(recipient.PubKey().SerializeCompressed(), 25)}}
+	if err := tx.AttachMemo([]byte("invoice 7")); err != nil {
+		t.Fatal(err)
+	}
+	if !tx.IsMemoValid() {
+		t.Error("attached memo is not valid")
+	}
+	memo, err := OpenMemo(recipient, tx.Memo)
+	if err != nil || string(memo) != "invoice 7" {
+		t.Fatalf("opened %q, %v", memo, err)
+	}
+	if _, err := OpenMemo(newKey(t), tx.Memo); err == nil {
+		t.Error("memo opened with another key")
+	}
+
+	if err := tx.AttachMemo(make([]byte, MaxMemoSize)); err == nil {
+		t.Error("memo longer than the cap attached")
+	}
+	tx.Memo = make([]byte, MaxMemoSize + 1)
+	if tx.IsMemoValid() {
+		t.Error("oversized memo is valid")
+	}
+	if err := (&Transaction{}).AttachMemo([]byte("invoice 7")); err == nil {
+		t.Error("memo attached to a transaction without outputs")
+	}
+}
@@ -0,0 +1,161 @@
+package utxi
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+)
+
+var ErrNettingOverflow = errors.New("netting position out of range")
+
+/*
+	An amount Debtor owes Creditor, both identified by public key.
+*/
+type Obligation struct {
+	Debtor			[]byte
+	Creditor		[]byte
+	Amount			uint64
+	Asset			AssetId		`json:",omitempty"`
+}
+
+/*
+	NettingTransaction replaces the listed loans by the net obligations
+	between their participants. Every lender of a listed loan signs it.
+*/
+type NettingTransaction struct {
+	Loans			[][]byte
+	ScriptSigs		[]UnLockingScript
+}
+
+func (ntx *NettingTransaction) SigningBytes() []byte {
+	unsigned := *ntx
+	unsigned.ScriptSigs = nil
+	nettingAsJson, _ := json.Marshal(unsigned)
+	return nettingAsJson
+}
+
+func (ntx *NettingTransaction) Serialize() []byte {
+	nettingAsJson, _ := json.Marshal(ntx)
+	return nettingAsJson
+}
+
+func (ntx *NettingTransaction) Hash() []byte {
+	h := sha256.New()
+	h.Write(ntx.Serialize())
+	return h.Sum(nil)
+}
+
+// SignedBy lists the base64 (URL encoding) keys with a valid signature.
+func (ntx *NettingTransaction) SignedBy() map[string]bool {
+	signers := make(map[string]bool)
+	msg := ntx.SigningBytes()
+	for _, script := range ntx.ScriptSigs {
+		if script.Verify(msg) {
+			signers[base64.URLEncoding.EncodeToString(script.PublicKey)] = true
+		}
+	}
+	return signers
+}
+
+/*
+	NetObligations computes every participant's net position in each asset and settles it
+	with at most one fewer obligations than there are participants with a
+	non-zero position: the largest remaining debtor always pays the largest
+	remaining creditor. Ties are broken by key so every node arrives at the
+	same result. Positions are signed, so an amount or position beyond
+	math.MaxInt64 fails with ErrNettingOverflow.
+*/
+func NetObligations(obligations []Obligation) ([]Obligation, error) {
+	// obligations in different assets are netted separately
+	byAsset := make(map[AssetId][]Obligation)
+	var assets []AssetId
+	for _, o := range obligations {
+		if o.Amount > math.MaxInt64 {
+			return nil, ErrNettingOverflow
+		}
+		if _, seen := byAsset[o.Asset]; !seen {
+			assets = append(assets, o.Asset)
+		}
+		byAsset[o.Asset] = append(byAsset[o.Asset], o)
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i] < assets[j] })
+
+	var netted []Obligation
+	for _, asset := range assets {
+		byParticipant, err := netAsset(asset, byAsset[asset])
+		if err != nil {
+			return nil, err
+		}
+		netted = append(netted, byParticipant...)
+	}
+	return netted, nil
+}
+
+func netAsset(asset AssetId, obligations []Obligation) ([]Obligation, error) {
+	// positive balances are owed to the participant, negative ones owed by it;
+	// math.MinInt64 is left out so every balance can be negated
+	balances := make(map[string]int64)
+	for _, o := range obligations {
+		debtor := base64.URLEncoding.EncodeToString(o.Debtor)
+		creditor := base64.URLEncoding.EncodeToString(o.Creditor)
+		amount := int64(o.Amount)
+		if balances[debtor] < -math.MaxInt64 + amount || balances[creditor] > math.MaxInt64 - amount {
+			return nil, ErrNettingOverflow
+		}
+		balances[debtor] = balances[debtor] - amount
+		balances[creditor] = balances[creditor] + amount
+	}
+
+	var debtors, creditors []string
+	for participant, balance := range balances {
+		if balance < 0 {
+			debtors = append(debtors, participant)
+		} else if balance > 0 {
+			creditors = append(creditors, participant)
+		}
+	}
+	byPosition := func(participants []string) {
+		sort.Slice(participants, func(i, j int) bool {
+			bi, bj := abs(balances[participants[i]]), abs(balances[participants[j]])
+			if bi != bj {
+				return bi > bj
+			}
+			return participants[i] < participants[j]
+		})
+	}
+
+	var netted []Obligation
+	for len(debtors) > 0 && len(creditors) > 0 {
+		byPosition(debtors)
+		byPosition(creditors)
+		debtor, creditor := debtors[0], creditors[0]
+
+		amount := -balances[debtor]
+		if balances[creditor] < amount {
+			amount = balances[creditor]
+		}
+		debtorKey, _ := base64.URLEncoding.DecodeString(debtor)
+		creditorKey, _ := base64.URLEncoding.DecodeString(creditor)
+		netted = append(netted, Obligation{Debtor: debtorKey, Creditor: creditorKey, Amount: uint64(amount), Asset: asset})
+
+		balances[debtor] = balances[debtor] + amount
+		balances[creditor] = balances[creditor] - amount
+		if balances[debtor] == 0 {
+			debtors = debtors[1:]
+		}
+		if balances[creditor] == 0 {
+			creditors = creditors[1:]
+		}
+	}
+	return netted, nil
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
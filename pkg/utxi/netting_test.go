@@ -0,0 +1,132 @@
+package utxi
+
+import (
+	"math"
+	"testing"
+)
+
+var (
+	alice	= []byte("alice")
+	bob	= []byte("bob")
+	carol	= []byte("carol")
+	dave	= []byte("dave")
+)
+
+// positions sums what each participant is owed, less what it owes, per asset.
+func positions(obligations []Obligation) map[AssetId]map[string]int64 {
+	balances := make(map[AssetId]map[string]int64)
+	for _, o := range obligations {
+		if balances[o.Asset] == nil {
+			balances[o.Asset] = make(map[string]int64)
+		}
+		balances[o.Asset][string(o.Debtor)] -= int64(o.Amount)
+		balances[o.Asset][string(o.Creditor)] += int64(o.Amount)
+	}
+	for _, byParticipant := range balances {
+		for participant, balance := range byParticipant {
+			if balance == 0 {
+				delete(byParticipant, participant)
+			}
+		}
+	}
+	return balances
+}
+
+func samePositions(a, b map[AssetId]map[string]int64) bool {
+	for asset := range a {
+		if len(a[asset]) != len(b[asset]) {
+			return false
+		}
+		for participant, balance := range a[asset] {
+			if b[asset][participant] != balance {
+				return false
+			}
+		}
+	}
+	for asset := range b {
+		if len(b[asset]) != 0 && len(a[asset]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNetObligations(t *testing.T) {
+	cases := []struct {
+		name		string
+		obligations	[]Obligation
+		// most obligations the netting may leave
+		max		int
+	}{
+		{"cycle", []Obligation{{alice, bob, 10, ""}, {bob, carol, 10, ""}, {carol, alice, 10, ""}}, 0},
+		{"chain", []Obligation{{alice, bob, 10, ""}, {bob, carol, 10, ""}}, 1},
+		{"offsetting", []Obligation{{alice, bob, 30, ""}, {bob, alice, 12, ""}}, 1},
+		{"many to one", []Obligation{{alice, dave, 5, ""}, {bob, dave, 7, ""}, {carol, dave, 9, ""}, {dave, alice, 5, ""}}, 2},
+		{"dense", []Obligation{{alice, bob, 40, ""}, {bob, carol, 25, ""}, {carol, dave, 60, ""}, {dave, alice, 10, ""}, {bob, dave, 5, ""}, {carol, alice, 3, ""}}, 3},
+		{"per asset", []Obligation{{alice, bob, 10, ""}, {bob, alice, 10, "usd"}}, 2},
+	}
+	for _, tc := range cases {
+		netted, err := NetObligations(tc.obligations)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if len(netted) > tc.max {
+			t.Errorf("%v: %v obligations left, want at most %v", tc.name, len(netted), tc.max)
+		}
+		if !samePositions(positions(netted), positions(tc.obligations)) {
+			t.Errorf("%v: netting changed the positions: %v", tc.name, netted)
+		}
+		for _, o := range netted {
+			if o.Amount == 0 {
+				t.Errorf("%v: netting left an empty obligation", tc.name)
+			}
+		}
+	}
+}
+
+func TestNetObligationsDeterministic(t *testing.T) {
+	obligations := []Obligation{{alice, bob, 10, ""}, {carol, dave, 10, ""}, {bob, carol, 4, "usd"}, {dave, alice, 4, "usd"}}
+	reversed := make([]Obligation, len(obligations))
+	for i, o := range obligations {
+		reversed[len(obligations) - 1 - i] = o
+	}
+	first, err := NetObligations(obligations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NetObligations(reversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != len(second) {
+		t.Fatalf("netted to %v and %v", first, second)
+	}
+	for i := range first {
+		if string(first[i].Debtor) != string(second[i].Debtor) || string(first[i].Creditor) != string(second[i].Creditor) || first[i].Amount != second[i].Amount || first[i].Asset != second[i].Asset {
+			t.Errorf("obligation %v: %v and %v", i, first[i], second[i])
+		}
+	}
+}
+
+func TestNetObligationsOverflow(t *testing.T) {
+	cases := []struct {
+		name		string
+		obligations	[]Obligation
+	}{
+		{"amount beyond int64", []Obligation{{alice, bob, math.MaxInt64 + 1, ""}, {bob, alice, 1, ""}}},
+		{"largest amount", []Obligation{{alice, bob, math.MaxUint64, ""}, {bob, carol, 1, ""}}},
+		{"creditor position", []Obligation{{alice, bob, math.MaxInt64, ""}, {carol, bob, 1, ""}}},
+		{"debtor position", []Obligation{{alice, bob, math.MaxInt64, ""}, {alice, carol, 1, ""}}},
+	}
+	for _, tc := range cases {
+		if netted, err := NetObligations(tc.obligations); err != ErrNettingOverflow {
+			t.Errorf("%v: netted to %v, %v", tc.name, netted, err)
+		}
+	}
+
+	// positions up to math.MaxInt64 still net
+	netted, err := NetObligations([]Obligation{{alice, bob, math.MaxInt64, ""}, {bob, carol, math.MaxInt64, ""}})
+	if err != nil || len(netted) != 1 || netted[0].Amount != math.MaxInt64 {
+		t.Errorf("netted to %v, %v", netted, err)
+	}
+}
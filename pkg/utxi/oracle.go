@@ -0,0 +1,98 @@
+package utxi
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"sort"
+)
+
+/*
+	An Appraisal is a registered oracle's signed valuation of a piece of
+	collateral, e.g. a property appraisal or a house price index update
+	applied to it. The node keeps the latest appraisal from every oracle and
+	values the collateral at their median.
+*/
+type Appraisal struct {
+	Collateral		string
+	Value			uint64
+	// unix seconds at which the value was observed
+	Time			int64
+	ScriptSig		UnLockingScript
+}
+
+func (ap *Appraisal) SigningBytes() []byte {
+	unsigned := *ap
+	unsigned.ScriptSig = UnLockingScript{}
+	appraisalAsJson, _ := json.Marshal(unsigned)
+	return appraisalAsJson
+}
+
+func (ap *Appraisal) Serialize() []byte {
+	appraisalAsJson, _ := json.Marshal(ap)
+	return appraisalAsJson
+}
+
+func (ap *Appraisal) Hash() []byte {
+	h := sha256.New()
+	h.Write(ap.Serialize())
+	return h.Sum(nil)
+}
+
+func (ap *Appraisal) Oracle() []byte {
+	return ap.ScriptSig.PublicKey
+}
+
+func (ap *Appraisal) IsAppraisalValid() bool {
+	if ap.Collateral == "" || ap.Value == 0 || ap.Time <= 0 {
+		return false
+	}
+	return ap.ScriptSig.Verify(ap.SigningBytes())
+}
+
+// aggregated value of a piece of collateral
+type CollateralValue struct {
+	Collateral		string
+	Value			uint64
+	// number of appraisals the median was taken over
+	Appraisals		int
+	// time of the oldest appraisal used
+	Time			int64
+}
+
+// MedianAppraisal values the collateral at the median of the appraisals; with
+// an even count it takes the lower middle value.
+func MedianAppraisal(appraisals []Appraisal) CollateralValue {
+	if len(appraisals) == 0 {
+		return CollateralValue{}
+	}
+	values := make([]uint64, len(appraisals))
+	oldest := appraisals[0].Time
+	for i, ap := range appraisals {
+		values[i] = ap.Value
+		if ap.Time < oldest {
+			oldest = ap.Time
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return CollateralValue{
+		Collateral: appraisals[0].Collateral,
+		Value: values[(len(values) - 1) / 2],
+		Appraisals: len(appraisals),
+		Time: oldest,
+	}
+}
+
+// LoanToValueBps is debt over collateral value in basis points.
+func LoanToValueBps(debt, value uint64) uint64 {
+	if value == 0 {
+		return ^uint64(0)
+	}
+	ltv := new(big.Int).SetUint64(debt)
+	ltv.Mul(ltv, big.NewInt(10000))
+	ltv.Div(ltv, new(big.Int).SetUint64(value))
+	if !ltv.IsUint64() {
+		return ^uint64(0)
+	}
+	return ltv.Uint64()
+}
@@ -0,0 +1,53 @@
+package utxi
+
+import (
+	"testing"
+)
+
+func TestMedianAppraisal(t *testing.T) {
+	appraisals := func(values ...uint64) []Appraisal {
+		var aps []Appraisal
+		for i, value := range values {
+			aps = append(aps, Appraisal{Collateral: "house", Value: value, Time: int64(100 + i)})
+		}
+		return aps
+	}
+	cases := []struct {
+		values	[]uint64
+		median	uint64
+	}{
+		{[]uint64{500}, 500},
+		{[]uint64{300, 100, 200}, 200},
+		// the lower middle value of an even count
+		{[]uint64{400, 100, 300, 200}, 200},
+		{[]uint64{100, 100, 900}, 100},
+	}
+	for _, tc := range cases {
+		value := MedianAppraisal(appraisals(tc.values...))
+		if value.Value != tc.median || value.Appraisals != len(tc.values) || value.Time != 100 || value.Collateral != "house" {
+			t.Errorf("median of %v: %+v, want %v", tc.values, value, tc.median)
+		}
+	}
+	if value := MedianAppraisal(nil); value.Appraisals != 0 {
+		t.Errorf("median of nothing: %+v", value)
+	}
+}
+
+func TestLoanToValueBps(t *testing.T) {
+	cases := []struct {
+		debt, value, ltv	uint64
+	}{
+		{80, 100, 8000},
+		{1, 3, 3333},
+		{0, 100, 0},
+		{100, 0, ^uint64(0)},
+		// debt * 10000 does not fit in a uint64
+		{^uint64(0), 1, ^uint64(0)},
+		{^uint64(0), ^uint64(0), 10000},
+	}
+	for _, tc := range cases {
+		if ltv := LoanToValueBps(tc.debt, tc.value); ltv != tc.ltv {
+			t.Errorf("%v over %v: %v bps, want %v", tc.debt, tc.value, ltv, tc.ltv)
+		}
+	}
+}
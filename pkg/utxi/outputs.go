@@ -13,8 +13,25 @@ type LockingScript struct {
 }
 
 type TxOutput struct {
+	// zero for confidential outputs
 	Value				uint64
+	// NativeAsset if empty
+	Asset				AssetId				`json:",omitempty"`
 	SciptPubKey			LockingScript
+	Confidential		*ConfidentialValue	`json:",omitempty"`
+	// OutputMemo sealed to the recipient's view key
+	Memo				[]byte				`json:",omitempty"`
+	// ephemeral public key from which the recipient recovers a stealth address
+	Ephemeral			[]byte				`json:",omitempty"`
+	// the spend keys a stealth debt output may pay, for KYC
+	StealthProof		*StealthProof		`json:",omitempty"`
+}
+
+// an output recorded on chain, as served to wallets scanning the chain
+type RecordedOutput struct {
+	Txid			[]byte
+	Vout			int64
+	Output			TxOutput
 }
 
 func ConstructOutput(address []byte, value uint64) TxOutput {
@@ -22,11 +39,50 @@ func ConstructOutput(address []byte, value uint64) TxOutput {
 	lockscript := LockingScript{address}
 
 	return TxOutput{
-		value,
-		lockscript,
+		Value: value,
+		SciptPubKey: lockscript,
+	}
+}
+
+func ConstructConfidentialOutput(address []byte, value ConfidentialValue) TxOutput {
+	return TxOutput{
+		SciptPubKey: LockingScript{address},
+		Confidential: &value,
 	}
 }
 
+func (tx *TxOutput) IsConfidential() bool {
+	return tx.Confidential != nil
+}
+
+func (tx *TxOutput) IsStealth() bool {
+	return len(tx.Ephemeral) > 0
+}
+
+// IsOutputValid checks that confidential outputs hide their value behind a
+// valid range proof and that stealth outputs pay to a public key, with a
+// valid StealthProof if they carry one. Memos
+// and ephemeral keys are stored with the output, so their size is bounded.
+func (tx *TxOutput) IsOutputValid() bool {
+	if len(tx.Memo) > MaxOutputMemoSize {
+		return false
+	}
+	if tx.IsStealth() && !isStealthOutputValid(tx) {
+		return false
+	}
+	if !tx.IsStealth() && tx.StealthProof != nil {
+		return false
+	}
+	if !tx.IsConfidential() {
+		return true
+	}
+	// commitments of different assets cannot be summed
+	if tx.Asset != NativeAsset {
+		return false
+	}
+	return tx.Value == 0 && tx.Confidential.IsValueValid() && tx.Confidential.IsAuditOpeningWellFormed()
+}
+
 func (tx *TxOutput) Serialize() []byte {
 	txAsJson, _ := json.Marshal(tx)
 	return txAsJson
@@ -55,7 +111,15 @@ func (tx *TxOutput) RecipientAddrStr() string {
 func (txo TxOutput) String() string {
 	var output strings.Builder
 	output.WriteString("Value:    ")
-	output.WriteString(strconv.Itoa(int(txo.Value)))
+	if txo.IsConfidential() {
+		output.WriteString("confidential")
+	} else {
+		output.WriteString(strconv.Itoa(int(txo.Value)))
+	}
+	if txo.Asset != NativeAsset {
+		output.WriteString(" ")
+		output.WriteString(string(txo.Asset))
+	}
 	output.WriteString("\n")
 	output.WriteString("ScriptPubKey:    ")
 	output.WriteString(txo.RecipientAddrStr())
@@ -0,0 +1,246 @@
+package utxi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+)
+
+/*
+	A tranche of a securitization pool. Tokens are denominated in units of
+	face value: holding n tokens entitles the holder to n of the tranche's
+	Face, paid out of repayments into the pool.
+*/
+type Tranche struct {
+	Name			string
+	Face			uint64
+	Paid			uint64
+	// token balances keyed by base64 (URL encoding) holder public key
+	Holders			map[string]uint64
+}
+
+func (tr *Tranche) Outstanding() uint64 {
+	if tr.Paid >= tr.Face {
+		return 0
+	}
+	return tr.Face - tr.Paid
+}
+
+// isNew checks a tranche as created: nothing paid yet, and every token of
+// the face value held by someone.
+func (tr *Tranche) isNew() bool {
+	if tr.Face == 0 || tr.Paid != 0 {
+		return false
+	}
+	var tokens uint64
+	for _, held := range tr.Holders {
+		if tokens + held < tokens {
+			return false
+		}
+		tokens = tokens + held
+	}
+	return tokens == tr.Face
+}
+
+/*
+	LoanPool bundles outstanding loans of a single originator and issues
+	tranches against them. Tranches are listed in waterfall order, senior first.
+*/
+type LoanPool struct {
+	// hash of the signed contents, assigned by the node when the pool is created
+	Id				[]byte		`json:",omitempty"`
+	Loans			[][]byte
+	Tranches		[]Tranche
+	// signed by the originator, who must be the lender of every pooled loan
+	ScriptSig		UnLockingScript
+}
+
+// NewLoanPool assigns every tranche's tokens to the originator.
+func NewLoanPool(originator []byte, loans [][]byte, tranches []Tranche) LoanPool {
+	holder := base64.URLEncoding.EncodeToString(originator)
+	for i := range tranches {
+		tranches[i].Paid = 0
+		tranches[i].Holders = map[string]uint64{holder: tranches[i].Face}
+	}
+	return LoanPool{Loans: loans, Tranches: tranches}
+}
+
+// SigningBytes is the message the originator signs; it leaves out Id and ScriptSig.
+func (pool *LoanPool) SigningBytes() []byte {
+	unsigned := *pool
+	unsigned.Id = nil
+	unsigned.ScriptSig = UnLockingScript{}
+	poolAsJson, _ := json.Marshal(unsigned)
+	return poolAsJson
+}
+
+// Hash covers the signed contents, so it only identifies the pool until
+// repayments or transfers change the tranches; use Id after creation.
+func (pool *LoanPool) Hash() []byte {
+	h := sha256.New()
+	h.Write(pool.SigningBytes())
+	return h.Sum(nil)
+}
+
+func (pool *LoanPool) Originator() []byte {
+	return pool.ScriptSig.PublicKey
+}
+
+func (pool *LoanPool) Serialize() []byte {
+	poolAsJson, _ := json.Marshal(pool)
+	return poolAsJson
+}
+
+func (pool *LoanPool) IsPoolValid() bool {
+	if len(pool.Loans) == 0 || len(pool.Tranches) == 0 {
+		return false
+	}
+	for _, tranche := range pool.Tranches {
+		if !tranche.isNew() {
+			return false
+		}
+	}
+	return pool.ScriptSig.Verify(pool.SigningBytes())
+}
+
+/*
+	Distribute pays amount through the tranches in waterfall order. Each
+	tranche is paid up to its outstanding face before the next one receives
+	anything, and a tranche's payment is split between holders in proportion
+	to their tokens. Whatever is left once every tranche is paid off goes to
+	the originator. The returned outputs are ordered deterministically.
+*/
+func (pool *LoanPool) Distribute(amount uint64) []TxOutput {
+	var outputs []TxOutput
+	remaining := amount
+
+	for i := range pool.Tranches {
+		tranche := &pool.Tranches[i]
+		if remaining == 0 {
+			break
+		}
+		payment := tranche.Outstanding()
+		if payment > remaining {
+			payment = remaining
+		}
+		if payment == 0 {
+			continue
+		}
+		tranche.Paid = tranche.Paid + payment
+		remaining = remaining - payment
+		outputs = append(outputs, tranche.split(payment)...)
+	}
+
+	if remaining > 0 {
+		outputs = append(outputs, ConstructOutput(pool.Originator(), remaining))
+	}
+	return outputs
+}
+
+// split divides payment pro rata over the holders; the rounding remainder
+// goes to the first holder in key order.
+func (tr *Tranche) split(payment uint64) []TxOutput {
+	holders := make([]string, 0, len(tr.Holders))
+	var totalTokens uint64
+	for holder, tokens := range tr.Holders {
+		if tokens == 0 {
+			continue
+		}
+		holders = append(holders, holder)
+		totalTokens = totalTokens + tokens
+	}
+	sort.Strings(holders)
+	if totalTokens == 0 {
+		return nil
+	}
+
+	shares := make([]uint64, len(holders))
+	var distributed uint64
+	for i, holder := range holders {
+		// payment * tokens can overflow a uint64
+		share := new(big.Int).SetUint64(payment)
+		share.Mul(share, new(big.Int).SetUint64(tr.Holders[holder]))
+		share.Div(share, new(big.Int).SetUint64(totalTokens))
+		shares[i] = share.Uint64()
+		distributed = distributed + shares[i]
+	}
+	if len(shares) > 0 {
+		shares[0] = shares[0] + payment - distributed
+	}
+
+	var outputs []TxOutput
+	for i, holder := range holders {
+		if shares[i] == 0 {
+			continue
+		}
+		address, _ := base64.URLEncoding.DecodeString(holder)
+		outputs = append(outputs, ConstructOutput(address, shares[i]))
+	}
+	return outputs
+}
+
+/*
+	Moves tranche tokens between holders. Signed by the sending holder; the
+	nonce tells apart transfers of the same amount, and the node applies
+	each signed transfer once.
+*/
+type TrancheTransfer struct {
+	Pool			[]byte
+	Tranche			int
+	To				[]byte
+	Amount			uint64
+	Nonce			uint64
+	ScriptSig		UnLockingScript
+}
+
+func (transfer *TrancheTransfer) SigningBytes() []byte {
+	unsigned := *transfer
+	unsigned.ScriptSig = UnLockingScript{}
+	transferAsJson, _ := json.Marshal(unsigned)
+	return transferAsJson
+}
+
+// Id names the transfer by what was signed.
+func (transfer *TrancheTransfer) Id() []byte {
+	h := sha256.New()
+	h.Write(transfer.SigningBytes())
+	return h.Sum(nil)
+}
+
+func (transfer *TrancheTransfer) Serialize() []byte {
+	transferAsJson, _ := json.Marshal(transfer)
+	return transferAsJson
+}
+
+func (transfer *TrancheTransfer) IsTransferValid() bool {
+	if transfer.Amount == 0 || len(transfer.To) == 0 {
+		return false
+	}
+	return transfer.ScriptSig.Verify(transfer.SigningBytes())
+}
+
+// Apply moves the tokens in the pool; the pool is left untouched on error.
+func (pool *LoanPool) Apply(transfer TrancheTransfer) error {
+	if !bytes.Equal(pool.Id, transfer.Pool) {
+		return errors.New("transfer is for a different pool")
+	}
+	if transfer.Tranche < 0 || transfer.Tranche >= len(pool.Tranches) {
+		return errors.New("no such tranche")
+	}
+	tranche := &pool.Tranches[transfer.Tranche]
+	from := base64.URLEncoding.EncodeToString(transfer.ScriptSig.PublicKey)
+	to := base64.URLEncoding.EncodeToString(transfer.To)
+	if tranche.Holders[from] < transfer.Amount {
+		return errors.New("insufficient tranche tokens")
+	}
+	tranche.Holders[from] = tranche.Holders[from] - transfer.Amount
+	if tranche.Holders[from] == 0 {
+		delete(tranche.Holders, from)
+	}
+	tranche.Holders[to] = tranche.Holders[to] + transfer.Amount
+	return nil
+}
@@ -0,0 +1,209 @@
+package utxi
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+func newKey(t *testing.T) *btcec.PrivateKey {
+	t.Helper()
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func sign(t *testing.T, key *btcec.PrivateKey, msg []byte) UnLockingScript {
+	t.Helper()
+	digest := sha256.Sum256(msg)
+	r, s, err := ecdsa.Sign(rand.Reader, key.ToECDSA(), digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Cmp(HalfOrder) > 0 {
+		s.Sub(btcec.S256().N, s)
+	}
+	return UnLockingScript{PublicKey: key.PubKey().SerializeCompressed(), Signature: EcdsaSignature{R: r, S: s}}
+}
+
+func holder(key []byte) string {
+	return base64.URLEncoding.EncodeToString(key)
+}
+
+func newTestPool(t *testing.T, originator *btcec.PrivateKey, faces ...uint64) LoanPool {
+	t.Helper()
+	var tranches []Tranche
+	for _, face := range faces {
+		tranches = append(tranches, Tranche{Name: "tranche", Face: face})
+	}
+	pool := NewLoanPool(originator.PubKey().SerializeCompressed(), [][]byte{[]byte("loan")}, tranches)
+	pool.ScriptSig = sign(t, originator, pool.SigningBytes())
+	pool.Id = pool.Hash()
+	return pool
+}
+
+// paid sums the outputs paid to each address.
+func paid(outputs []TxOutput) map[string]uint64 {
+	byAddress := make(map[string]uint64)
+	for _, output := range outputs {
+		byAddress[holder(output.RecipientAddr())] += output.Value
+	}
+	return byAddress
+}
+
+func TestDistributeWaterfall(t *testing.T) {
+	originator := newKey(t)
+	origin := holder(originator.PubKey().SerializeCompressed())
+	senior, junior := newKey(t), newKey(t)
+	pool := newTestPool(t, originator, 100, 50)
+	pool.Tranches[0].Holders = map[string]uint64{holder(senior.PubKey().SerializeCompressed()): 100}
+	pool.Tranches[1].Holders = map[string]uint64{holder(junior.PubKey().SerializeCompressed()): 50}
+
+	payments := []struct {
+		amount		uint64
+		senior		uint64
+		junior		uint64
+		originator	uint64
+	}{
+		{60, 60, 0, 0},
+		{60, 40, 20, 0},
+		{45, 0, 30, 15},
+		{10, 0, 0, 10},
+	}
+	for i, p := range payments {
+		got := paid(pool.Distribute(p.amount))
+		if got[holder(senior.PubKey().SerializeCompressed())] != p.senior || got[holder(junior.PubKey().SerializeCompressed())] != p.junior || got[origin] != p.originator {
+			t.Errorf("payment %v: paid %v", i, got)
+		}
+	}
+	if pool.Tranches[0].Outstanding() != 0 || pool.Tranches[1].Outstanding() != 0 {
+		t.Error("tranches not paid off")
+	}
+}
+
+func TestDistributeProRata(t *testing.T) {
+	originator := newKey(t)
+	pool := newTestPool(t, originator, 3)
+	pool.Tranches[0].Holders = map[string]uint64{"AAAA": 1, "BBBB": 1, "CCCC": 1}
+
+	// 2 split three ways leaves the remainder with the first holder in key order
+	got := paid(pool.Distribute(2))
+	if len(got) != 1 || got["AAAA"] != 2 {
+		t.Errorf("paid %v, want the whole payment to the first holder", got)
+	}
+
+	pool = newTestPool(t, originator, 300)
+	pool.Tranches[0].Holders = map[string]uint64{"AAAA": 100, "BBBB": 200}
+	got = paid(pool.Distribute(150))
+	if got["AAAA"] != 50 || got["BBBB"] != 100 {
+		t.Errorf("paid %v, want 50 and 100", got)
+	}
+
+	// face times tokens does not fit in a uint64
+	pool = newTestPool(t, originator, ^uint64(0))
+	pool.Tranches[0].Holders = map[string]uint64{"AAAA": ^uint64(0) - 1, "BBBB": 1}
+	var total uint64
+	for _, amount := range paid(pool.Distribute(^uint64(0))) {
+		total += amount
+	}
+	if total != ^uint64(0) {
+		t.Errorf("distributed %v of %v", total, ^uint64(0))
+	}
+}
+
+func TestOutstanding(t *testing.T) {
+	tranche := Tranche{Face: 10, Paid: 12}
+	if tranche.Outstanding() != 0 {
+		t.Errorf("overpaid tranche has %v outstanding", tranche.Outstanding())
+	}
+}
+
+func TestIsPoolValid(t *testing.T) {
+	originator := newKey(t)
+	if pool := newTestPool(t, originator, 100, 50); !pool.IsPoolValid() {
+		t.Fatal("new pool is not valid")
+	}
+
+	invalid := []struct {
+		name	string
+		tamper	func(pool *LoanPool)
+	}{
+		{"paid tranche", func(pool *LoanPool) { pool.Tranches[0].Paid = 10 }},
+		{"tokens short of face", func(pool *LoanPool) { pool.Tranches[1].Face = 60 }},
+		{"overflowing tokens", func(pool *LoanPool) { pool.Tranches[0].Holders["other"] = ^uint64(0) - 99 }},
+		{"empty tranche", func(pool *LoanPool) { pool.Tranches[1] = Tranche{} }},
+		{"no loans", func(pool *LoanPool) { pool.Loans = nil }},
+		{"no tranches", func(pool *LoanPool) { pool.Tranches = nil }},
+	}
+	for _, tc := range invalid {
+		pool := newTestPool(t, originator, 100, 50)
+		tc.tamper(&pool)
+		pool.ScriptSig = sign(t, originator, pool.SigningBytes())
+		if pool.IsPoolValid() {
+			t.Errorf("%v: pool is valid", tc.name)
+		}
+	}
+
+	pool := newTestPool(t, originator, 100, 50)
+	pool.Tranches[0].Face = 90
+	pool.Tranches[0].Holders[holder(originator.PubKey().SerializeCompressed())] = 90
+	if pool.IsPoolValid() {
+		t.Error("pool changed after signing is valid")
+	}
+}
+
+func TestTrancheTransfer(t *testing.T) {
+	originator, buyer := newKey(t), newKey(t)
+	pool := newTestPool(t, originator, 100)
+	transfer := TrancheTransfer{Pool: pool.Id, To: buyer.PubKey().SerializeCompressed(), Amount: 40, Nonce: 1}
+	transfer.ScriptSig = sign(t, originator, transfer.SigningBytes())
+	if !transfer.IsTransferValid() {
+		t.Fatal("transfer is not valid")
+	}
+	if err := pool.Apply(transfer); err != nil {
+		t.Fatal(err)
+	}
+	holders := pool.Tranches[0].Holders
+	if holders[holder(originator.PubKey().SerializeCompressed())] != 60 || holders[holder(buyer.PubKey().SerializeCompressed())] != 40 {
+		t.Errorf("holders %v after transfer", holders)
+	}
+
+	again := transfer
+	again.Nonce = 2
+	if string(again.Id()) == string(transfer.Id()) {
+		t.Error("transfers with different nonces share an id")
+	}
+
+	tooMany := transfer
+	tooMany.Amount = 61
+	tooMany.ScriptSig = sign(t, originator, tooMany.SigningBytes())
+	if err := pool.Apply(tooMany); err == nil {
+		t.Error("transfer of more tokens than held applied")
+	}
+	if holders[holder(originator.PubKey().SerializeCompressed())] != 60 {
+		t.Error("failed transfer changed the holders")
+	}
+	otherPool := transfer
+	otherPool.Pool = []byte("other")
+	if err := pool.Apply(otherPool); err == nil {
+		t.Error("transfer for another pool applied")
+	}
+
+	highS := transfer
+	highS.ScriptSig.Signature.S = new(big.Int).Sub(btcec.S256().N, transfer.ScriptSig.Signature.S)
+	if highS.IsTransferValid() {
+		t.Error("high-S signature accepted")
+	}
+	tampered := transfer
+	tampered.Amount = 41
+	if tampered.IsTransferValid() {
+		t.Error("transfer changed after signing is valid")
+	}
+}
@@ -0,0 +1,15 @@
+package utxi
+
+type ProductType string
+
+const (
+	ProductReverseMortgage	ProductType = "reverse-mortgage"
+)
+
+/*
+	Attestations a borrower needs, besides KYC, before debt of a product type
+	is issued to them. Genesis app_state can replace the list for a product.
+*/
+var DefaultRequirements = map[ProductType][]AttestationKind{
+	ProductReverseMortgage: {AttestCounseling},
+}
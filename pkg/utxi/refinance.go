@@ -0,0 +1,57 @@
+package utxi
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+)
+
+/*
+	Refinance closes one or more loans between a lender and a borrower and
+	originates a single loan with new terms in their place. Principal covers
+	at least the outstanding debt of the closed loans; any excess is paid out
+	to the borrower. Both the lender and the borrower sign it.
+*/
+type Refinance struct {
+	Loans			[][]byte
+	Principal		uint64
+	Terms			LoanTerms
+	ScriptSigs		[]UnLockingScript
+}
+
+func (rf *Refinance) SigningBytes() []byte {
+	unsigned := *rf
+	unsigned.ScriptSigs = nil
+	refinanceAsJson, _ := json.Marshal(unsigned)
+	return refinanceAsJson
+}
+
+func (rf *Refinance) Serialize() []byte {
+	refinanceAsJson, _ := json.Marshal(rf)
+	return refinanceAsJson
+}
+
+func (rf *Refinance) Hash() []byte {
+	h := sha256.New()
+	h.Write(rf.Serialize())
+	return h.Sum(nil)
+}
+
+// IsRefinanceValid checks the new terms as issuance checks a debt's terms.
+func (rf *Refinance) IsRefinanceValid() bool {
+	if len(rf.Loans) == 0 || len(rf.ScriptSigs) < 2 {
+		return false
+	}
+	return rf.Terms.IsScheduleValid()
+}
+
+func (rf *Refinance) IsSignedBy(key []byte) bool {
+	msg := rf.SigningBytes()
+	keyStr := base64.URLEncoding.EncodeToString(key)
+	for _, script := range rf.ScriptSigs {
+		if base64.URLEncoding.EncodeToString(script.PublicKey) == keyStr && script.Verify(msg) {
+			return true
+		}
+	}
+	return false
+}
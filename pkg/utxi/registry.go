@@ -0,0 +1,148 @@
+package utxi
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+/*
+	Only registered lenders may originate debt, only registered attesters
+	may attest to borrowers, only registered oracles may appraise collateral
+	and only registered assets may be lent. The registry starts from the genesis app_state
+	and is changed afterwards by governance transactions signed by a
+	threshold of the governor keys held by the validators.
+*/
+
+type IssuerStatus uint8
+
+const (
+	IssuerActive	IssuerStatus = iota + 1
+	IssuerSuspended
+)
+
+type Issuer struct {
+	PublicKey		[]byte
+	Status			IssuerStatus
+}
+
+func (issuer *Issuer) IsActive() bool {
+	return issuer.Status == IssuerActive
+}
+
+type GovernanceAction string
+
+const (
+	AddIssuer		GovernanceAction = "AddIssuer"
+	SuspendIssuer	GovernanceAction = "SuspendIssuer"
+	RemoveIssuer	GovernanceAction = "RemoveIssuer"
+	AddAttester		GovernanceAction = "AddAttester"
+	RemoveAttester	GovernanceAction = "RemoveAttester"
+	AddOracle		GovernanceAction = "AddOracle"
+	RemoveOracle	GovernanceAction = "RemoveOracle"
+	// registers Asset; Key is its issuer
+	AddAsset		GovernanceAction = "AddAsset"
+	// rotate the governor keys; Threshold, if set, replaces the threshold
+	AddGovernor		GovernanceAction = "AddGovernor"
+	RemoveGovernor	GovernanceAction = "RemoveGovernor"
+)
+
+type Governance struct {
+	Action			GovernanceAction
+	// key the action applies to
+	Key				[]byte
+	// kind of attestation, for AddAttester and RemoveAttester
+	Kind			AttestationKind		`json:",omitempty"`
+	// metadata of the asset, for AddAsset
+	Asset			*Asset				`json:",omitempty"`
+	// governor signatures required once AddGovernor or RemoveGovernor is
+	// applied; 0 keeps the current threshold
+	Threshold		int					`json:",omitempty"`
+	// distinguishes repeated actions on the same key, e.g. re-adding an issuer
+	Nonce			uint64
+	ScriptSigs		[]UnLockingScript
+}
+
+func (gov *Governance) SigningBytes() []byte {
+	unsigned := *gov
+	unsigned.ScriptSigs = nil
+	governanceAsJson, _ := json.Marshal(unsigned)
+	return governanceAsJson
+}
+
+func (gov *Governance) Serialize() []byte {
+	governanceAsJson, _ := json.Marshal(gov)
+	return governanceAsJson
+}
+
+// Id names the action by what was signed, so adding or dropping a
+// signature does not make it a new one.
+func (gov *Governance) Id() []byte {
+	h := sha256.New()
+	h.Write(gov.SigningBytes())
+	return h.Sum(nil)
+}
+
+func (gov *Governance) Hash() []byte {
+	h := sha256.New()
+	h.Write(gov.Serialize())
+	return h.Sum(nil)
+}
+
+// SignedBy returns the keys with a valid signature, base64 URL encoded.
+func (gov *Governance) SignedBy() map[string]bool {
+	signers := make(map[string]bool)
+	msg := gov.SigningBytes()
+	for _, script := range gov.ScriptSigs {
+		if script.Verify(msg) {
+			signers[base64.URLEncoding.EncodeToString(script.PublicKey)] = true
+		}
+	}
+	return signers
+}
+
+func (gov *Governance) IsGovernanceValid() bool {
+	switch gov.Action {
+	case AddIssuer, SuspendIssuer, RemoveIssuer, AddOracle, RemoveOracle:
+	case AddAttester, RemoveAttester:
+		if gov.Kind == "" {
+			return false
+		}
+	case AddAsset:
+		if gov.Asset == nil || gov.Asset.Id == NativeAsset {
+			return false
+		}
+	case AddGovernor, RemoveGovernor:
+		if gov.Threshold < 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	_, err := btcec.ParsePubKey(gov.Key, btcec.S256())
+	return err == nil && len(gov.ScriptSigs) > 0
+}
+
+// Originator returns the lender recorded on a debt transaction's inputs.
+func (tx *Transaction) Originator() []byte {
+	return tx.Inputs[0].Txid
+}
+
+/*
+	IsSignedByOriginator checks that every debt input names the same lender
+	and is signed by it over the whole transaction, so amounts, asset, terms,
+	product, collateral and memo cannot be changed under the signature.
+*/
+func (tx *Transaction) IsSignedByOriginator() bool {
+	if len(tx.Inputs) == 0 || len(tx.Inputs) != len(tx.Outputs) {
+		return false
+	}
+	for _, input := range tx.Inputs {
+		if input.Vout != -2 || string(input.Txid) != string(tx.Originator()) {
+			return false
+		}
+	}
+	return tx.IsSignedBy(tx.Originator())
+}
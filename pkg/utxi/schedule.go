@@ -0,0 +1,167 @@
+package utxi
+
+import (
+	"math/big"
+)
+
+/*
+	Repayment schedules and delinquency. Due dates are unix times compared
+	against block times, so every node sees a loan fall behind in the same
+	block.
+*/
+
+const secondsPerDay = 24 * 60 * 60
+
+type Installment struct {
+	DueTime			int64
+	Amount			uint64
+}
+
+/*
+	Late fee charged once per missed installment when it is more than
+	GraceDays past due: Flat plus Bps basis points of the installment. Bps
+	is at most 10000, a fee of the whole installment.
+*/
+type LateFeeRule struct {
+	GraceDays		int64
+	Flat			uint64
+	Bps				uint64
+}
+
+const MaxLateFeeBps = 10000
+
+// Fee returns the fee for the installment, or false if it does not fit in
+// a uint64.
+func (rule LateFeeRule) Fee(installment Installment) (uint64, bool) {
+	// installment.Amount * rule.Bps can overflow a uint64
+	fee := new(big.Int).SetUint64(installment.Amount)
+	fee.Mul(fee, new(big.Int).SetUint64(rule.Bps))
+	fee.Div(fee, big.NewInt(10000))
+	fee.Add(fee, new(big.Int).SetUint64(rule.Flat))
+	if !fee.IsUint64() {
+		return 0, false
+	}
+	return fee.Uint64(), true
+}
+
+// days-past-due buckets
+const (
+	BucketCurrent	= "current"
+	Bucket1To29		= "1-29"
+	Bucket30To59	= "30-59"
+	Bucket60To89	= "60-89"
+	Bucket90Plus	= "90+"
+)
+
+func DelinquencyBucket(daysPastDue int64) string {
+	switch {
+	case daysPastDue <= 0:
+		return BucketCurrent
+	case daysPastDue < 30:
+		return Bucket1To29
+	case daysPastDue < 60:
+		return Bucket30To59
+	case daysPastDue < 90:
+		return Bucket60To89
+	}
+	return Bucket90Plus
+}
+
+type Delinquency struct {
+	DaysPastDue		int64
+	Bucket			string
+	// due time of the oldest installment not fully paid
+	Since			int64
+}
+
+/*
+	IsScheduleValid checks the installments are in due order and that the
+	whole schedule, with the late fee of every installment, fits in a
+	uint64, so assessing the loan cannot overflow.
+*/
+func (terms *LoanTerms) IsScheduleValid() bool {
+	if terms.LateFee.Bps > MaxLateFeeBps {
+		return false
+	}
+	var total uint64
+	for i, installment := range terms.Schedule {
+		if i > 0 && installment.DueTime <= terms.Schedule[i-1].DueTime {
+			return false
+		}
+		fee, ok := terms.LateFee.Fee(installment)
+		if !ok {
+			return false
+		}
+		for _, amount := range []uint64{installment.Amount, fee} {
+			if total + amount < total {
+				return false
+			}
+			total = total + amount
+		}
+	}
+	return true
+}
+
+/*
+	Assess brings the loan's delinquency up to date at block time now.
+	Payments are applied to installments in order. It returns the late fees
+	charged by this call and whether the delinquency bucket changed. A fee
+	that would overflow the loan's fees is not charged.
+*/
+func (loan *Loan) Assess(now int64) (uint64, bool) {
+	if loan.Terms == nil || loan.IsClosed() {
+		return 0, false
+	}
+	credited := loan.Repaid + loan.Forgiven
+	var scheduled, fees uint64
+	var oldest *Installment
+
+	for i, installment := range loan.Terms.Schedule {
+		if installment.DueTime > now {
+			break
+		}
+		if scheduled + installment.Amount < scheduled {
+			break
+		}
+		scheduled = scheduled + installment.Amount
+		if scheduled <= credited {
+			continue
+		}
+		if oldest == nil {
+			oldest = &loan.Terms.Schedule[i]
+		}
+		daysLate := (now - installment.DueTime) / secondsPerDay
+		if i >= loan.FeesAssessed && daysLate > loan.Terms.LateFee.GraceDays {
+			fee, ok := loan.Terms.LateFee.Fee(installment)
+			charged := loan.LateFees + fees
+			if ok && charged + fee >= charged {
+				fees = fees + fee
+			}
+			loan.FeesAssessed = i + 1
+		}
+	}
+	loan.LateFees = loan.LateFees + fees
+
+	previous := BucketCurrent
+	if loan.Delinquency != nil {
+		previous = loan.Delinquency.Bucket
+	}
+	if oldest == nil {
+		loan.Delinquency = nil
+	} else {
+		daysPastDue := (now - oldest.DueTime) / secondsPerDay
+		loan.Delinquency = &Delinquency{
+			DaysPastDue: daysPastDue,
+			Bucket: DelinquencyBucket(daysPastDue),
+			Since: oldest.DueTime,
+		}
+	}
+	return fees, loan.DelinquencyBucket() != previous
+}
+
+func (loan *Loan) DelinquencyBucket() string {
+	if loan.Delinquency == nil {
+		return BucketCurrent
+	}
+	return loan.Delinquency.Bucket
+}
@@ -0,0 +1,109 @@
+package utxi
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLateFee(t *testing.T) {
+	cases := []struct {
+		name		string
+		rule		LateFeeRule
+		amount		uint64
+		fee			uint64
+		ok			bool
+	}{
+		{"flat", LateFeeRule{Flat: 25}, 1000, 25, true},
+		{"basis points", LateFeeRule{Bps: 150}, 1000, 15, true},
+		{"both", LateFeeRule{Flat: 25, Bps: 150}, 1000, 40, true},
+		{"whole installment", LateFeeRule{Bps: MaxLateFeeBps}, math.MaxUint64, math.MaxUint64, true},
+		{"product beyond uint64", LateFeeRule{Bps: 5000}, math.MaxUint64, math.MaxUint64 / 2, true},
+		{"flat overflows", LateFeeRule{Flat: 1, Bps: MaxLateFeeBps}, math.MaxUint64, 0, false},
+	}
+	for _, tc := range cases {
+		fee, ok := tc.rule.Fee(Installment{Amount: tc.amount})
+		if fee != tc.fee || ok != tc.ok {
+			t.Errorf("%v: fee %v, %v; want %v, %v", tc.name, fee, ok, tc.fee, tc.ok)
+		}
+	}
+}
+
+func TestScheduleValid(t *testing.T) {
+	cases := []struct {
+		name		string
+		terms		LoanTerms
+		valid		bool
+	}{
+		{"no schedule", LoanTerms{}, true},
+		{"in order", LoanTerms{Schedule: []Installment{{1000, 50}, {2000, 50}}, LateFee: LateFeeRule{Flat: 5, Bps: 100}}, true},
+		{"out of order", LoanTerms{Schedule: []Installment{{2000, 50}, {1000, 50}}}, false},
+		{"same due time", LoanTerms{Schedule: []Installment{{1000, 50}, {1000, 50}}}, false},
+		{"fee above the installment", LoanTerms{Schedule: []Installment{{1000, 50}}, LateFee: LateFeeRule{Bps: MaxLateFeeBps + 1}}, false},
+		{"installments overflow", LoanTerms{Schedule: []Installment{{1000, math.MaxUint64}, {2000, 1}}}, false},
+		{"fees overflow", LoanTerms{Schedule: []Installment{{1000, math.MaxUint64 / 2}}, LateFee: LateFeeRule{Bps: MaxLateFeeBps, Flat: 2}}, false},
+		{"flat fee overflows", LoanTerms{Schedule: []Installment{{1000, 1}}, LateFee: LateFeeRule{Flat: math.MaxUint64}}, false},
+	}
+	for _, tc := range cases {
+		if valid := tc.terms.IsScheduleValid(); valid != tc.valid {
+			t.Errorf("%v: valid %v", tc.name, valid)
+		}
+	}
+}
+
+func TestDelinquencyBucket(t *testing.T) {
+	cases := []struct {
+		days		int64
+		bucket		string
+	}{
+		{-5, BucketCurrent},
+		{0, BucketCurrent},
+		{1, Bucket1To29},
+		{29, Bucket1To29},
+		{30, Bucket30To59},
+		{59, Bucket30To59},
+		{60, Bucket60To89},
+		{89, Bucket60To89},
+		{90, Bucket90Plus},
+		{400, Bucket90Plus},
+	}
+	for _, tc := range cases {
+		if bucket := DelinquencyBucket(tc.days); bucket != tc.bucket {
+			t.Errorf("%v days: bucket %v, want %v", tc.days, bucket, tc.bucket)
+		}
+	}
+}
+
+func TestAssess(t *testing.T) {
+	const due = 1000
+	loan := Loan{Terms: &LoanTerms{
+		Schedule: []Installment{{due, 100}, {due + 30 * secondsPerDay, 100}},
+		LateFee: LateFeeRule{GraceDays: 5, Flat: 10, Bps: 1000},
+	}}
+
+	steps := []struct {
+		name		string
+		now			int64
+		repaid		uint64
+		fees		uint64
+		changed		bool
+		bucket		string
+	}{
+		{"before the due date", due - 1, 0, 0, false, BucketCurrent},
+		{"within the grace period", due + 3 * secondsPerDay, 0, 0, true, Bucket1To29},
+		{"past the grace period", due + 6 * secondsPerDay, 0, 20, false, Bucket1To29},
+		{"fee charged once", due + 7 * secondsPerDay, 0, 0, false, Bucket1To29},
+		{"second installment missed", due + 40 * secondsPerDay, 0, 20, true, Bucket30To59},
+		{"first installment paid", due + 40 * secondsPerDay, 100, 0, true, Bucket1To29},
+		{"paid up", due + 40 * secondsPerDay, 200, 0, true, BucketCurrent},
+	}
+	for _, step := range steps {
+		loan.Repaid = step.repaid
+		fees, changed := loan.Assess(step.now)
+		if fees != step.fees || changed != step.changed || loan.DelinquencyBucket() != step.bucket {
+			t.Errorf("%v: fees %v, changed %v, bucket %v", step.name, fees, changed, loan.DelinquencyBucket())
+		}
+	}
+	if loan.LateFees != 40 || loan.FeesAssessed != 2 {
+		t.Errorf("late fees %v for %v installments", loan.LateFees, loan.FeesAssessed)
+	}
+}
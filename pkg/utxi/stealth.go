@@ -0,0 +1,300 @@
+package utxi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+/*
+	Stealth addresses. A borrower publishes a scan key and a spend key once;
+	a lender paying the borrower picks an ephemeral key r and pays to the
+	one-time address
+
+		P = SpendKey + H(r * ScanKey) * G
+
+	recording R = r * G on the output. The borrower recognises the output
+	from H(scan * R) and alone can sign for it with spend + H(scan * R).
+	Holding the scan key is enough to find payments but not to spend them.
+
+	Borrowers are attested under their spend key, never under a one-time
+	address. A stealth debt output carries a StealthProof that it pays one
+	of a ring of spend keys picked by the lender, without saying which, so
+	the node can check every key of the ring is attested.
+*/
+type StealthAddress struct {
+	// compressed secp256k1 public keys
+	ScanKey			[]byte
+	SpendKey		[]byte
+}
+
+func (sa StealthAddress) String() string {
+	return base64.URLEncoding.EncodeToString(append(append([]byte{}, sa.ScanKey...), sa.SpendKey...))
+}
+
+func ParseStealthAddress(s string) (StealthAddress, error) {
+	b, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return StealthAddress{}, err
+	}
+	if len(b) != 2 * btcec.PubKeyBytesLenCompressed {
+		return StealthAddress{}, errors.New("stealth address must hold two compressed public keys")
+	}
+	sa := StealthAddress{
+		ScanKey: b[:btcec.PubKeyBytesLenCompressed],
+		SpendKey: b[btcec.PubKeyBytesLenCompressed:],
+	}
+	if _, err := btcec.ParsePubKey(sa.ScanKey, btcec.S256()); err != nil {
+		return StealthAddress{}, err
+	}
+	if _, err := btcec.ParsePubKey(sa.SpendKey, btcec.S256()); err != nil {
+		return StealthAddress{}, err
+	}
+	return sa, nil
+}
+
+// stealthTweak hashes the ECDH shared point to a scalar.
+func stealthTweak(sharedX, sharedY *big.Int) *big.Int {
+	shared := btcec.PublicKey{Curve: btcec.S256(), X: sharedX, Y: sharedY}
+	h := sha256.Sum256(shared.SerializeCompressed())
+	tweak := new(big.Int).SetBytes(h[:])
+	return tweak.Mod(tweak, btcec.S256().N)
+}
+
+// oneTimeKey adds tweak * G to the spend key.
+func oneTimeKey(spendKey *btcec.PublicKey, tweak *big.Int) *btcec.PublicKey {
+	curve := btcec.S256()
+	tx, ty := curve.ScalarBaseMult(tweak.Bytes())
+	x, y := curve.Add(spendKey.X, spendKey.Y, tx, ty)
+	return &btcec.PublicKey{Curve: curve, X: x, Y: y}
+}
+
+// Derive picks a fresh ephemeral key and returns the one-time address and the
+// ephemeral public key to record with the output.
+func (sa StealthAddress) Derive() ([]byte, []byte, error) {
+	address, ephemeral, _, err := sa.derive()
+	return address, ephemeral, err
+}
+
+// derive also returns the tweak added to the spend key.
+func (sa StealthAddress) derive() ([]byte, []byte, *big.Int, error) {
+	scanKey, err := btcec.ParsePubKey(sa.ScanKey, btcec.S256())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	spendKey, err := btcec.ParsePubKey(sa.SpendKey, btcec.S256())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ephemeral, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sharedX, sharedY := btcec.S256().ScalarMult(scanKey.X, scanKey.Y, ephemeral.D.Bytes())
+	tweak := stealthTweak(sharedX, sharedY)
+	address := oneTimeKey(spendKey, tweak)
+	return address.SerializeCompressed(), ephemeral.PubKey().SerializeCompressed(), tweak, nil
+}
+
+/*
+	ConstructStealthOutput pays value to a fresh one-time address of to, with
+	a proof that it pays one of to.SpendKey and decoys. The decoys are spend
+	keys of other attested borrowers; without any the proof names the
+	borrower's spend key.
+*/
+func ConstructStealthOutput(to StealthAddress, value uint64, decoys [][]byte) (TxOutput, error) {
+	address, ephemeral, tweak, err := to.derive()
+	if err != nil {
+		return TxOutput{}, err
+	}
+	output := ConstructOutput(address, value)
+	output.Ephemeral = ephemeral
+	output.StealthProof, err = proveStealthRing(output, to.SpendKey, decoys, tweak)
+	if err != nil {
+		return TxOutput{}, err
+	}
+	return output, nil
+}
+
+// most spend keys a StealthProof may list
+const MaxStealthRing = 16
+
+/*
+	StealthProof is a ring signature, by the tweak t of the one-time address
+	P = S + t*G, over the keys P - S for every spend key S of the ring. Only
+	the lender who derived P knows the discrete log of one of them.
+*/
+type StealthProof struct {
+	// sorted compressed spend keys
+	Ring			[][]byte
+	Challenge		[]byte
+	Responses		[][]byte
+}
+
+// ringKeys returns P - S for every spend key S of the ring.
+func ringKeys(address []byte, ring [][]byte) ([]*big.Int, []*big.Int, error) {
+	px, py, err := parsePoint(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	xs, ys := make([]*big.Int, len(ring)), make([]*big.Int, len(ring))
+	for i, key := range ring {
+		sx, sy, err := parsePoint(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		xs[i], ys[i] = subtract(px, py, sx, sy)
+	}
+	return xs, ys, nil
+}
+
+func ringChallenge(message []byte, rx, ry *big.Int) *big.Int {
+	h := sha256.New()
+	h.Write(message)
+	h.Write(serializePoint(rx, ry))
+	return new(big.Int).Mod(new(big.Int).SetBytes(h.Sum(nil)), curve.N)
+}
+
+// ringMessage binds the proof to the output and the ring.
+func ringMessage(output TxOutput, ring [][]byte) []byte {
+	h := sha256.New()
+	h.Write(output.RecipientAddr())
+	h.Write(output.Ephemeral)
+	for _, key := range ring {
+		h.Write(key)
+	}
+	return h.Sum(nil)
+}
+
+func proveStealthRing(output TxOutput, spendKey []byte, decoys [][]byte, tweak *big.Int) (*StealthProof, error) {
+	ring := [][]byte{spendKey}
+	for _, decoy := range decoys {
+		if !bytes.Equal(decoy, spendKey) {
+			ring = append(ring, decoy)
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return bytes.Compare(ring[i], ring[j]) < 0 })
+	if len(ring) > MaxStealthRing {
+		return nil, errors.New("too many decoys")
+	}
+	signer := 0
+	for i, key := range ring {
+		if bytes.Equal(key, spendKey) {
+			signer = i
+		}
+	}
+	xs, ys, err := ringKeys(output.RecipientAddr(), ring)
+	if err != nil {
+		return nil, err
+	}
+	message := ringMessage(output, ring)
+
+	n := len(ring)
+	challenges := make([]*big.Int, n)
+	responses := make([]*big.Int, n)
+	u, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	ux, uy := mulG(u)
+	challenges[(signer + 1) % n] = ringChallenge(message, ux, uy)
+	for k := 1; k < n; k++ {
+		i := (signer + k) % n
+		responses[i], err = randomScalar()
+		if err != nil {
+			return nil, err
+		}
+		gx, gy := mulG(responses[i])
+		cx, cy := curve.ScalarMult(xs[i], ys[i], scalarBytes(challenges[i]))
+		rx, ry := curve.Add(gx, gy, cx, cy)
+		challenges[(i + 1) % n] = ringChallenge(message, rx, ry)
+	}
+	// u = s + c*t at the signer closes the ring
+	s := new(big.Int).Mul(tweak, challenges[signer])
+	responses[signer] = s.Sub(u, s).Mod(s, curve.N)
+
+	proof := &StealthProof{Ring: ring, Challenge: scalarBytes(challenges[0])}
+	for _, response := range responses {
+		proof.Responses = append(proof.Responses, scalarBytes(response))
+	}
+	return proof, nil
+}
+
+// Verify checks the proof for the output's one-time address.
+func (proof *StealthProof) Verify(output TxOutput) bool {
+	n := len(proof.Ring)
+	if n == 0 || n > MaxStealthRing || len(proof.Responses) != n {
+		return false
+	}
+	for i, key := range proof.Ring {
+		if len(key) != btcec.PubKeyBytesLenCompressed || bytes.Equal(key, output.RecipientAddr()) {
+			return false
+		}
+		if i > 0 && bytes.Compare(proof.Ring[i-1], key) >= 0 {
+			return false
+		}
+	}
+	xs, ys, err := ringKeys(output.RecipientAddr(), proof.Ring)
+	if err != nil {
+		return false
+	}
+	message := ringMessage(output, proof.Ring)
+	first := new(big.Int).SetBytes(proof.Challenge)
+	if first.Cmp(curve.N) >= 0 {
+		return false
+	}
+	challenge := first
+	for i := 0; i < n; i++ {
+		response := new(big.Int).SetBytes(proof.Responses[i])
+		if response.Cmp(curve.N) >= 0 {
+			return false
+		}
+		gx, gy := mulG(response)
+		cx, cy := curve.ScalarMult(xs[i], ys[i], scalarBytes(challenge))
+		rx, ry := curve.Add(gx, gy, cx, cy)
+		challenge = ringChallenge(message, rx, ry)
+	}
+	return challenge.Cmp(first) == 0
+}
+
+/*
+	StealthTweak checks whether a stealth output pays to the spend key whose
+	scan key is given, and returns the tweak to add to the spend private key
+	to sign for it.
+*/
+func StealthTweak(scanKey *btcec.PrivateKey, spendKey *btcec.PublicKey, output TxOutput) (*big.Int, bool) {
+	if !output.IsStealth() {
+		return nil, false
+	}
+	ephemeral, err := btcec.ParsePubKey(output.Ephemeral, btcec.S256())
+	if err != nil {
+		return nil, false
+	}
+	sharedX, sharedY := btcec.S256().ScalarMult(ephemeral.X, ephemeral.Y, scanKey.D.Bytes())
+	tweak := stealthTweak(sharedX, sharedY)
+	address := oneTimeKey(spendKey, tweak)
+	if string(address.SerializeCompressed()) != string(output.RecipientAddr()) {
+		return nil, false
+	}
+	return tweak, true
+}
+
+func isStealthOutputValid(output *TxOutput) bool {
+	// ephemeral keys are published compressed, see Derive
+	if len(output.Ephemeral) != btcec.PubKeyBytesLenCompressed {
+		return false
+	}
+	if _, err := btcec.ParsePubKey(output.Ephemeral, btcec.S256()); err != nil {
+		return false
+	}
+	_, err := btcec.ParsePubKey(output.RecipientAddr(), btcec.S256())
+	if err != nil {
+		return false
+	}
+	return output.StealthProof == nil || output.StealthProof.Verify(*output)
+}
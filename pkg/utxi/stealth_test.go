@@ -0,0 +1,165 @@
+package utxi
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+func newStealthAddress(t *testing.T) (StealthAddress, *btcec.PrivateKey, *btcec.PrivateKey) {
+	t.Helper()
+	scan, spend := newKey(t), newKey(t)
+	sa := StealthAddress{
+		ScanKey: scan.PubKey().SerializeCompressed(),
+		SpendKey: spend.PubKey().SerializeCompressed(),
+	}
+	return sa, scan, spend
+}
+
+func TestStealthOutputRecovered(t *testing.T) {
+	sa, scan, spend := newStealthAddress(t)
+	output, err := ConstructStealthOutput(sa, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !output.IsStealth() || !output.IsOutputValid() {
+		t.Fatal("stealth output is not valid")
+	}
+	tweak, ok := StealthTweak(scan, spend.PubKey(), output)
+	if !ok {
+		t.Fatal("scan key does not find the output")
+	}
+	// spend + tweak signs for the one-time address
+	d := new(big.Int).Add(spend.D, tweak)
+	d.Mod(d, btcec.S256().N)
+	oneTime, _ := btcec.PrivKeyFromBytes(btcec.S256(), d.Bytes())
+	if string(oneTime.PubKey().SerializeCompressed()) != string(output.RecipientAddr()) {
+		t.Error("tweaked spend key is not the one-time key")
+	}
+
+	if _, ok := StealthTweak(newKey(t), spend.PubKey(), output); ok {
+		t.Error("another scan key finds the output")
+	}
+	if _, ok := StealthTweak(scan, newKey(t).PubKey(), output); ok {
+		t.Error("output found for another spend key")
+	}
+	if _, ok := StealthTweak(scan, spend.PubKey(), ConstructOutput(output.RecipientAddr(), 100)); ok {
+		t.Error("output without an ephemeral key found")
+	}
+}
+
+func TestStealthAddressesUnlinkable(t *testing.T) {
+	sa, _, _ := newStealthAddress(t)
+	first, firstEphemeral, err := sa.Derive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, secondEphemeral, err := sa.Derive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) == string(second) || string(firstEphemeral) == string(secondEphemeral) {
+		t.Error("two derivations give the same address")
+	}
+	if string(first) == string(sa.SpendKey) || string(first) == string(sa.ScanKey) {
+		t.Error("one-time address is a published key")
+	}
+}
+
+func TestParseStealthAddress(t *testing.T) {
+	sa, _, _ := newStealthAddress(t)
+	parsed, err := ParseStealthAddress(sa.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(parsed.ScanKey) != string(sa.ScanKey) || string(parsed.SpendKey) != string(sa.SpendKey) {
+		t.Errorf("parsed %v, want %v", parsed, sa)
+	}
+	for _, s := range []string{"", "not base64!", StealthAddress{ScanKey: sa.ScanKey}.String(), StealthAddress{ScanKey: sa.ScanKey, SpendKey: make([]byte, 33)}.String()} {
+		if _, err := ParseStealthAddress(s); err == nil {
+			t.Errorf("parsed %q", s)
+		}
+	}
+}
+
+func TestStealthOutputEphemeral(t *testing.T) {
+	sa, _, _ := newStealthAddress(t)
+	output, err := ConstructStealthOutput(sa, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ephemeral, _ := btcec.ParsePubKey(output.Ephemeral, btcec.S256())
+
+	invalid := []struct {
+		name		string
+		ephemeral	[]byte
+	}{
+		{"uncompressed", ephemeral.SerializeUncompressed()},
+		{"truncated", output.Ephemeral[:32]},
+		{"not on the curve", append([]byte{0x02}, make([]byte, 32)...)},
+	}
+	for _, tc := range invalid {
+		tampered := output
+		tampered.Ephemeral = tc.ephemeral
+		if tampered.IsOutputValid() {
+			t.Errorf("%v ephemeral key accepted", tc.name)
+		}
+	}
+}
+
+func TestStealthProof(t *testing.T) {
+	sa, _, _ := newStealthAddress(t)
+	var decoys [][]byte
+	for i := 0; i < 3; i++ {
+		decoys = append(decoys, newKey(t).PubKey().SerializeCompressed())
+	}
+	output, err := ConstructStealthOutput(sa, 100, decoys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := output.StealthProof
+	if proof == nil || len(proof.Ring) != 4 || !proof.Verify(output) || !output.IsOutputValid() {
+		t.Fatalf("proof %+v does not verify", proof)
+	}
+
+	other, err := ConstructStealthOutput(sa, 100, decoys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outsider := newKey(t).PubKey().SerializeCompressed()
+	invalid := []struct {
+		name		string
+		change		func(output *TxOutput, proof *StealthProof)
+	}{
+		{"another output's proof", func(output *TxOutput, proof *StealthProof) { *proof = *other.StealthProof }},
+		{"borrower left out", func(output *TxOutput, proof *StealthProof) { proof.Ring[0] = outsider }},
+		{"ring shortened", func(output *TxOutput, proof *StealthProof) { proof.Ring, proof.Responses = proof.Ring[1:], proof.Responses[1:] }},
+		{"ring unsorted", func(output *TxOutput, proof *StealthProof) { proof.Ring[0], proof.Ring[1] = proof.Ring[1], proof.Ring[0] }},
+		{"response changed", func(output *TxOutput, proof *StealthProof) { proof.Responses[2] = scalarBytes(big.NewInt(1)) }},
+		{"response not reduced", func(output *TxOutput, proof *StealthProof) {
+			proof.Responses[0] = new(big.Int).Add(new(big.Int).SetBytes(proof.Responses[0]), btcec.S256().N).Bytes()
+		}},
+		{"address changed", func(output *TxOutput, proof *StealthProof) { output.SciptPubKey.PubKHash = other.RecipientAddr() }},
+		{"on a plain output", func(output *TxOutput, proof *StealthProof) { output.Ephemeral = nil }},
+	}
+	for _, tc := range invalid {
+		tampered := output
+		tamperedProof := *proof
+		tamperedProof.Ring = append([][]byte{}, proof.Ring...)
+		tamperedProof.Responses = append([][]byte{}, proof.Responses...)
+		tampered.StealthProof = &tamperedProof
+		tc.change(&tampered, &tamperedProof)
+		if tampered.IsOutputValid() {
+			t.Errorf("%v: output accepted", tc.name)
+		}
+	}
+
+	tooMany := make([][]byte, MaxStealthRing)
+	for i := range tooMany {
+		tooMany[i] = newKey(t).PubKey().SerializeCompressed()
+	}
+	if _, err := ConstructStealthOutput(sa, 100, tooMany); err == nil {
+		t.Error("ring above MaxStealthRing proven")
+	}
+}
@@ -14,6 +14,14 @@ import (
 type Transaction struct {
 	Inputs		[]TxInput
 	Outputs		[]TxOutput
+	// terms of the loan, only set on debt transactions
+	Terms		*LoanTerms	`json:",omitempty"`
+	// kind of loan, only set on debt transactions
+	Product		ProductType	`json:",omitempty"`
+	// id of the collateral securing the loan, valued by the oracles
+	Collateral	string		`json:",omitempty"`
+	// payment reference sealed to the recipient of the first output
+	Memo		[]byte		`json:",omitempty"`
 }
 
 func (tx Transaction) String() string {
@@ -43,12 +51,63 @@ func (tx Transaction) String() string {
 }
 
 func (tx *Transaction) IsTransactionValid() bool {
+	if (len(tx.Inputs) == 0) {
+		return false
+	}
 	if (len(tx.Inputs) != len(tx.Outputs)) {
 		return false
 	}
+	if (tx.Terms != nil && !tx.Terms.IsScheduleValid()) {
+		return false
+	}
+	if (!tx.IsMemoValid()) {
+		return false
+	}
+	// a loan is in a single asset
+	if (!tx.IsSingleAsset()) {
+		return false
+	}
+	for _, output := range tx.Outputs {
+		if (!output.IsOutputValid()) {
+			return false
+		}
+	}
+	// schedules are tracked against plaintext repayments
+	if (tx.Terms != nil && tx.IsConfidential()) {
+		return false
+	}
 	return true
 }
 
+// IsRepaymentValid checks a repayment on its own: one input naming the debt,
+// and one plaintext output or a confidential payment and remaining debt.
+func (tx *Transaction) IsRepaymentValid() bool {
+	if (len(tx.Inputs) != 1 || tx.Terms != nil) {
+		return false
+	}
+	if (len(tx.Outputs) != 1 && !(len(tx.Outputs) == 2 && tx.IsConfidential())) {
+		return false
+	}
+	if (!tx.IsMemoValid() || !tx.IsSingleAsset()) {
+		return false
+	}
+	for _, output := range tx.Outputs {
+		if (!output.IsOutputValid()) {
+			return false
+		}
+	}
+	return true
+}
+
+func (tx *Transaction) IsConfidential() bool {
+	for _, output := range tx.Outputs {
+		if output.IsConfidential() {
+			return true
+		}
+	}
+	return false
+}
+
 func (tx *Transaction) IsDebtTransaction() bool {
 	if len(tx.Inputs) > 1 {
 		return false
@@ -87,6 +146,32 @@ func MakeOutstandingDebtTx(tx Transaction) Transaction {
 	return odtx
 }
 
+// SigningBytes is the message the inputs sign: the transaction with every
+// ScriptSig left out.
+func (tx *Transaction) SigningBytes() []byte {
+	unsigned := *tx
+	unsigned.Inputs = make([]TxInput, len(tx.Inputs))
+	for i, input := range tx.Inputs {
+		input.ScriptSig = UnLockingScript{}
+		unsigned.Inputs[i] = input
+	}
+	return unsigned.Serialize()
+}
+
+// IsSignedBy checks that every input is signed by key over the signing bytes.
+func (tx *Transaction) IsSignedBy(key []byte) bool {
+	if len(tx.Inputs) == 0 {
+		return false
+	}
+	msg := tx.SigningBytes()
+	for _, input := range tx.Inputs {
+		if string(input.ScriptSig.PublicKey) != string(key) || !input.ScriptSig.Verify(msg) {
+			return false
+		}
+	}
+	return true
+}
+
 func (tx *Transaction) Serialize() []byte {
 	txAsJson, _ := json.Marshal(tx)
 	return txAsJson